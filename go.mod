@@ -8,15 +8,18 @@ require (
 	github.com/Jigsaw-Code/outline-sdk v0.0.7
 	github.com/crazy-max/xgo v0.26.0
 	github.com/eycorsican/go-tun2socks v1.16.11
+	github.com/hashicorp/yamux v0.1.2
+	github.com/shadowsocks/go-shadowsocks2 v0.1.5
+	golang.org/x/crypto v0.14.0
 	golang.org/x/mobile v0.0.0-20230906132913-2077a3224571
 	golang.org/x/net v0.17.0
 	golang.org/x/sys v0.13.0
+	lukechampine.com/blake3 v1.2.1
 )
 
 require (
-	github.com/shadowsocks/go-shadowsocks2 v0.1.5 // indirect
+	github.com/klauspost/cpuid/v2 v2.0.9 // indirect
 	github.com/songgao/water v0.0.0-20200317203138-2b4b6d7c09d8 // indirect
-	golang.org/x/crypto v0.14.0 // indirect
 	golang.org/x/mod v0.12.0 // indirect
 	golang.org/x/sync v0.3.0 // indirect
 	golang.org/x/tools v0.13.0 // indirect
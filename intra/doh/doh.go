@@ -467,6 +467,24 @@ func (t *transport) Query(q []byte) ([]byte, error) {
 	return response, err
 }
 
+// ipRefresher is implemented by the IPMaps that can refresh their addresses
+// after a network change.
+type ipRefresher interface {
+	Refresh()
+}
+
+// OnNetworkChanged discards the state that depends on the network: the server
+// addresses are refreshed, idle connections are closed and any servfail hangover ends.
+func (t *transport) OnNetworkChanged() {
+	if ips, ok := t.ips.(ipRefresher); ok {
+		ips.Refresh()
+	}
+	t.client.CloseIdleConnections()
+	t.hangoverLock.Lock()
+	t.hangoverExpiration = time.Time{}
+	t.hangoverLock.Unlock()
+}
+
 func (t *transport) GetURL() string {
 	return t.url
 }
@@ -27,6 +27,7 @@ import (
 	"net/url"
 	"reflect"
 	"testing"
+	"time"
 )
 
 var testURL = "https://dns.google/dns-query"
@@ -449,6 +450,26 @@ func TestDohIPConfirmDisconfirm(t *testing.T) {
 	}
 }
 
+// Check that a network change clears the confirmed IP and the servfail hangover.
+func TestOnNetworkChanged(t *testing.T) {
+	doh, _ := NewTransport(testURL, ips, nil, nil, nil)
+	transport := doh.(*transport)
+	ipset := transport.ips.Get(parsedURL.Hostname())
+	ipset.Confirm(net.ParseIP(ips[0]))
+	transport.hangoverExpiration = time.Now().Add(hangoverDuration)
+
+	transport.OnNetworkChanged()
+	if ip := ipset.Confirmed(); ip != nil {
+		t.Errorf("IP %s still confirmed after network change", ip)
+	}
+	if !transport.hangoverExpiration.IsZero() {
+		t.Error("Servfail hangover not cleared after network change")
+	}
+	if len(ipset.GetAll()) < len(ips) {
+		t.Errorf("Fallback IPs lost after network change: %v", ipset.GetAll())
+	}
+}
+
 type fakeListener struct {
 	Listener
 	summary *Summary
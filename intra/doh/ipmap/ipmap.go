@@ -19,10 +19,15 @@ import (
 	"math/rand"
 	"net"
 	"sync"
+	"time"
 
 	"github.com/eycorsican/go-tun2socks/common/log"
 )
 
+// Bounds the resolution of each hostname on Refresh, so that a broken network
+// doesn't leave the refresh running indefinitely.
+const refreshTimeout = 10 * time.Second
+
 // IPMap maps hostnames to IPSets.
 type IPMap interface {
 	// Get creates an IPSet for this hostname populated with the IPs
@@ -72,6 +77,34 @@ func (m *ipMap) Get(hostname string) *IPSet {
 	return s
 }
 
+// Refresh prepares the IPSets for a new network: confirmed addresses are
+// disconfirmed, and hostnames are resolved again in the background.
+// Previously known IPs are kept as fallbacks. Callers of an IPMap must
+// type-assert it, since Refresh is not part of the interface.
+func (m *ipMap) Refresh() {
+	m.RLock()
+	sets := make(map[string]*IPSet, len(m.m))
+	for hostname, s := range m.m {
+		sets[hostname] = s
+	}
+	m.RUnlock()
+
+	for _, s := range sets {
+		s.Lock()
+		s.confirmed = nil
+		s.Unlock()
+	}
+	// Refresh is called from the network change callbacks, which must not block on
+	// the resolution.
+	go func() {
+		for hostname, s := range sets {
+			ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
+			s.resolve(ctx, hostname)
+			cancel()
+		}
+	}()
+}
+
 // IPSet represents an unordered collection of IP addresses for a single host.
 // One IP can be marked as confirmed to be working correctly.
 type IPSet struct {
@@ -101,8 +134,13 @@ func (s *IPSet) add(ip net.IP) {
 // Add one or more IP addresses to the set.
 // The hostname can be a domain name or an IP address.
 func (s *IPSet) Add(hostname string) {
+	s.resolve(context.TODO(), hostname)
+}
+
+// resolve adds the IP addresses of `hostname` to the set, until `ctx` is done.
+func (s *IPSet) resolve(ctx context.Context, hostname string) {
 	// Don't hold the ipMap lock during blocking I/O.
-	resolved, err := s.r.LookupIPAddr(context.TODO(), hostname)
+	resolved, err := s.r.LookupIPAddr(ctx, hostname)
 	if err != nil {
 		log.Warnf("Failed to resolve %s: %v", hostname, err)
 	}
@@ -20,6 +20,7 @@ import (
 	"net"
 	"sync/atomic"
 	"testing"
+	"time"
 )
 
 // We use '.' at the end to make sure resolution treats it an inexistent root domain.
@@ -157,6 +158,70 @@ func TestDisconfirmMismatch(t *testing.T) {
 	}
 }
 
+func TestRefresh(t *testing.T) {
+	var dialCount int32
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(context context.Context, network, address string) (net.Conn, error) {
+			atomic.AddInt32(&dialCount, 1)
+			return nil, errors.New("Fake dialer")
+		},
+	}
+	m := NewIPMap(resolver)
+	s := m.Get(invalidDomain)
+	s.Add("192.0.2.1")
+	s.Confirm(net.ParseIP("192.0.2.1"))
+	before := atomic.LoadInt32(&dialCount)
+
+	m.(*ipMap).Refresh()
+	if s.Confirmed() != nil {
+		t.Error("Confirmed address should be cleared")
+	}
+	if ips := s.GetAll(); len(ips) != 1 || ips[0].String() != "192.0.2.1" {
+		t.Errorf("Previous addresses should be kept, got %v", ips)
+	}
+	// The hostname is resolved again in the background.
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&dialCount) == before && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if atomic.LoadInt32(&dialCount) == before {
+		t.Error("Hostname was not resolved again")
+	}
+}
+
+func TestRefreshDoesNotBlock(t *testing.T) {
+	var blocking int32
+	release := make(chan struct{})
+	defer close(release)
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			if atomic.LoadInt32(&blocking) != 0 {
+				select {
+				case <-release:
+				case <-ctx.Done():
+				}
+			}
+			return nil, errors.New("Fake dialer")
+		},
+	}
+	m := NewIPMap(resolver)
+	m.Get(invalidDomain)
+	atomic.StoreInt32(&blocking, 1)
+
+	done := make(chan struct{})
+	go func() {
+		m.(*ipMap).Refresh()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Refresh blocked on the resolution")
+	}
+}
+
 func TestResolver(t *testing.T) {
 	var dialCount int32
 	resolver := &net.Resolver{
@@ -93,6 +93,14 @@ func (sd *intraStreamDialer) SetDNS(dns doh.Transport) error {
 	return nil
 }
 
+// onNetworkChanged resets the state that was learned in the previous network.
+func (sd *intraStreamDialer) onNetworkChanged() {
+	sd.alwaysSplitHTTPS.Store(false)
+	if dns, ok := (*sd.dns.Load()).(networkChangeListener); ok {
+		dns.OnNetworkChanged()
+	}
+}
+
 func (sd *intraStreamDialer) dial(ctx context.Context, dest netip.AddrPort, stats *TCPSocketSummary) (transport.StreamConn, error) {
 	if dest.Port() == 443 {
 		if sd.alwaysSplitHTTPS.Load() {
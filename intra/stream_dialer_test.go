@@ -0,0 +1,50 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package intra
+
+import (
+	"net/netip"
+	"testing"
+)
+
+type networkChangeTransport struct {
+	fakeTransport
+	changes int
+}
+
+func (t *networkChangeTransport) OnNetworkChanged() {
+	t.changes++
+}
+
+func TestStreamDialerOnNetworkChanged(t *testing.T) {
+	dns := &networkChangeTransport{}
+	sd, err := newIntraStreamDialer(netip.MustParseAddrPort("192.0.2.53:53"), dns, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sd.alwaysSplitHTTPS.Store(true)
+
+	sd.onNetworkChanged()
+	if sd.alwaysSplitHTTPS.Load() {
+		t.Error("Split state not reset after network change")
+	}
+	if dns.changes != 1 {
+		t.Errorf("DNS transport notified %d times, want 1", dns.changes)
+	}
+
+	// Transports without network state are fine too.
+	sd.SetDNS(newFakeTransport(nil))
+	sd.onNetworkChanged()
+}
@@ -36,6 +36,11 @@ type Listener interface {
 	doh.Listener
 }
 
+// networkChangeListener is implemented by DNS transports that keep network state.
+type networkChangeListener interface {
+	OnNetworkChanged()
+}
+
 // Tunnel represents an Intra session.
 type Tunnel struct {
 	network.IPDevice
@@ -104,6 +109,14 @@ func (t *Tunnel) SetDNS(dns doh.Transport) {
 	t.sni.SetDNS(dns)
 }
 
+// OnNetworkChanged must be called when the device switches networks, so that the state
+// learned in the previous network doesn't cause failures in the new one.  The DoH server
+// addresses are resolved again, idle DoH connections are closed and the split retry state
+// is reset.
+func (t *Tunnel) OnNetworkChanged() {
+	t.sd.onNetworkChanged()
+}
+
 // Enable reporting of SNIs that resulted in connection failures, using the
 // Choir library for privacy-preserving error reports.  `file` is the path
 // that Choir should use to store its persistent state, `suffix` is the
@@ -15,6 +15,8 @@
 package outline
 
 import (
+	"errors"
+
 	"github.com/Jigsaw-Code/outline-sdk/transport"
 )
 
@@ -24,4 +26,20 @@ import (
 type Client struct {
 	transport.StreamDialer
 	transport.PacketListener
+	// FallbackPacketListener relays UDP over the proxy's streams. It's used instead of the
+	// PacketListener when the network doesn't support UDP. Nil if the proxy doesn't support it.
+	FallbackPacketListener transport.PacketListener
+	// NetworkChangeHandler, if not nil, is notified when the device switches networks.
+	NetworkChangeHandler NetworkChangeHandler
+}
+
+// NetworkChangeHandler is implemented by dialers that keep state about the network,
+// such as resolved addresses or pooled connections.
+type NetworkChangeHandler interface {
+	// OnNetworkChanged discards the state that depends on the previous network.
+	OnNetworkChanged()
 }
+
+// ErrProxyAuthentication is wrapped by the dialer errors when a proxy rejects the credentials,
+// so that the connectivity checks can tell them from network failures.
+var ErrProxyAuthentication = errors.New("proxy authentication failed")
@@ -0,0 +1,466 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline"
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/neterrors"
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Defaults of the [Checker] options.
+const (
+	defaultTCPTargetURL   = "http://example.com"
+	defaultUDPResolver    = "1.1.1.1:53"
+	defaultTCPTimeout     = 10 * time.Second
+	defaultUDPTimeout     = 1 * time.Second
+	defaultTCPMaxAttempts = 1
+	defaultUDPMaxAttempts = 5
+)
+
+// Checker checks whether a proxy can relay TCP and UDP traffic under the current network.
+// The TCP check sends an HTTP HEAD request to each target URL in turn, until one responds.
+// The UDP check sends a DNS query for a random name to all the resolvers, until one responds
+// with the query ID and question.
+//
+// The options are set with methods, so that they can be used from non-golang callers.
+// A Checker is safe for concurrent use.
+type Checker struct {
+	mu      sync.Mutex
+	options checkerOptions
+	// Cancels the checks in progress.
+	cancels map[*context.CancelFunc]struct{}
+}
+
+type checkerOptions struct {
+	tcpTargetURLs []string
+	udpResolvers  []net.Addr
+	// Target of the UDP payload probes. May be nil.
+	udpEchoServer net.Addr
+	udpQueryType  dnsmessage.Type
+	// Targets of the IPv6 checks.
+	ipv6TCPTargetURLs []string
+	ipv6UDPResolvers  []net.Addr
+	tcpTimeout        time.Duration
+	udpTimeout        time.Duration
+	tcpMaxAttempts    int
+	udpMaxAttempts    int
+}
+
+// NewChecker creates a [Checker] that requests http://example.com over TCP, and queries the
+// resolver at 1.1.1.1:53 over UDP, until the targets are replaced.
+func NewChecker() *Checker {
+	resolverAddr, _ := net.ResolveUDPAddr("udp", defaultUDPResolver)
+	ipv6ResolverAddr, _ := net.ResolveUDPAddr("udp", defaultIPv6UDPResolver)
+	return &Checker{
+		options: checkerOptions{
+			tcpTargetURLs:     []string{defaultTCPTargetURL},
+			udpResolvers:      []net.Addr{resolverAddr},
+			udpQueryType:      dnsmessage.TypeA,
+			ipv6TCPTargetURLs: []string{defaultIPv6TCPTargetURL},
+			ipv6UDPResolvers:  []net.Addr{ipv6ResolverAddr},
+			tcpTimeout:        defaultTCPTimeout,
+			udpTimeout:        defaultUDPTimeout,
+			tcpMaxAttempts:    defaultTCPMaxAttempts,
+			udpMaxAttempts:    defaultUDPMaxAttempts,
+		},
+		cancels: make(map[*context.CancelFunc]struct{}),
+	}
+}
+
+// SetTCPTargetURLs replaces the URLs of the TCP check with the comma-separated `urls`, which
+// must be of the form http://[host](:[port])(/[path]).
+func (c *Checker) SetTCPTargetURLs(urls string) error {
+	var targets []string
+	for _, url := range splitList(urls) {
+		if _, err := tcpTargetAddress(url); err != nil {
+			return err
+		}
+		targets = append(targets, url)
+	}
+	if len(targets) == 0 {
+		return errors.New("must provide at least one TCP target URL")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.options.tcpTargetURLs = targets
+	return nil
+}
+
+// SetUDPResolvers replaces the DNS resolvers of the UDP check with the comma-separated
+// `addresses`, which must be of the form ip:port. IPv6 addresses must be in brackets, as in
+// [2606:4700:4700::1111]:53.
+func (c *Checker) SetUDPResolvers(addresses string) error {
+	var resolvers []net.Addr
+	for _, address := range splitList(addresses) {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil || net.ParseIP(host) == nil {
+			return fmt.Errorf("invalid resolver address %q: must be of the form ip:port", address)
+		}
+		resolverAddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(host, port))
+		if err != nil {
+			return fmt.Errorf("invalid resolver address %q: %w", address, err)
+		}
+		resolvers = append(resolvers, resolverAddr)
+	}
+	if len(resolvers) == 0 {
+		return errors.New("must provide at least one UDP resolver")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.options.udpResolvers = resolvers
+	return nil
+}
+
+// SetUDPQueryType sets the type of the DNS queries of the UDP check, "A" or "AAAA", which
+// defaults to "A".
+func (c *Checker) SetUDPQueryType(queryType string) error {
+	var udpQueryType dnsmessage.Type
+	switch strings.ToUpper(queryType) {
+	case "A":
+		udpQueryType = dnsmessage.TypeA
+	case "AAAA":
+		udpQueryType = dnsmessage.TypeAAAA
+	default:
+		return fmt.Errorf("invalid DNS query type %q: must be A or AAAA", queryType)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.options.udpQueryType = udpQueryType
+	return nil
+}
+
+// SetTCPTimeoutMillis sets the timeout of each TCP attempt. Non-positive values select the default.
+func (c *Checker) SetTCPTimeoutMillis(millis int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.options.tcpTimeout = durationOrDefault(millis, defaultTCPTimeout)
+}
+
+// SetUDPTimeoutMillis sets the timeout of each UDP attempt. Non-positive values select the default.
+func (c *Checker) SetUDPTimeoutMillis(millis int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.options.udpTimeout = durationOrDefault(millis, defaultUDPTimeout)
+}
+
+// SetMaxAttempts sets the number of attempts of each TCP target URL, and of the UDP queries.
+// Non-positive values select the defaults.
+func (c *Checker) SetMaxAttempts(tcpAttempts, udpAttempts int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.options.tcpMaxAttempts, c.options.udpMaxAttempts = defaultTCPMaxAttempts, defaultUDPMaxAttempts
+	if tcpAttempts > 0 {
+		c.options.tcpMaxAttempts = tcpAttempts
+	}
+	if udpAttempts > 0 {
+		c.options.udpMaxAttempts = udpAttempts
+	}
+}
+
+// Cancel stops the checks in progress, which fail with [context.Canceled].
+func (c *Checker) Cancel() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for cancel := range c.cancels {
+		(*cancel)()
+	}
+}
+
+// withCancel returns a context derived from `ctx` that is also canceled by [Checker.Cancel],
+// and a copy of the checker options.
+func (c *Checker) withCancel(ctx context.Context) (context.Context, *checkerOptions, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cancels[&cancel] = struct{}{}
+	options := c.options
+	return ctx, &options, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		delete(c.cancels, &cancel)
+		cancel()
+	}
+}
+
+// CheckConnectivity determines whether the proxy can relay TCP and UDP traffic under the current
+// network. Parallelizes the execution of TCP and UDP checks, selects the appropriate error code
+// to return accounting for transient network failures.
+// Returns the legacy code of the failure, as in [neterrors.Error.LegacyCode], for the callers
+// of integer codes. Returns an error if an unexpected error ocurrs, or if `ctx` is done.
+func (c *Checker) CheckConnectivity(ctx context.Context, client *outline.Client) (neterrors.Error, error) {
+	err := c.Check(ctx, client)
+	platformErr := neterrors.FromError(err)
+	switch {
+	case platformErr == nil:
+		return neterrors.NoError, nil
+	case platformErr.Code == neterrors.Unexpected:
+		return neterrors.Unexpected, platformErr.Cause
+	default:
+		return platformErr.Code.LegacyCode(), nil
+	}
+}
+
+// Check is like [Checker.CheckConnectivity], and returns the failure as a
+// [*neterrors.PlatformError], caused by the error of the failed check, or nil on success.
+// Its code is not mapped to the legacy codes.
+// The code is [neterrors.Unexpected] if an unexpected error ocurrs, or if `ctx` is done.
+func (c *Checker) Check(ctx context.Context, client *outline.Client) error {
+	ctx, options, done := c.withCancel(ctx)
+	defer done()
+	// Start asynchronous UDP support check.
+	udpChan := make(chan error, 1)
+	go func() {
+		udpChan <- options.checkUDP(ctx, client)
+	}()
+	// Check whether the proxy is reachable and that the client is able to authenticate to the proxy
+	tcpErr := options.checkTCP(ctx, client)
+	if ctx.Err() != nil {
+		return neterrors.New(neterrors.Unexpected, ctx.Err())
+	}
+	if tcpErr != nil {
+		return tcpError(tcpErr)
+	}
+	udpErr := <-udpChan
+	if ctx.Err() != nil {
+		return neterrors.New(neterrors.Unexpected, ctx.Err())
+	}
+	if udpErr != nil {
+		return neterrors.New(neterrors.UDPConnectivity, udpErr)
+	}
+	return nil
+}
+
+// CheckTCP is like [Checker.Check], for proxies that only relay TCP traffic. Only the TCP
+// checks run through `dialer`.
+func (c *Checker) CheckTCP(ctx context.Context, dialer transport.StreamDialer) error {
+	ctx, options, done := c.withCancel(ctx)
+	defer done()
+	tcpErr := options.checkTCP(ctx, dialer)
+	if ctx.Err() != nil {
+		return neterrors.New(neterrors.Unexpected, ctx.Err())
+	}
+	if tcpErr != nil {
+		return tcpError(tcpErr)
+	}
+	return nil
+}
+
+// CheckUDPConnectivity determines whether the proxy represented by `listener` and the network
+// support UDP traffic by issuing DNS queries though the resolvers.
+// Returns nil on success or an error on failure.
+func (c *Checker) CheckUDPConnectivity(ctx context.Context, listener transport.PacketListener) error {
+	ctx, options, done := c.withCancel(ctx)
+	defer done()
+	return options.checkUDP(ctx, listener)
+}
+
+// CheckTCPConnectivity determines whether the proxy is reachable over TCP and validates the
+// client's authentication credentials by performing HTTP HEAD requests to the target URLs.
+// Returns nil on success, or the error of the last attempt.
+func (c *Checker) CheckTCPConnectivity(ctx context.Context, dialer transport.StreamDialer) error {
+	ctx, options, done := c.withCancel(ctx)
+	defer done()
+	return options.checkTCP(ctx, dialer)
+}
+
+func (c *checkerOptions) checkUDP(ctx context.Context, listener transport.PacketListener) error {
+	conn, err := listener.ListenPacket(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	defer unblockWhenDone(ctx, conn)()
+	buf := make([]byte, bufferLength)
+	// The responses to the queries of previous attempts are also accepted, since they may be late.
+	var queries []*dnsQuery
+	for attempt := 0; attempt < c.udpMaxAttempts && ctx.Err() == nil; attempt++ {
+		query, err := newDNSQuery(c.udpQueryType)
+		if err != nil {
+			return err
+		}
+		request, err := query.pack(0)
+		if err != nil {
+			return err
+		}
+		queries = append(queries, query)
+		conn.SetDeadline(time.Now().Add(c.udpTimeout))
+		for _, resolverAddr := range c.udpResolvers {
+			conn.WriteTo(request, resolverAddr)
+		}
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if n == 0 && err != nil {
+				break
+			}
+			// Ensure we got a response to one of our queries from a resolver.
+			if isResolver(addr, c.udpResolvers) && isResponse(queries, buf[:n]) {
+				return nil
+			}
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return errors.New("UDP connectivity check timed out")
+}
+
+func (c *checkerOptions) checkTCP(ctx context.Context, dialer transport.StreamDialer) error {
+	var err error
+	for _, targetURL := range c.tcpTargetURLs {
+		for attempt := 0; attempt < c.tcpMaxAttempts; attempt++ {
+			if err = checkTCPWithHTTP(ctx, dialer, targetURL, c.tcpTimeout); err == nil {
+				return nil
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+		}
+	}
+	return err
+}
+
+// checkTCPWithHTTP performs an HTTP HEAD request to `targetURL` through `dialer`. Returns nil on
+// success, error if `targetURL` is invalid, AuthenticationError or ReachabilityError on
+// connectivity failure, or ResponseError for failures that are unlikely to be caused by
+// invalid credentials.
+func checkTCPWithHTTP(ctx context.Context, dialer transport.StreamDialer, targetURL string, timeout time.Duration) error {
+	_, err := timeTCPWithHTTP(ctx, dialer, targetURL, timeout)
+	return err
+}
+
+// tcpTiming is the timing of a successful HTTP request through a proxy.
+type tcpTiming struct {
+	// Time to establish the connection through the proxy.
+	handshake time.Duration
+	// Time from the request write to the first byte of the response.
+	requestRTT time.Duration
+}
+
+// timeTCPWithHTTP is like checkTCPWithHTTP, and returns the timing of the request on success.
+func timeTCPWithHTTP(ctx context.Context, dialer transport.StreamDialer, targetURL string, timeout time.Duration) (tcpTiming, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequest("HEAD", targetURL, nil)
+	if err != nil {
+		return tcpTiming{}, err
+	}
+	targetAddr := req.Host
+	if !hasPort(targetAddr) {
+		targetAddr = net.JoinHostPort(req.URL.Hostname(), "80")
+	}
+	dialTime := time.Now()
+	conn, err := dialer.Dial(ctx, targetAddr)
+	if errors.Is(err, outline.ErrProxyAuthentication) {
+		return tcpTiming{}, &authenticationError{err}
+	} else if err != nil {
+		return tcpTiming{}, &reachabilityError{err}
+	}
+	defer conn.Close()
+	timing := tcpTiming{handshake: time.Since(dialTime)}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	defer unblockWhenDone(ctx, conn)()
+	writeTime := time.Now()
+	err = req.Write(conn)
+	if err != nil {
+		return tcpTiming{}, newResponseError(err, 0, time.Since(writeTime))
+	}
+	n, err := conn.Read(make([]byte, bufferLength))
+	if n == 0 && err != nil {
+		return tcpTiming{}, newResponseError(err, 0, time.Since(writeTime))
+	}
+	timing.requestRTT = time.Since(writeTime)
+	return timing, nil
+}
+
+// unblockWhenDone unblocks the I/O of `conn` when `ctx` is done, until the returned function
+// is called.
+func unblockWhenDone(ctx context.Context, conn interface{ SetDeadline(time.Time) error }) func() {
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+	return func() { close(stop) }
+}
+
+// tcpTargetAddress returns the host:port address of `targetURL`.
+func tcpTargetAddress(targetURL string) (string, error) {
+	req, err := http.NewRequest("HEAD", targetURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("invalid TCP target URL %q: %w", targetURL, err)
+	}
+	if req.URL.Scheme != "http" || req.Host == "" {
+		return "", fmt.Errorf("invalid TCP target URL %q: must be of the form http://host(:port)(/path)", targetURL)
+	}
+	if !hasPort(req.Host) {
+		return net.JoinHostPort(req.URL.Hostname(), "80"), nil
+	}
+	return req.Host, nil
+}
+
+func isResponse(queries []*dnsQuery, packet []byte) bool {
+	for _, query := range queries {
+		if query.isResponse(packet) {
+			return true
+		}
+	}
+	return false
+}
+
+func isResolver(addr net.Addr, resolvers []net.Addr) bool {
+	if addr == nil {
+		return false
+	}
+	for _, resolverAddr := range resolvers {
+		if addr.String() == resolverAddr.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// splitList returns the non-empty items of the comma-separated `list`.
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func durationOrDefault(millis int, defaultDuration time.Duration) time.Duration {
+	if millis <= 0 {
+		return defaultDuration
+	}
+	return time.Duration(millis) * time.Millisecond
+}
@@ -0,0 +1,212 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline"
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/neterrors"
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestChecker_SetTCPTargetURLs(t *testing.T) {
+	checker := NewChecker()
+	for _, urls := range []string{"", " , ", "https://example.com", "example.com", "http://"} {
+		if err := checker.SetTCPTargetURLs(urls); err == nil {
+			t.Errorf("SetTCPTargetURLs(%q) expects an error", urls)
+		}
+	}
+	if err := checker.SetTCPTargetURLs("http://example.org, http://192.0.2.1:8080/path"); err != nil {
+		t.Fatalf("SetTCPTargetURLs() failed: %v", err)
+	}
+	if want := []string{"http://example.org", "http://192.0.2.1:8080/path"}; !reflect.DeepEqual(checker.options.tcpTargetURLs, want) {
+		t.Errorf("TCP targets = %v, want %v", checker.options.tcpTargetURLs, want)
+	}
+}
+
+func TestChecker_SetUDPResolvers(t *testing.T) {
+	checker := NewChecker()
+	for _, addresses := range []string{"", "dns.google:53", "8.8.8.8"} {
+		if err := checker.SetUDPResolvers(addresses); err == nil {
+			t.Errorf("SetUDPResolvers(%q) expects an error", addresses)
+		}
+	}
+	if err := checker.SetUDPResolvers("8.8.8.8:53,[2001:4860:4860::8888]:53"); err != nil {
+		t.Fatalf("SetUDPResolvers() failed: %v", err)
+	}
+	if got := len(checker.options.udpResolvers); got != 2 {
+		t.Errorf("Got %d resolvers, want 2", got)
+	}
+}
+
+func TestChecker_Options(t *testing.T) {
+	checker := NewChecker()
+	checker.SetTCPTimeoutMillis(500)
+	checker.SetUDPTimeoutMillis(-1)
+	checker.SetMaxAttempts(3, 0)
+	want := checkerOptions{
+		tcpTargetURLs:     []string{defaultTCPTargetURL},
+		udpResolvers:      checker.options.udpResolvers,
+		udpQueryType:      dnsmessage.TypeA,
+		ipv6TCPTargetURLs: []string{defaultIPv6TCPTargetURL},
+		ipv6UDPResolvers:  checker.options.ipv6UDPResolvers,
+		tcpTimeout:        500 * time.Millisecond,
+		udpTimeout:        defaultUDPTimeout,
+		tcpMaxAttempts:    3,
+		udpMaxAttempts:    defaultUDPMaxAttempts,
+	}
+	if !reflect.DeepEqual(checker.options, want) {
+		t.Errorf("Options = %+v, want %+v", checker.options, want)
+	}
+}
+
+// blockingDialer is a [transport.StreamDialer] that fails for `blockedAddr`, records the
+// dialed addresses, and succeeds otherwise.
+type blockingDialer struct {
+	mu          sync.Mutex
+	blockedAddr string
+	dialed      []string
+}
+
+func (d *blockingDialer) Dial(ctx context.Context, addr string) (transport.StreamConn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dialed = append(d.dialed, addr)
+	if addr == d.blockedAddr {
+		return nil, errors.New("blocked")
+	}
+	return &fakeDuplexConn{}, nil
+}
+
+func TestChecker_CheckTCPConnectivity_Fallback(t *testing.T) {
+	checker := NewChecker()
+	checker.SetTCPTargetURLs("http://example.com,http://example.org:8080")
+	checker.SetMaxAttempts(2, 0)
+	dialer := &blockingDialer{blockedAddr: "example.com:80"}
+	if err := checker.CheckTCPConnectivity(context.Background(), dialer); err != nil {
+		t.Fatalf("CheckTCPConnectivity() failed: %v", err)
+	}
+	if want := []string{"example.com:80", "example.com:80", "example.org:8080"}; !reflect.DeepEqual(dialer.dialed, want) {
+		t.Errorf("Dialed %v, want %v", dialer.dialed, want)
+	}
+
+	dialer = &blockingDialer{blockedAddr: "example.com:80"}
+	checker.SetTCPTargetURLs("http://example.com")
+	var reachabilityErr *reachabilityError
+	if err := checker.CheckTCPConnectivity(context.Background(), dialer); !errors.As(err, &reachabilityErr) {
+		t.Errorf("CheckTCPConnectivity() error = %v, want a reachability error", err)
+	}
+}
+
+// resolverPacketConn is a [net.PacketConn] that only gets responses from `resolverAddr`.
+type resolverPacketConn struct {
+	net.PacketConn
+	resolverAddr string
+	responses    chan resolverResponse
+}
+
+type resolverResponse struct {
+	addr   net.Addr
+	packet []byte
+}
+
+func (c *resolverPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if addr.String() == c.resolverAddr {
+		c.responses <- resolverResponse{addr, newDNSResponse(b)}
+	}
+	return len(b), nil
+}
+
+func (c *resolverPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	select {
+	case response := <-c.responses:
+		return copy(b, response.packet), response.addr, nil
+	case <-time.After(100 * time.Millisecond):
+		return 0, nil, errors.New("timeout")
+	}
+}
+
+type resolverListener struct {
+	resolverAddr string
+}
+
+func (l *resolverListener) ListenPacket(ctx context.Context) (net.PacketConn, error) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	return &resolverPacketConn{PacketConn: conn, resolverAddr: l.resolverAddr, responses: make(chan resolverResponse, 10)}, nil
+}
+
+func TestChecker_CheckUDPConnectivity_Fallback(t *testing.T) {
+	checker := NewChecker()
+	checker.SetMaxAttempts(0, 1)
+	checker.SetUDPResolvers("192.0.2.53:53,192.0.2.54:53")
+	if err := checker.CheckUDPConnectivity(context.Background(), &resolverListener{"192.0.2.54:53"}); err != nil {
+		t.Errorf("CheckUDPConnectivity() failed: %v", err)
+	}
+	if err := checker.CheckUDPConnectivity(context.Background(), &resolverListener{"192.0.2.55:53"}); err == nil {
+		t.Error("CheckUDPConnectivity() expects an error without responding resolvers")
+	}
+}
+
+// hangingDialer is a [transport.StreamDialer] that blocks until the context is done.
+type hangingDialer struct {
+	dialing chan struct{}
+}
+
+func (d *hangingDialer) Dial(ctx context.Context, addr string) (transport.StreamConn, error) {
+	close(d.dialing)
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (d *hangingDialer) ListenPacket(ctx context.Context) (net.PacketConn, error) {
+	return nil, errors.New("UDP is not supported")
+}
+
+func TestChecker_Cancel(t *testing.T) {
+	checker := NewChecker()
+	dialer := &hangingDialer{dialing: make(chan struct{})}
+	go func() {
+		<-dialer.dialing
+		checker.Cancel()
+	}()
+	client := &outline.Client{StreamDialer: dialer, PacketListener: dialer}
+	code, err := checker.CheckConnectivity(context.Background(), client)
+	if code != neterrors.Unexpected || !errors.Is(err, context.Canceled) {
+		t.Errorf("CheckConnectivity() = %v, %v, want %v, %v", code, err, neterrors.Unexpected, context.Canceled)
+	}
+	if len(checker.cancels) != 0 {
+		t.Errorf("Got %d pending checks, want none", len(checker.cancels))
+	}
+}
+
+func TestChecker_ContextDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	dialer := &hangingDialer{dialing: make(chan struct{})}
+	if err := NewChecker().CheckTCPConnectivity(ctx, dialer); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("CheckTCPConnectivity() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
@@ -16,22 +16,25 @@ package connectivity
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/binary"
 	"errors"
+	"fmt"
 	"net"
-	"net/http"
+	"strings"
 	"time"
 
 	"github.com/Jigsaw-Code/outline-go-tun2socks/outline"
 	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/neterrors"
 	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"golang.org/x/net/dns/dnsmessage"
 )
 
-// TODO: make these values configurable by exposing a struct with the connectivity methods.
 const (
-	tcpTimeout          = 10 * time.Second
-	udpTimeout          = 1 * time.Second
-	udpMaxRetryAttempts = 5
-	bufferLength        = 512
+	bufferLength = 512
+	// Length and alphabet of the random labels of the DNS queries.
+	dnsLabelLength   = 16
+	dnsLabelAlphabet = "abcdefghijklmnopqrstuvwxyz0123456789"
 )
 
 // authenticationError is used to signal failed authentication to the Shadowsocks proxy.
@@ -39,116 +42,184 @@ type authenticationError struct {
 	error
 }
 
+func (e *authenticationError) Unwrap() error { return e.error }
+
 // reachabilityError is used to signal an unreachable proxy.
 type reachabilityError struct {
 	error
 }
 
-// CheckConnectivity determines whether the Shadowsocks proxy can relay TCP and UDP traffic under
-// the current network. Parallelizes the execution of TCP and UDP checks, selects the appropriate
-// error code to return accounting for transient network failures.
-// Returns an error if an unexpected error ocurrs.
-func CheckConnectivity(client *outline.Client) (neterrors.Error, error) {
-	// Start asynchronous UDP support check.
-	udpChan := make(chan error)
-	go func() {
-		resolverAddr := &net.UDPAddr{IP: net.ParseIP("1.1.1.1"), Port: 53}
-		udpChan <- CheckUDPConnectivityWithDNS(client, resolverAddr)
-	}()
-	// Check whether the proxy is reachable and that the client is able to authenticate to the proxy
-	tcpErr := CheckTCPConnectivityWithHTTP(client, "http://example.com")
-	if tcpErr == nil {
-		udpErr := <-udpChan
-		if udpErr == nil {
-			return neterrors.NoError, nil
-		}
-		return neterrors.UDPConnectivity, nil
+func (e *reachabilityError) Unwrap() error { return e.error }
+
+// ResponseError is used to signal that the proxy connection failed after the request of the
+// TCP check was sent. Connection resets, clean closes and timeouts are returned as is, since
+// they can't be told apart from network failures: Outline servers don't respond to invalid
+// keys to resist probing, but networks drop traffic too. Other failures, such as responses
+// that fail to decrypt, are wrapped in an authenticationError.
+type ResponseError struct {
+	Err error
+	// One of the ErrorClass constants.
+	Class string
+	// Bytes of the response received before the failure.
+	BytesReceived int
+	// Time from the request write to the failure.
+	AfterWrite time.Duration
+}
+
+func newResponseError(err error, bytesReceived int, afterWrite time.Duration) error {
+	respErr := &ResponseError{Err: err, Class: ClassifyError(err), BytesReceived: bytesReceived, AfterWrite: afterWrite}
+	switch respErr.Class {
+	case ErrorClassReset, ErrorClassClosed, ErrorClassTimeout:
+		return respErr
 	}
+	return &authenticationError{respErr}
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("%v after receiving %d bytes, %v after the request", e.Err, e.BytesReceived, e.AfterWrite.Round(time.Millisecond))
+}
+
+func (e *ResponseError) Unwrap() error { return e.Err }
+
+// tcpError returns the [neterrors.PlatformError] of an error of the TCP check, caused by
+// `tcpErr`. The code is [neterrors.Unexpected] if the error is not related to the connectivity
+// checks.
+func tcpError(tcpErr error) *neterrors.PlatformError {
 	var authErr *authenticationError
+	var respErr *ResponseError
 	var reachabilityErr *reachabilityError
-	if errors.As(tcpErr, &authErr) {
-		return neterrors.AuthenticationFailure, nil
-	} else if errors.As(tcpErr, &reachabilityErr) {
-		return neterrors.Unreachable, nil
+	switch {
+	case errors.As(tcpErr, &authErr):
+		return neterrors.New(neterrors.AuthenticationFailure, tcpErr)
+	case errors.As(tcpErr, &respErr) && respErr.Class == ErrorClassReset:
+		return neterrors.New(neterrors.ProxyConnectionReset, tcpErr)
+	case errors.As(tcpErr, &respErr) && respErr.Class == ErrorClassTimeout:
+		return neterrors.New(neterrors.ProxyResponseTimeout, tcpErr)
+	case errors.As(tcpErr, &respErr):
+		return neterrors.New(neterrors.ProxyConnectionClosed, tcpErr)
+	case errors.As(tcpErr, &reachabilityErr):
+		return neterrors.New(neterrors.Unreachable, tcpErr)
 	}
-	// The error is not related to the connectivity checks.
-	return neterrors.Unexpected, tcpErr
+	return neterrors.New(neterrors.Unexpected, tcpErr)
+}
+
+// CheckConnectivity determines whether the Shadowsocks proxy can relay TCP and UDP traffic under
+// the current network, with the default options of [NewChecker]. Returns the legacy code of the
+// failure, like [Checker.CheckConnectivity], and an error if an unexpected error ocurrs.
+func CheckConnectivity(client *outline.Client) (neterrors.Error, error) {
+	return NewChecker().CheckConnectivity(context.Background(), client)
 }
 
 // CheckUDPConnectivityWithDNS determines whether the Shadowsocks proxy represented by `client` and
 // the network support UDP traffic by issuing a DNS query though a resolver at `resolverAddr`.
 // Returns nil on success or an error on failure.
 func CheckUDPConnectivityWithDNS(client transport.PacketListener, resolverAddr net.Addr) error {
-	conn, err := client.ListenPacket(context.Background())
-	if err != nil {
-		return err
-	}
-	defer conn.Close()
-	buf := make([]byte, bufferLength)
-	for attempt := 0; attempt < udpMaxRetryAttempts; attempt++ {
-		conn.SetDeadline(time.Now().Add(udpTimeout))
-		_, err := conn.WriteTo(getDNSRequest(), resolverAddr)
-		if err != nil {
-			continue
-		}
-		n, addr, err := conn.ReadFrom(buf)
-		if n == 0 && err != nil {
-			continue
-		}
-		if addr.String() != resolverAddr.String() {
-			continue // Ensure we got a response from the resolver.
-		}
-		return nil
-	}
-	return errors.New("UDP connectivity check timed out")
+	checker := NewChecker()
+	checker.options.udpResolvers = []net.Addr{resolverAddr}
+	return checker.CheckUDPConnectivity(context.Background(), client)
 }
 
 // CheckTCPConnectivityWithHTTP determines whether the proxy is reachable over TCP and validates the
 // client's authentication credentials by performing an HTTP HEAD request to `targetURL`, which must
 // be of the form: http://[host](:[port])(/[path]). Returns nil on success, error if `targetURL` is
-// invalid, AuthenticationError or ReachabilityError on connectivity failure.
+// invalid, an authentication or reachability error if the proxy rejects the client or can't be
+// reached, or a [*ResponseError] if the response fails for other reasons, such as a reset.
 func CheckTCPConnectivityWithHTTP(dialer transport.StreamDialer, targetURL string) error {
-	deadline := time.Now().Add(tcpTimeout)
-	ctx, cancel := context.WithDeadline(context.Background(), deadline)
-	defer cancel()
-	req, err := http.NewRequest("HEAD", targetURL, nil)
-	if err != nil {
-		return err
+	checker := NewChecker()
+	checker.options.tcpTargetURLs = []string{targetURL}
+	return checker.CheckTCPConnectivity(context.Background(), dialer)
+}
+
+// dnsQuery is a DNS query of the UDP checks.
+type dnsQuery struct {
+	id       uint16
+	question dnsmessage.Question
+}
+
+// newDNSQuery returns a query of `queryType` with a random ID and a random name, so that its
+// responses can't be spoofed blindly or served from the cache of a middlebox.
+func newDNSQuery(queryType dnsmessage.Type) (*dnsQuery, error) {
+	var random [2 + dnsLabelLength]byte
+	if _, err := rand.Read(random[:]); err != nil {
+		return nil, err
 	}
-	targetAddr := req.Host
-	if !hasPort(targetAddr) {
-		targetAddr = net.JoinHostPort(targetAddr, "80")
+	label := make([]byte, dnsLabelLength)
+	for i, b := range random[2:] {
+		label[i] = dnsLabelAlphabet[int(b)%len(dnsLabelAlphabet)]
 	}
-	conn, err := dialer.Dial(ctx, targetAddr)
+	name, err := dnsmessage.NewName(string(label) + ".com.")
 	if err != nil {
-		return &reachabilityError{err}
+		return nil, err
+	}
+	return &dnsQuery{
+		id:       binary.BigEndian.Uint16(random[:2]),
+		question: dnsmessage.Question{Name: name, Type: queryType, Class: dnsmessage.ClassINET},
+	}, nil
+}
+
+// pack returns the wire format of the query. If `size` is positive, the query has an EDNS(0)
+// record with a padding option (RFC 7830) that fills `size` bytes.
+func (q *dnsQuery) pack(size int) ([]byte, error) {
+	if size <= 0 {
+		return q.build(0, false)
 	}
-	defer conn.Close()
-	conn.SetDeadline(deadline)
-	err = req.Write(conn)
+	query, err := q.build(0, true)
 	if err != nil {
-		return &authenticationError{err}
+		return nil, err
 	}
-	n, err := conn.Read(make([]byte, bufferLength))
-	if n == 0 && err != nil {
-		return &authenticationError{err}
+	padding := size - len(query)
+	if padding < paddingHeaderLength {
+		return nil, fmt.Errorf("padded DNS query must have at least %d bytes", len(query)+paddingHeaderLength)
 	}
-	return nil
+	return q.build(padding, true)
 }
 
-func getDNSRequest() []byte {
-	return []byte{
-		0, 0, // [0-1]   query ID
-		1, 0, // [2-3]   flags; byte[2] = 1 for recursion desired (RD).
-		0, 1, // [4-5]   QDCOUNT (number of queries)
-		0, 0, // [6-7]   ANCOUNT (number of answers)
-		0, 0, // [8-9]   NSCOUNT (number of name server records)
-		0, 0, // [10-11] ARCOUNT (number of additional records)
-		3, 'c', 'o', 'm',
-		0,    // null terminator of FQDN (root TLD)
-		0, 1, // QTYPE, set to A
-		0, 1, // QCLASS, set to 1 = IN (Internet)
+// build returns the wire format of the query, with an EDNS(0) record if `edns`, and a padding
+// option of `padding` bytes including its header, if positive.
+func (q *dnsQuery) build(padding int, edns bool) ([]byte, error) {
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: q.id, RecursionDesired: true})
+	builder.EnableCompression()
+	if err := builder.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := builder.Question(q.question); err != nil {
+		return nil, err
+	}
+	if edns {
+		if err := builder.StartAdditionals(); err != nil {
+			return nil, err
+		}
+		var optHeader dnsmessage.ResourceHeader
+		if err := optHeader.SetEDNS0(MaxProbePayload, dnsmessage.RCodeSuccess, false); err != nil {
+			return nil, err
+		}
+		var opt dnsmessage.OPTResource
+		if padding > 0 {
+			opt.Options = []dnsmessage.Option{{Code: paddingOptionCode, Data: make([]byte, padding-paddingHeaderLength)}}
+		}
+		if err := builder.OPTResource(optHeader, opt); err != nil {
+			return nil, err
+		}
+	}
+	return builder.Finish()
+}
+
+// isResponse returns whether `packet` is a response to the query, with the same ID and question.
+// The answers are not validated, since errors such as NXDOMAIN still prove that the query
+// reached the resolver.
+func (q *dnsQuery) isResponse(packet []byte) bool {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(packet)
+	if err != nil || !header.Response || header.ID != q.id {
+		return false
+	}
+	questions, err := parser.AllQuestions()
+	if err != nil || len(questions) != 1 {
+		return false
 	}
+	question := questions[0]
+	return question.Type == q.question.Type && question.Class == q.question.Class &&
+		strings.EqualFold(question.Name.String(), q.question.Name.String())
 }
 
 func hasPort(hostPort string) bool {
@@ -17,13 +17,20 @@ package connectivity
 import (
 	"context"
 	"errors"
+	"fmt"
+	"io"
 	"net"
+	"os"
 	"reflect"
+	"syscall"
 	"testing"
 	"time"
 
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline"
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/neterrors"
 	"github.com/Jigsaw-Code/outline-sdk/transport"
 	"github.com/Jigsaw-Code/outline-sdk/transport/shadowsocks"
+	"golang.org/x/net/dns/dnsmessage"
 )
 
 func TestCheckUDPConnectivityWithDNS_Success(t *testing.T) {
@@ -97,15 +104,19 @@ func (c *fakeSSClient) ListenPacket(_ context.Context) (net.PacketConn, error) {
 func (c *fakeSSClient) SetTCPSaltGenerator(salter shadowsocks.SaltGenerator) {
 }
 
-// Fake PacketConn that fails `ReadFrom` calls when `failRead` is true.
+// Fake PacketConn that responds to the DNS query of the last `WriteTo` call, or fails
+// `ReadFrom` calls when `failRead` is true. The response is altered by `spoof`, if not nil.
 type fakePacketConn struct {
 	net.PacketConn
 	addr     net.Addr
+	query    []byte
 	failRead bool
+	spoof    func(*dnsmessage.Message)
 }
 
 func (c *fakePacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
 	c.addr = addr
+	c.query = append([]byte(nil), b...)
 	return len(b), nil // Write always succeeds
 }
 
@@ -113,7 +124,38 @@ func (c *fakePacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
 	if c.failRead {
 		return 0, c.addr, errors.New("Fake read error")
 	}
-	return len(b), c.addr, nil
+	if c.query == nil {
+		return 0, c.addr, os.ErrDeadlineExceeded
+	}
+	var msg dnsmessage.Message
+	if err := msg.Unpack(c.query); err != nil {
+		return 0, c.addr, err
+	}
+	c.query = nil
+	msg.Response = true
+	msg.Additionals = nil
+	if c.spoof != nil {
+		c.spoof(&msg)
+	}
+	response, err := msg.Pack()
+	if err != nil {
+		return 0, c.addr, err
+	}
+	return copy(b, response), c.addr, nil
+}
+
+// newDNSResponse returns an empty response to `query`, or nil if it's not a valid query.
+func newDNSResponse(query []byte) []byte {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(query); err != nil {
+		return nil
+	}
+	response := dnsmessage.Message{Header: dnsmessage.Header{ID: msg.ID, Response: true}, Questions: msg.Questions}
+	packed, err := response.Pack()
+	if err != nil {
+		return nil
+	}
+	return packed
 }
 
 // Fake DuplexConn that fails `Read` calls when `failRead` is true.
@@ -148,3 +190,204 @@ func (c *fakeDuplexConn) SetWriteDeadline(t time.Time) error { return nil }
 func (c *fakeDuplexConn) CloseRead() error { return nil }
 
 func (c *fakeDuplexConn) CloseWrite() error { return nil }
+
+// failingDialer is a [transport.StreamDialer] that fails with `dialErr`, or whose connections
+// fail to read with `readErr`.
+type failingDialer struct {
+	dialErr error
+	readErr error
+}
+
+func (d *failingDialer) Dial(_ context.Context, raddr string) (transport.StreamConn, error) {
+	if d.dialErr != nil {
+		return nil, d.dialErr
+	}
+	return &failingReadConn{fakeDuplexConn: &fakeDuplexConn{}, readErr: d.readErr}, nil
+}
+
+type failingReadConn struct {
+	*fakeDuplexConn
+	readErr error
+}
+
+func (c *failingReadConn) Read(b []byte) (int, error) { return 0, c.readErr }
+
+func TestCheckConnectivity_ErrorCodes(t *testing.T) {
+	tests := []struct {
+		name   string
+		dialer *failingDialer
+		want   neterrors.Error
+	}{
+		{
+			name:   "proxy authentication",
+			dialer: &failingDialer{dialErr: fmt.Errorf("SOCKS5 %w", outline.ErrProxyAuthentication)},
+			want:   neterrors.AuthenticationFailure,
+		},
+		{
+			name:   "dial failure",
+			dialer: &failingDialer{dialErr: &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}},
+			want:   neterrors.Unreachable,
+		},
+		{
+			name:   "reset",
+			dialer: &failingDialer{readErr: &net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}},
+			want:   neterrors.ProxyConnectionReset,
+		},
+		{
+			name:   "closed",
+			dialer: &failingDialer{readErr: io.EOF},
+			want:   neterrors.ProxyConnectionClosed,
+		},
+		{
+			name:   "timeout",
+			dialer: &failingDialer{readErr: &net.OpError{Op: "read", Err: os.ErrDeadlineExceeded}},
+			want:   neterrors.ProxyResponseTimeout,
+		},
+		{
+			name:   "invalid response",
+			dialer: &failingDialer{readErr: errors.New("failed to decrypt response")},
+			want:   neterrors.AuthenticationFailure,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &outline.Client{StreamDialer: tt.dialer, PacketListener: &fakeSSClient{}}
+			// Older apps get the codes they handle.
+			code, err := CheckConnectivity(client)
+			if code != tt.want.LegacyCode() || err != nil {
+				t.Errorf("CheckConnectivity() = %v, %v, want %v", code, err, tt.want.LegacyCode())
+			}
+			// The detailed error has the same code, and wraps the error of the check.
+			err = NewChecker().Check(context.Background(), client)
+			var platformErr *neterrors.PlatformError
+			if !errors.As(err, &platformErr) || !errors.Is(err, tt.want) || platformErr.Cause == nil {
+				t.Errorf("Check() = %v, want a %v error with a cause", err, tt.want)
+			}
+			if tt.dialer.readErr != nil && !errors.Is(err, tt.dialer.readErr) {
+				t.Errorf("Check() = %v, want it to wrap %v", err, tt.dialer.readErr)
+			}
+		})
+	}
+}
+
+func TestChecker_Check(t *testing.T) {
+	if err := NewChecker().Check(context.Background(), &outline.Client{StreamDialer: &fakeSSClient{}, PacketListener: &fakeSSClient{}}); err != nil {
+		t.Errorf("Check() = %v, want success", err)
+	}
+	err := NewChecker().Check(context.Background(), &outline.Client{StreamDialer: &fakeSSClient{}, PacketListener: &fakeSSClient{failUDP: true}})
+	if !errors.Is(err, neterrors.UDPConnectivity) || !neterrors.FromError(err).Retryable {
+		t.Errorf("Check() = %v, want a retryable UDP connectivity error", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = NewChecker().Check(ctx, &outline.Client{StreamDialer: &fakeSSClient{}, PacketListener: &fakeSSClient{}})
+	if !errors.Is(err, neterrors.Unexpected) || !errors.Is(err, context.Canceled) {
+		t.Errorf("Check() = %v, want an unexpected error caused by the cancelation", err)
+	}
+}
+
+func TestChecker_CheckTCP(t *testing.T) {
+	if err := NewChecker().CheckTCP(context.Background(), &fakeSSClient{}); err != nil {
+		t.Errorf("CheckTCP() = %v, want success", err)
+	}
+	err := NewChecker().CheckTCP(context.Background(), &fakeSSClient{failAuthentication: true})
+	if !errors.Is(err, neterrors.AuthenticationFailure) {
+		t.Errorf("CheckTCP() = %v, want an authentication failure", err)
+	}
+}
+
+func TestCheckTCPConnectivityWithHTTP_ResponseError(t *testing.T) {
+	dialer := &failingDialer{readErr: &net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}}
+	err := CheckTCPConnectivityWithHTTP(dialer, "http://example.com")
+	var respErr *ResponseError
+	if !errors.As(err, &respErr) || respErr.Class != ErrorClassReset || respErr.BytesReceived != 0 {
+		t.Fatalf("Expected a reset ResponseError, got: %v", err)
+	}
+	if !errors.Is(err, syscall.ECONNRESET) {
+		t.Errorf("ResponseError doesn't wrap the cause: %v", err)
+	}
+}
+
+// spoofingListener is a [transport.PacketListener] whose connections respond to the DNS
+// queries with responses altered by `spoof`.
+type spoofingListener struct {
+	spoof func(*dnsmessage.Message)
+}
+
+func (l *spoofingListener) ListenPacket(_ context.Context) (net.PacketConn, error) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	return &fakePacketConn{PacketConn: conn, spoof: l.spoof}, nil
+}
+
+func TestChecker_CheckUDPConnectivity_Validation(t *testing.T) {
+	tests := []struct {
+		name    string
+		spoof   func(*dnsmessage.Message)
+		wantErr bool
+	}{
+		{name: "valid", spoof: func(msg *dnsmessage.Message) { msg.RCode = dnsmessage.RCodeNameError }},
+		{name: "wrong ID", spoof: func(msg *dnsmessage.Message) { msg.ID++ }, wantErr: true},
+		{name: "not a response", spoof: func(msg *dnsmessage.Message) { msg.Response = false }, wantErr: true},
+		{name: "no question", spoof: func(msg *dnsmessage.Message) { msg.Questions = nil }, wantErr: true},
+		{name: "wrong name", spoof: func(msg *dnsmessage.Message) { msg.Questions[0].Name = dnsmessage.MustNewName("com.") }, wantErr: true},
+		{name: "wrong type", spoof: func(msg *dnsmessage.Message) { msg.Questions[0].Type = dnsmessage.TypeAAAA }, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := NewChecker()
+			checker.SetMaxAttempts(0, 2)
+			err := checker.CheckUDPConnectivity(context.Background(), &spoofingListener{tt.spoof})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckUDPConnectivity() error = %v, want error = %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestChecker_SetUDPQueryType(t *testing.T) {
+	checker := NewChecker()
+	if err := checker.SetUDPQueryType("MX"); err == nil {
+		t.Error("SetUDPQueryType(\"MX\") expects an error")
+	}
+	if err := checker.SetUDPQueryType("aaaa"); err != nil {
+		t.Fatalf("SetUDPQueryType() failed: %v", err)
+	}
+	var gotType dnsmessage.Type
+	listener := &spoofingListener{func(msg *dnsmessage.Message) { gotType = msg.Questions[0].Type }}
+	if err := checker.CheckUDPConnectivity(context.Background(), listener); err != nil || gotType != dnsmessage.TypeAAAA {
+		t.Errorf("CheckUDPConnectivity() = %v with query type %v, want success with AAAA", err, gotType)
+	}
+}
+
+func TestDNSQuery(t *testing.T) {
+	query1, err := newDNSQuery(dnsmessage.TypeA)
+	if err != nil {
+		t.Fatalf("newDNSQuery() failed: %v", err)
+	}
+	query2, _ := newDNSQuery(dnsmessage.TypeA)
+	if query1.id == query2.id && query1.question.Name == query2.question.Name {
+		t.Errorf("Got the same random ID and name twice: %v", query1.question.Name)
+	}
+	for _, size := range []int{0, MinProbePayload, MaxProbePayload} {
+		packed, err := query1.pack(size)
+		if err != nil {
+			t.Fatalf("pack(%d) failed: %v", size, err)
+		}
+		if size > 0 && len(packed) != size {
+			t.Errorf("Got query of %d bytes, want %d", len(packed), size)
+		}
+		var msg dnsmessage.Message
+		if err := msg.Unpack(packed); err != nil || msg.ID != query1.id || len(msg.Questions) != 1 {
+			t.Errorf("Got invalid query %+v: %v", msg, err)
+		}
+		if !query1.isResponse(newDNSResponse(packed)) || query2.isResponse(newDNSResponse(packed)) {
+			t.Errorf("isResponse() doesn't match the response to the query of %d bytes", size)
+		}
+	}
+	if _, err := query1.pack(20); err == nil {
+		t.Error("pack() expects an error for a size smaller than the query")
+	}
+}
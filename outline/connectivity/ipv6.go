@@ -0,0 +1,108 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline"
+)
+
+// Defaults of the IPv6 targets of [Checker.CheckIPv6Connectivity].
+const (
+	defaultIPv6TCPTargetURL = "http://[2606:4700:4700::1111]"
+	defaultIPv6UDPResolver  = "[2606:4700:4700::1111]:53"
+)
+
+// IPv6Support is the result of [Checker.CheckIPv6Connectivity].
+type IPv6Support struct {
+	// Whether the proxy can reach IPv6 destinations over TCP.
+	TCP bool `json:"tcp"`
+	// Whether the proxy can reach IPv6 destinations over UDP.
+	UDP bool `json:"udp"`
+}
+
+// SetIPv6Targets replaces the targets of [Checker.CheckIPv6Connectivity] with the comma-separated
+// `tcpURLs`, of the form http://[ipv6](:port)(/path), and the comma-separated `udpResolvers`,
+// of the form [ipv6]:port. Empty lists keep the current targets.
+func (c *Checker) SetIPv6Targets(tcpURLs, udpResolvers string) error {
+	var targets []string
+	for _, url := range splitList(tcpURLs) {
+		address, err := tcpTargetAddress(url)
+		if err != nil {
+			return err
+		}
+		if !isIPv6Address(address) {
+			return fmt.Errorf("invalid IPv6 TCP target URL %q: host must be an IPv6 address", url)
+		}
+		targets = append(targets, url)
+	}
+	var resolvers []net.Addr
+	for _, address := range splitList(udpResolvers) {
+		if !isIPv6Address(address) {
+			return fmt.Errorf("invalid IPv6 resolver address %q: must be of the form [ipv6]:port", address)
+		}
+		resolverAddr, err := net.ResolveUDPAddr("udp", address)
+		if err != nil {
+			return fmt.Errorf("invalid IPv6 resolver address %q: %w", address, err)
+		}
+		resolvers = append(resolvers, resolverAddr)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(targets) > 0 {
+		c.options.ipv6TCPTargetURLs = targets
+	}
+	if len(resolvers) > 0 {
+		c.options.ipv6UDPResolvers = resolvers
+	}
+	return nil
+}
+
+// CheckIPv6Connectivity determines whether the proxy represented by `client` can reach IPv6
+// destinations, by running the TCP and UDP checks concurrently against the IPv6 targets. The
+// result is only meaningful if [Checker.CheckConnectivity] succeeds, since the IPv6 checks
+// can't tell proxy failures apart from missing IPv6 support.
+// Returns an error if `ctx` is done.
+func (c *Checker) CheckIPv6Connectivity(ctx context.Context, client *outline.Client) (*IPv6Support, error) {
+	ctx, options, done := c.withCancel(ctx)
+	defer done()
+	ipv6Options := *options
+	ipv6Options.tcpTargetURLs = options.ipv6TCPTargetURLs
+	ipv6Options.udpResolvers = options.ipv6UDPResolvers
+
+	udpChan := make(chan error, 1)
+	go func() {
+		udpChan <- ipv6Options.checkUDP(ctx, client)
+	}()
+	tcpErr := ipv6Options.checkTCP(ctx, client)
+	udpErr := <-udpChan
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return &IPv6Support{TCP: tcpErr == nil, UDP: udpErr == nil}, nil
+}
+
+// isIPv6Address returns whether `address` is of the form [ipv6]:port.
+func isIPv6Address(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.To4() == nil
+}
@@ -0,0 +1,85 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline"
+)
+
+func TestChecker_SetIPv6Targets(t *testing.T) {
+	checker := NewChecker()
+	tests := []struct {
+		tcpURLs      string
+		udpResolvers string
+	}{
+		{tcpURLs: "http://example.com"},
+		{tcpURLs: "http://192.0.2.1"},
+		{tcpURLs: "https://[2001:db8::1]"},
+		{udpResolvers: "192.0.2.53:53"},
+		{udpResolvers: "2001:db8::53"},
+	}
+	for _, tt := range tests {
+		if err := checker.SetIPv6Targets(tt.tcpURLs, tt.udpResolvers); err == nil {
+			t.Errorf("SetIPv6Targets(%q, %q) expects an error", tt.tcpURLs, tt.udpResolvers)
+		}
+	}
+	if err := checker.SetIPv6Targets("http://[2001:db8::1]:8080/path", ""); err != nil {
+		t.Fatalf("SetIPv6Targets() failed: %v", err)
+	}
+	if want := []string{"http://[2001:db8::1]:8080/path"}; !reflect.DeepEqual(checker.options.ipv6TCPTargetURLs, want) {
+		t.Errorf("IPv6 TCP targets = %v, want %v", checker.options.ipv6TCPTargetURLs, want)
+	}
+	if got := checker.options.ipv6UDPResolvers[0].String(); got != defaultIPv6UDPResolver {
+		t.Errorf("IPv6 resolver = %v, want the default %v", got, defaultIPv6UDPResolver)
+	}
+}
+
+func TestChecker_CheckIPv6Connectivity(t *testing.T) {
+	tests := []struct {
+		name   string
+		client *outline.Client
+		want   IPv6Support
+	}{
+		{
+			name:   "supported",
+			client: &outline.Client{StreamDialer: &blockingDialer{}, PacketListener: &resolverListener{defaultIPv6UDPResolver}},
+			want:   IPv6Support{TCP: true, UDP: true},
+		},
+		{
+			name:   "UDP only",
+			client: &outline.Client{StreamDialer: &blockingDialer{blockedAddr: "[2606:4700:4700::1111]:80"}, PacketListener: &resolverListener{defaultIPv6UDPResolver}},
+			want:   IPv6Support{UDP: true},
+		},
+		{
+			name:   "IPv4 only",
+			client: &outline.Client{StreamDialer: &blockingDialer{blockedAddr: "[2606:4700:4700::1111]:80"}, PacketListener: &resolverListener{defaultUDPResolver}},
+			want:   IPv6Support{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := NewChecker()
+			checker.SetMaxAttempts(0, 1)
+			got, err := checker.CheckIPv6Connectivity(context.Background(), tt.client)
+			if err != nil || *got != tt.want {
+				t.Errorf("CheckIPv6Connectivity() = %+v, %v, want %+v", got, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline"
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/neterrors"
+)
+
+const defaultLatencySamples = 5
+
+// LatencyResult is the latency of a client measured by [Checker.MeasureLatency]. It can be
+// serialized to JSON.
+type LatencyResult struct {
+	// Index of the client in the arguments of [Checker.MeasureLatency].
+	Index int `json:"index"`
+	// Number of successful samples.
+	Samples int `json:"samples"`
+	// Median time to establish a connection through the proxy.
+	HandshakeMs int64 `json:"handshakeMs"`
+	// Median time from the request write to the first byte of the response.
+	RequestRTTMs int64 `json:"requestRttMs"`
+	// Mean difference between the request round trip times of consecutive samples.
+	JitterMs int64 `json:"jitterMs"`
+	// Error of the last sample, and its legacy code, if all the samples failed.
+	Error     string `json:"error,omitempty"`
+	ErrorCode int    `json:"errorCode"`
+
+	// Median total time, used for the ranking.
+	total time.Duration
+}
+
+// MeasureLatency measures the latency of each client with `samples` HTTP requests to the first
+// TCP target URL, or 5 if not positive. The clients are measured concurrently, and their samples
+// sequentially. Returns the results ranked from the fastest to the slowest, followed by the
+// clients whose samples all failed.
+func (c *Checker) MeasureLatency(ctx context.Context, clients []*outline.Client, samples int) []*LatencyResult {
+	ctx, options, done := c.withCancel(ctx)
+	defer done()
+	if samples <= 0 {
+		samples = defaultLatencySamples
+	}
+	results := make([]*LatencyResult, len(clients))
+	var wg sync.WaitGroup
+	for i, client := range clients {
+		wg.Add(1)
+		go func(i int, client *outline.Client) {
+			defer wg.Done()
+			results[i] = options.measureLatency(ctx, client, samples)
+			results[i].Index = i
+		}(i, client)
+	}
+	wg.Wait()
+	sort.SliceStable(results, func(i, j int) bool {
+		if (results[i].Samples > 0) != (results[j].Samples > 0) {
+			return results[i].Samples > 0
+		}
+		return results[i].total < results[j].total
+	})
+	return results
+}
+
+func (c *checkerOptions) measureLatency(ctx context.Context, client *outline.Client, samples int) *LatencyResult {
+	var handshakes, rtts []time.Duration
+	var err error
+	for i := 0; i < samples && ctx.Err() == nil; i++ {
+		var timing tcpTiming
+		timing, err = timeTCPWithHTTP(ctx, client, c.tcpTargetURLs[0], c.tcpTimeout)
+		if err == nil {
+			handshakes = append(handshakes, timing.handshake)
+			rtts = append(rtts, timing.requestRTT)
+		}
+	}
+	result := &LatencyResult{Samples: len(rtts)}
+	if len(rtts) == 0 {
+		if err == nil {
+			err = ctx.Err()
+		}
+		code := tcpError(err).Code
+		if errors.Is(err, context.Canceled) {
+			code = neterrors.Unexpected
+		}
+		result.Error, result.ErrorCode = err.Error(), code.LegacyCode().Number()
+		return result
+	}
+	handshake, rtt := median(handshakes), median(rtts)
+	result.HandshakeMs, result.RequestRTTMs = handshake.Milliseconds(), rtt.Milliseconds()
+	result.JitterMs = jitter(rtts).Milliseconds()
+	result.total = handshake + rtt
+	return result
+}
+
+// median returns the median of `samples`, which must not be empty.
+func median(samples []time.Duration) time.Duration {
+	sorted := append([]time.Duration(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+	return sorted[middle]
+}
+
+// jitter returns the mean absolute difference between consecutive `samples`, or 0 if there are
+// less than 2.
+func jitter(samples []time.Duration) time.Duration {
+	if len(samples) < 2 {
+		return 0
+	}
+	var sum time.Duration
+	for i := 1; i < len(samples); i++ {
+		diff := samples[i] - samples[i-1]
+		if diff < 0 {
+			diff = -diff
+		}
+		sum += diff
+	}
+	return sum / time.Duration(len(samples)-1)
+}
@@ -0,0 +1,78 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline"
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/neterrors"
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+)
+
+// delayedDialer is a [transport.StreamDialer] whose connections take `delay` to establish.
+type delayedDialer struct {
+	delay time.Duration
+}
+
+func (d *delayedDialer) Dial(ctx context.Context, addr string) (transport.StreamConn, error) {
+	time.Sleep(d.delay)
+	return &fakeDuplexConn{}, nil
+}
+
+func TestChecker_MeasureLatency(t *testing.T) {
+	clients := []*outline.Client{
+		{StreamDialer: &delayedDialer{30 * time.Millisecond}},
+		{StreamDialer: &failingDialer{dialErr: &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}}},
+		{StreamDialer: &delayedDialer{time.Millisecond}},
+	}
+	results := NewChecker().MeasureLatency(context.Background(), clients, 3)
+	if len(results) != 3 {
+		t.Fatalf("Got %d results, want 3", len(results))
+	}
+	if results[0].Index != 2 || results[1].Index != 0 || results[2].Index != 1 {
+		t.Errorf("Ranked indexes = %d, %d, %d, want 2, 0, 1", results[0].Index, results[1].Index, results[2].Index)
+	}
+	if results[0].Samples != 3 || results[1].HandshakeMs < 30 || results[1].Error != "" {
+		t.Errorf("Unexpected results %+v, %+v", results[0], results[1])
+	}
+	if failed := results[2]; failed.Samples != 0 || failed.Error == "" || failed.ErrorCode != neterrors.Unreachable.Number() {
+		t.Errorf("Failed result = %+v, want an unreachable error", failed)
+	}
+}
+
+func TestMedianAndJitter(t *testing.T) {
+	tests := []struct {
+		samples    []time.Duration
+		wantMedian time.Duration
+		wantJitter time.Duration
+	}{
+		{samples: []time.Duration{5}, wantMedian: 5, wantJitter: 0},
+		{samples: []time.Duration{30, 10, 20}, wantMedian: 20, wantJitter: 15},
+		{samples: []time.Duration{10, 40, 20, 30}, wantMedian: 25, wantJitter: 20},
+	}
+	for _, tt := range tests {
+		if got := median(tt.samples); got != tt.wantMedian {
+			t.Errorf("median(%v) = %v, want %v", tt.samples, got, tt.wantMedian)
+		}
+		if got := jitter(tt.samples); got != tt.wantJitter {
+			t.Errorf("jitter(%v) = %v, want %v", tt.samples, got, tt.wantJitter)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline"
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/neterrors"
+)
+
+// Connectivity states of a [Monitor].
+const (
+	// No check has completed yet.
+	StateUnknown = 0
+	// TCP and UDP work.
+	StateHealthy = 1
+	// TCP works, but recent checks failed, or the last one was slow.
+	StateDegraded = 2
+	// TCP works, but UDP doesn't.
+	StateUDPLost = 3
+	// The last TCP checks failed.
+	StateUnreachable = 4
+)
+
+// Defaults of the [Monitor] options.
+const (
+	defaultMonitorInterval      = 60 * time.Second
+	defaultMonitorRetryInterval = 5 * time.Second
+	// Checks slower than this degrade the state.
+	slowCheckDuration = 5 * time.Second
+	// Number of samples kept in the history, and number of recent samples whose failures
+	// degrade the state.
+	monitorHistoryLength  = 10
+	monitorDegradedWindow = 3
+	// Consecutive TCP failures that make the proxy unreachable.
+	unreachableFailures = 2
+)
+
+// MonitorListener is notified of the state transitions of a [Monitor].
+type MonitorListener interface {
+	// OnStateChanged is called with the new and the previous state, in order, from a goroutine
+	// of its own, so it may call [Monitor.Stop]. Transitions that happened before Stop may
+	// still be delivered after it returns.
+	OnStateChanged(state, previousState int)
+}
+
+// MonitorSample is the result of a check of a [Monitor]. It can be serialized to JSON.
+type MonitorSample struct {
+	// Unix time of the check, in milliseconds.
+	TimeMs     int64 `json:"timeMs"`
+	DurationMs int64 `json:"durationMs"`
+	// The legacy code returned by [Checker.CheckConnectivity].
+	ErrorCode int `json:"errorCode"`
+}
+
+// Monitor runs the connectivity checks periodically in the background, and notifies its
+// listener when the connectivity state changes. After failures, the checks are retried sooner,
+// backing off to the regular interval, so that recoveries are noticed quickly.
+// A Monitor is safe for concurrent use.
+type Monitor struct {
+	checker  *Checker
+	client   *outline.Client
+	listener MonitorListener
+
+	mu            sync.Mutex
+	interval      time.Duration
+	retryInterval time.Duration
+	state         int
+	history       []*MonitorSample
+	// Stops the monitor goroutine, and requests an immediate check. Nil if not running.
+	cancel  context.CancelFunc
+	checkCh chan struct{}
+	done    chan struct{}
+	// Transitions not delivered to the listener yet, as pairs of new and previous states, and
+	// whether a goroutine is delivering them.
+	transitions [][2]int
+	notifying   bool
+}
+
+// NewMonitor creates a [Monitor] of `client`, that runs the checks of `checker` every minute,
+// and retries them after 5 seconds on failure. `listener` may be nil.
+func NewMonitor(checker *Checker, client *outline.Client, listener MonitorListener) *Monitor {
+	return &Monitor{
+		checker:       checker,
+		client:        client,
+		listener:      listener,
+		interval:      defaultMonitorInterval,
+		retryInterval: defaultMonitorRetryInterval,
+	}
+}
+
+// SetIntervalMillis sets the interval between checks, and the first retry interval after a
+// failure, which doubles with each failure up to `interval`. Non-positive values select the
+// defaults. Takes effect after the next check.
+func (m *Monitor) SetIntervalMillis(interval, retryInterval int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.interval = durationOrDefault(interval, defaultMonitorInterval)
+	m.retryInterval = durationOrDefault(retryInterval, defaultMonitorRetryInterval)
+	if m.retryInterval > m.interval {
+		m.retryInterval = m.interval
+	}
+}
+
+// Start starts checking in the background, if not running.
+func (m *Monitor) Start() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cancel != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	m.checkCh = make(chan struct{}, 1)
+	m.done = make(chan struct{})
+	go m.run(ctx, m.checkCh, m.done)
+}
+
+// Stop stops checking, and waits for the check in progress to be canceled. The state and the
+// history are kept. It doesn't wait for the listener, which may call it.
+func (m *Monitor) Stop() {
+	m.mu.Lock()
+	cancel, done := m.cancel, m.done
+	m.cancel, m.checkCh, m.done = nil, nil, nil
+	m.mu.Unlock()
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+// CheckNow runs a check as soon as possible, such as after a network change, if running.
+func (m *Monitor) CheckNow() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.checkCh == nil {
+		return
+	}
+	select {
+	case m.checkCh <- struct{}{}:
+	default: // A check is already requested.
+	}
+}
+
+// State returns the current state, one of the State constants.
+func (m *Monitor) State() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state
+}
+
+// History returns a JSON array of the last [MonitorSample]s, from the oldest to the newest.
+func (m *Monitor) History() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	historyJSON, _ := json.Marshal(m.history)
+	return string(historyJSON)
+}
+
+func (m *Monitor) run(ctx context.Context, checkCh chan struct{}, done chan struct{}) {
+	defer close(done)
+	for {
+		m.check(ctx)
+		timer := time.NewTimer(m.nextDelay())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-checkCh:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// check runs a check, records its sample, and notifies the listener of the state transition,
+// if any.
+func (m *Monitor) check(ctx context.Context) {
+	start := time.Now()
+	code, _ := m.checker.CheckConnectivity(ctx, m.client)
+	if ctx.Err() != nil {
+		// The check was canceled by Stop.
+		return
+	}
+	// Unexpected errors count as TCP failures, with the code neterrors.Unexpected.
+	sample := &MonitorSample{TimeMs: start.UnixMilli(), DurationMs: time.Since(start).Milliseconds(), ErrorCode: code.Number()}
+
+	m.mu.Lock()
+	m.history = append(m.history, sample)
+	if len(m.history) > monitorHistoryLength {
+		m.history = m.history[len(m.history)-monitorHistoryLength:]
+	}
+	previousState := m.state
+	m.state = monitorState(m.history)
+	if m.state != previousState && m.listener != nil {
+		m.transitions = append(m.transitions, [2]int{m.state, previousState})
+		if !m.notifying {
+			m.notifying = true
+			go m.notify()
+		}
+	}
+	m.mu.Unlock()
+}
+
+// notify delivers the pending transitions to the listener, until there are none.
+func (m *Monitor) notify() {
+	for {
+		m.mu.Lock()
+		if len(m.transitions) == 0 {
+			m.notifying = false
+			m.mu.Unlock()
+			return
+		}
+		transition := m.transitions[0]
+		m.transitions = m.transitions[1:]
+		m.mu.Unlock()
+		m.listener.OnStateChanged(transition[0], transition[1])
+	}
+}
+
+// nextDelay returns the delay until the next check, which backs off from the retry interval
+// after consecutive failures.
+func (m *Monitor) nextDelay() time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	failures := 0
+	for i := len(m.history) - 1; i >= 0 && m.history[i].ErrorCode != neterrors.NoError.Number(); i-- {
+		failures++
+	}
+	if failures == 0 {
+		return m.interval
+	}
+	delay := m.retryInterval
+	for i := 1; i < failures && delay < m.interval; i++ {
+		delay *= 2
+	}
+	if delay > m.interval {
+		return m.interval
+	}
+	return delay
+}
+
+// monitorState returns the state after the samples of `history`, from the oldest to the newest.
+func monitorState(history []*MonitorSample) int {
+	if len(history) == 0 {
+		return StateUnknown
+	}
+	last := history[len(history)-1]
+	switch last.ErrorCode {
+	case neterrors.NoError.Number():
+		if last.DurationMs >= slowCheckDuration.Milliseconds() {
+			return StateDegraded
+		}
+		for i := len(history) - 2; i >= 0 && i >= len(history)-monitorDegradedWindow; i-- {
+			if history[i].ErrorCode != neterrors.NoError.Number() {
+				return StateDegraded
+			}
+		}
+		return StateHealthy
+	case neterrors.UDPConnectivity.Number():
+		return StateUDPLost
+	}
+	// TCP failed. Failures after a TCP success only degrade the state until they are
+	// consecutive, since they may be transient.
+	recent := history
+	if len(recent) > unreachableFailures {
+		recent = recent[len(recent)-unreachableFailures:]
+	}
+	for _, sample := range recent {
+		if sample.ErrorCode == neterrors.NoError.Number() || sample.ErrorCode == neterrors.UDPConnectivity.Number() {
+			return StateDegraded
+		}
+	}
+	return StateUnreachable
+}
@@ -0,0 +1,206 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline"
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/neterrors"
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+)
+
+// samples returns the history of the error codes `codes`, with fast checks.
+func samples(codes ...neterrors.Error) []*MonitorSample {
+	var history []*MonitorSample
+	for _, code := range codes {
+		history = append(history, &MonitorSample{ErrorCode: code.Number()})
+	}
+	return history
+}
+
+func TestMonitorState(t *testing.T) {
+	const ok, udp, down = neterrors.NoError, neterrors.UDPConnectivity, neterrors.Unreachable
+	slow := []*MonitorSample{{ErrorCode: ok.Number(), DurationMs: slowCheckDuration.Milliseconds()}}
+	tests := []struct {
+		name    string
+		history []*MonitorSample
+		want    int
+	}{
+		{name: "empty", want: StateUnknown},
+		{name: "healthy", history: samples(ok, ok), want: StateHealthy},
+		{name: "slow", history: slow, want: StateDegraded},
+		{name: "recovering", history: samples(down, ok, ok), want: StateDegraded},
+		{name: "recovered", history: samples(down, ok, ok, ok), want: StateHealthy},
+		{name: "UDP lost", history: samples(ok, udp), want: StateUDPLost},
+		{name: "first failure", history: samples(down), want: StateUnreachable},
+		{name: "transient failure", history: samples(ok, down), want: StateDegraded},
+		{name: "failure after UDP loss", history: samples(udp, down), want: StateDegraded},
+		{name: "consecutive failures", history: samples(ok, down, neterrors.AuthenticationFailure), want: StateUnreachable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := monitorState(tt.history); got != tt.want {
+				t.Errorf("monitorState() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMonitor_NextDelay(t *testing.T) {
+	const ok, down = neterrors.NoError, neterrors.Unreachable
+	monitor := NewMonitor(NewChecker(), nil, nil)
+	monitor.SetIntervalMillis(60000, 5000)
+	tests := []struct {
+		history []*MonitorSample
+		want    time.Duration
+	}{
+		{history: nil, want: 60 * time.Second},
+		{history: samples(down, ok), want: 60 * time.Second},
+		{history: samples(ok, down), want: 5 * time.Second},
+		{history: samples(down, down, down), want: 20 * time.Second},
+		{history: samples(down, down, down, down, down), want: 60 * time.Second},
+	}
+	for _, tt := range tests {
+		monitor.history = tt.history
+		if got := monitor.nextDelay(); got != tt.want {
+			t.Errorf("nextDelay() after %d samples = %v, want %v", len(tt.history), got, tt.want)
+		}
+	}
+}
+
+// switchableClient is an [outline.Client] whose TCP and UDP failures can be switched.
+type switchableClient struct {
+	mu      sync.Mutex
+	failTCP bool
+	failUDP bool
+}
+
+func (c *switchableClient) set(failTCP, failUDP bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failTCP, c.failUDP = failTCP, failUDP
+}
+
+func (c *switchableClient) Dial(ctx context.Context, addr string) (transport.StreamConn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return (&fakeSSClient{failReachability: c.failTCP}).Dial(ctx, addr)
+}
+
+func (c *switchableClient) ListenPacket(ctx context.Context) (net.PacketConn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return (&fakeSSClient{failUDP: c.failUDP}).ListenPacket(ctx)
+}
+
+// stateRecorder is a [MonitorListener] that sends the new states to a channel.
+type stateRecorder chan int
+
+func (r stateRecorder) OnStateChanged(state, previousState int) {
+	r <- state
+}
+
+func TestMonitor(t *testing.T) {
+	fake := &switchableClient{}
+	client := &outline.Client{StreamDialer: fake, PacketListener: fake}
+	states := make(stateRecorder, 10)
+	monitor := NewMonitor(NewChecker(), client, states)
+	monitor.SetIntervalMillis(10, 1)
+	expectState := func(want int) {
+		t.Helper()
+		select {
+		case got := <-states:
+			if got != want || monitor.State() != want {
+				t.Fatalf("Got state %v, want %v", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for state %v", want)
+		}
+	}
+
+	monitor.Start()
+	monitor.Start() // No-op.
+	expectState(StateHealthy)
+	fake.set(false, true)
+	expectState(StateUDPLost)
+	fake.set(true, true)
+	expectState(StateDegraded)
+	expectState(StateUnreachable)
+	fake.set(false, false)
+	expectState(StateDegraded)
+	expectState(StateHealthy)
+	monitor.Stop()
+	monitor.CheckNow() // No-op.
+
+	var history []MonitorSample
+	if err := json.Unmarshal([]byte(monitor.History()), &history); err != nil || len(history) == 0 || len(history) > monitorHistoryLength {
+		t.Errorf("Got history %v, %v", monitor.History(), err)
+	}
+	if state := monitor.State(); state != StateHealthy {
+		t.Errorf("Got state %v after Stop(), want %v", state, StateHealthy)
+	}
+}
+
+// stoppingListener is a [MonitorListener] that stops its monitor on the first transition.
+type stoppingListener struct {
+	monitor *Monitor
+	stopped chan int
+}
+
+func (l *stoppingListener) OnStateChanged(state, previousState int) {
+	l.monitor.Stop()
+	l.stopped <- state
+}
+
+func TestMonitor_StopFromListener(t *testing.T) {
+	fake := &switchableClient{}
+	client := &outline.Client{StreamDialer: fake, PacketListener: fake}
+	listener := &stoppingListener{stopped: make(chan int, 10)}
+	listener.monitor = NewMonitor(NewChecker(), client, listener)
+	listener.monitor.SetIntervalMillis(10, 1)
+
+	listener.monitor.Start()
+	select {
+	case state := <-listener.stopped:
+		if state != StateHealthy {
+			t.Errorf("Got state %v, want %v", state, StateHealthy)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop() from the listener deadlocked")
+	}
+}
+
+func TestMonitor_UnexpectedError(t *testing.T) {
+	checker := NewChecker()
+	// An invalid target fails the TCP check with an unexpected error.
+	checker.options.tcpTargetURLs = []string{"http://%zz"}
+	fake := &switchableClient{}
+	monitor := NewMonitor(checker, &outline.Client{StreamDialer: fake, PacketListener: fake}, nil)
+
+	monitor.check(context.Background())
+	monitor.check(context.Background())
+	if len(monitor.history) != 2 || monitor.history[1].ErrorCode != neterrors.Unexpected.Number() {
+		t.Fatalf("Got history %v, want 2 unexpected failures", monitor.History())
+	}
+	if state := monitor.State(); state != StateUnreachable {
+		t.Errorf("Got state %v, want %v", state, StateUnreachable)
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// MinProbePayload is the size of the smallest UDP payload probed by
+	// [Checker.ProbeMaxUDPPayload].
+	MinProbePayload = 64
+	// MaxProbePayload is the size of the largest UDP payload probed by
+	// [Checker.ProbeMaxUDPPayload], which fills an IPv4 packet of 1500 bytes.
+	MaxProbePayload = 1472
+	// Attempts of each probe size. Losses are rarely caused by the size, so the probes are
+	// retried, but less than the UDP check since the failing sizes are expected to time out.
+	probeMaxAttempts = 2
+	// Size of the padding option header of a DNS query, and the option code (RFC 7830).
+	paddingHeaderLength = 4
+	paddingOptionCode   = 12
+)
+
+// SetUDPEchoServer sets the UDP echo server at `address`, of the form ip:port, as the target
+// of [Checker.ProbeMaxUDPPayload]. An empty address selects the UDP resolvers instead.
+func (c *Checker) SetUDPEchoServer(address string) error {
+	var echoServer net.Addr
+	if address != "" {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil || net.ParseIP(host) == nil {
+			return fmt.Errorf("invalid echo server address %q: must be of the form ip:port", address)
+		}
+		if echoServer, err = net.ResolveUDPAddr("udp", net.JoinHostPort(host, port)); err != nil {
+			return fmt.Errorf("invalid echo server address %q: %w", address, err)
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.options.udpEchoServer = echoServer
+	return nil
+}
+
+// ProbeMaxUDPPayload searches for the largest UDP payload, between [MinProbePayload] and
+// [MaxProbePayload] bytes, that round-trips through `listener`. If an echo server is set with
+// [Checker.SetUDPEchoServer], the probes are echoed back, which validates both directions.
+// Otherwise, the probes are DNS queries to the UDP resolvers, padded to the probe size, which
+// only validates the direction to the resolvers since their responses are small.
+// Returns an error if the smallest probe fails, or if `ctx` is done.
+func (c *Checker) ProbeMaxUDPPayload(ctx context.Context, listener transport.PacketListener) (int, error) {
+	ctx, options, done := c.withCancel(ctx)
+	defer done()
+	conn, err := listener.ListenPacket(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	defer unblockWhenDone(ctx, conn)()
+
+	buf := make([]byte, 2*MaxProbePayload)
+	// Most paths support the largest payload, so it's probed first.
+	if options.probeUDPPayload(ctx, conn, MaxProbePayload, buf) {
+		return MaxProbePayload, nil
+	}
+	if !options.probeUDPPayload(ctx, conn, MinProbePayload, buf) {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		return 0, errors.New("UDP probe of the smallest payload timed out")
+	}
+	// The payload of `low` bytes round-trips, and the payload of `high` bytes doesn't.
+	low, high := MinProbePayload, MaxProbePayload
+	for high-low > 1 {
+		middle := (low + high) / 2
+		if options.probeUDPPayload(ctx, conn, middle, buf) {
+			low = middle
+		} else {
+			high = middle
+		}
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+	}
+	return low, nil
+}
+
+// probeUDPPayload returns whether a probe of `size` bytes round-trips through `conn`.
+func (c *checkerOptions) probeUDPPayload(ctx context.Context, conn net.PacketConn, size int, buf []byte) bool {
+	targets := c.udpResolvers
+	if c.udpEchoServer != nil {
+		targets = []net.Addr{c.udpEchoServer}
+	}
+	for attempt := 0; attempt < probeMaxAttempts && ctx.Err() == nil; attempt++ {
+		// Each attempt has a new probe, to ignore the late responses to the previous ones.
+		probe, isResponse, err := c.newProbe(size)
+		if err != nil {
+			return false
+		}
+		conn.SetDeadline(time.Now().Add(c.udpTimeout))
+		for _, target := range targets {
+			conn.WriteTo(probe, target)
+		}
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if n == 0 && err != nil {
+				break
+			}
+			if isResolver(addr, targets) && isResponse(buf[:n]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// newProbe returns a probe of `size` bytes, and a function that returns whether a packet is
+// a response to it.
+func (c *checkerOptions) newProbe(size int) ([]byte, func([]byte) bool, error) {
+	if c.udpEchoServer != nil {
+		probe := make([]byte, size)
+		if _, err := rand.Read(probe); err != nil {
+			return nil, nil, err
+		}
+		return probe, func(packet []byte) bool { return bytes.Equal(packet, probe) }, nil
+	}
+	query, err := newDNSQuery(dnsmessage.TypeA)
+	if err != nil {
+		return nil, nil, err
+	}
+	probe, err := query.pack(size)
+	if err != nil {
+		return nil, nil, err
+	}
+	return probe, query.isResponse, nil
+}
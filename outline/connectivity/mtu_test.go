@@ -0,0 +1,110 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+)
+
+// startLimitedUDPServer starts a local UDP server that drops the packets larger than
+// `maxPayload`. It echoes the other packets if `echo`, or responds to them as DNS queries.
+// Returns the server address.
+func startLimitedUDPServer(t *testing.T, maxPayload int, echo bool) string {
+	conn, err := transport.UDPPacketListener{Address: "127.0.0.1:0"}.ListenPacket(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 65536)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if n > maxPayload {
+				continue
+			}
+			if echo {
+				conn.WriteTo(buf[:n], addr)
+				continue
+			}
+			if response := newDNSResponse(buf[:n]); response != nil {
+				conn.WriteTo(response, addr)
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestChecker_ProbeMaxUDPPayload(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxPayload int
+		echo       bool
+		want       int
+		wantErr    bool
+	}{
+		{name: "DNS unlimited", maxPayload: 65536, want: MaxProbePayload},
+		{name: "DNS limited", maxPayload: 1200, want: 1200},
+		{name: "echo limited", maxPayload: 1000, echo: true, want: 1000},
+		{name: "echo minimum", maxPayload: MinProbePayload, echo: true, want: MinProbePayload},
+		{name: "too small", maxPayload: MinProbePayload - 1, echo: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := NewChecker()
+			checker.SetUDPTimeoutMillis(50)
+			serverAddr := startLimitedUDPServer(t, tt.maxPayload, tt.echo)
+			var err error
+			if tt.echo {
+				err = checker.SetUDPEchoServer(serverAddr)
+			} else {
+				err = checker.SetUDPResolvers(serverAddr)
+			}
+			if err != nil {
+				t.Fatalf("Failed to set the server: %v", err)
+			}
+			got, err := checker.ProbeMaxUDPPayload(context.Background(), &transport.UDPPacketListener{Address: "127.0.0.1:0"})
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ProbeMaxUDPPayload() = %v, want an error", got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("ProbeMaxUDPPayload() = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestChecker_SetUDPEchoServer(t *testing.T) {
+	checker := NewChecker()
+	for _, address := range []string{"example.com:7", "127.0.0.1", "127.0.0.1:port"} {
+		if err := checker.SetUDPEchoServer(address); err == nil {
+			t.Errorf("SetUDPEchoServer(%q) expects an error", address)
+		}
+	}
+	if err := checker.SetUDPEchoServer("[::1]:7"); err != nil || checker.options.udpEchoServer == nil {
+		t.Errorf("SetUDPEchoServer() failed: %v", err)
+	}
+	if err := checker.SetUDPEchoServer(""); err != nil || checker.options.udpEchoServer != nil {
+		t.Errorf("SetUDPEchoServer(\"\") = %v, want the echo server cleared", err)
+	}
+}
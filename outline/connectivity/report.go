@@ -0,0 +1,309 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline"
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/neterrors"
+)
+
+// Stages of a diagnostic [Report], in order.
+const (
+	// Resolution of the proxy host name.
+	StageResolve = "resolve"
+	// TCP connection to the proxy, or to the first hop in front of it.
+	StageConnect = "connect"
+	// Connection to the TCP target through the proxy, up to the first byte of the response to
+	// the HTTP request, which proves that the proxy accepted the credentials.
+	StageHandshake = "handshake"
+	// HTTP response of the TCP target through the proxy.
+	StageHTTP = "http"
+	// DNS query to the UDP resolvers through the proxy.
+	StageUDP = "udp"
+)
+
+// Classes of the errors of a [StageResult].
+const (
+	ErrorClassDNS         = "dns"
+	ErrorClassTimeout     = "timeout"
+	ErrorClassRefused     = "refused"
+	ErrorClassReset       = "reset"
+	ErrorClassUnreachable = "unreachable"
+	ErrorClassClosed      = "closed"
+	ErrorClassCanceled    = "canceled"
+	ErrorClassOther       = "other"
+)
+
+// StageResult is the result of a stage of a diagnostic [Report].
+type StageResult struct {
+	Stage string `json:"stage"`
+	// Whether the stage didn't run, because a stage it depends on failed.
+	Skipped    bool  `json:"skipped,omitempty"`
+	DurationMs int64 `json:"durationMs"`
+	// Error message and class, if the stage failed.
+	Error      string `json:"error,omitempty"`
+	ErrorClass string `json:"errorClass,omitempty"`
+	// Stage specific information, such as the resolved addresses or the HTTP status.
+	Detail string `json:"detail,omitempty"`
+}
+
+// Report is the result of [Checker.Diagnose]. It can be serialized to JSON.
+type Report struct {
+	Stages []*StageResult `json:"stages"`
+	// The error that [Checker.Check] would return, if any, and its legacy code.
+	Error     *neterrors.PlatformError `json:"error,omitempty"`
+	ErrorCode int                      `json:"errorCode"`
+}
+
+// Stage returns the result of `stage`, or nil if it's not in the report.
+func (r *Report) Stage(stage string) *StageResult {
+	for _, result := range r.Stages {
+		if result.Stage == stage {
+			return result
+		}
+	}
+	return nil
+}
+
+// Diagnose runs the connectivity checks stage by stage, and reports the timing and the error
+// class of each stage. `proxyHost` and `proxyPort` are the address of the proxy or the first
+// hop in front of it, which is resolved and connected to directly. `client` may be nil if it
+// couldn't be created, in which case the stages through the proxy are skipped.
+func (c *Checker) Diagnose(ctx context.Context, client *outline.Client, proxyHost string, proxyPort int) *Report {
+	ctx, options, done := c.withCancel(ctx)
+	defer done()
+	report := &Report{}
+
+	var ips []net.IPAddr
+	resolve := report.run(StageResolve, true, func() (string, error) {
+		var err error
+		ips, err = net.DefaultResolver.LookupIPAddr(ctx, proxyHost)
+		return joinIPs(ips), err
+	})
+	connect := report.run(StageConnect, resolve, func() (string, error) {
+		return options.connect(ctx, ips, proxyPort)
+	})
+
+	var conn net.Conn
+	var exchange *httpExchange
+	// The error of the failed TCP stage through the proxy, if any.
+	var tcpErr error
+	handshake := report.run(StageHandshake, connect && client != nil, func() (string, error) {
+		// Only the first target is diagnosed, to keep the stages comparable.
+		targetURL := options.tcpTargetURLs[0]
+		targetAddr, err := tcpTargetAddress(targetURL)
+		if err != nil {
+			return "", err
+		}
+		dialCtx, cancel := context.WithTimeout(ctx, options.tcpTimeout)
+		defer cancel()
+		conn, err = client.Dial(dialCtx, targetAddr)
+		if errors.Is(err, outline.ErrProxyAuthentication) {
+			tcpErr = &authenticationError{err}
+			return targetAddr, err
+		} else if err != nil {
+			tcpErr = &reachabilityError{err}
+			return targetAddr, err
+		}
+		// Proxies like Shadowsocks only authenticate the client once it sends data.
+		conn.SetDeadline(time.Now().Add(options.tcpTimeout))
+		exchange, err = startHTTP(ctx, conn, targetURL)
+		if err != nil {
+			tcpErr = err
+			return exchange.failure(), err
+		}
+		return targetAddr, nil
+	})
+	if conn != nil {
+		defer conn.Close()
+	}
+	httpOK := report.run(StageHTTP, handshake, func() (string, error) {
+		status, err := exchange.readResponse(ctx, conn)
+		tcpErr = err
+		return status, err
+	})
+
+	var udpErr error
+	udp := report.run(StageUDP, resolve && client != nil, func() (string, error) {
+		udpErr = options.checkUDP(ctx, client)
+		return "", udpErr
+	})
+
+	switch {
+	case tcpErr != nil:
+		report.Error = tcpError(tcpErr)
+	case !connect || !handshake || !httpOK:
+		report.Error = neterrors.New(neterrors.Unreachable, nil)
+	case !udp:
+		report.Error = neterrors.New(neterrors.UDPConnectivity, udpErr)
+	}
+	report.ErrorCode = neterrors.NoError.Number()
+	if report.Error != nil {
+		report.ErrorCode = report.Error.Code.LegacyCode().Number()
+	}
+	return report
+}
+
+// run adds the result of `stage` to the report, running `f` if `enabled`. Returns whether the
+// stage succeeded.
+func (r *Report) run(stage string, enabled bool, f func() (string, error)) bool {
+	result := &StageResult{Stage: stage}
+	r.Stages = append(r.Stages, result)
+	if !enabled {
+		result.Skipped = true
+		return false
+	}
+	start := time.Now()
+	detail, err := f()
+	result.DurationMs = time.Since(start).Milliseconds()
+	result.Detail = detail
+	if err != nil {
+		result.Error = err.Error()
+		result.ErrorClass = ClassifyError(err)
+		return false
+	}
+	return true
+}
+
+// connect connects to the first reachable address in `ips` over TCP. Returns the connected address.
+func (c *checkerOptions) connect(ctx context.Context, ips []net.IPAddr, port int) (string, error) {
+	var err error
+	for _, ip := range ips {
+		address := net.JoinHostPort(ip.String(), strconv.Itoa(port))
+		dialCtx, cancel := context.WithTimeout(ctx, c.tcpTimeout)
+		var conn net.Conn
+		conn, err = (&net.Dialer{}).DialContext(dialCtx, "tcp", address)
+		cancel()
+		if err == nil {
+			conn.Close()
+			return address, nil
+		}
+	}
+	return "", err
+}
+
+// httpExchange is an HTTP request sent by a [Report], whose response is being read.
+type httpExchange struct {
+	req       *http.Request
+	reader    *countingReader
+	buffered  *bufio.Reader
+	writeTime time.Time
+	// Whether the request was sent.
+	sent bool
+}
+
+// startHTTP sends an HTTP HEAD request for `targetURL` over `conn`, and waits for the first
+// byte of the response. Returns the exchange, and a [ResponseError] if no byte is received.
+func startHTTP(ctx context.Context, conn net.Conn, targetURL string) (*httpExchange, error) {
+	req, err := http.NewRequest("HEAD", targetURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer unblockWhenDone(ctx, conn)()
+	exchange := &httpExchange{req: req, reader: &countingReader{Reader: conn}, writeTime: time.Now()}
+	exchange.buffered = bufio.NewReader(exchange.reader)
+	if err := req.Write(conn); err != nil {
+		return exchange, newResponseError(err, 0, time.Since(exchange.writeTime))
+	}
+	exchange.sent = true
+	if _, err := exchange.buffered.Peek(1); err != nil {
+		return exchange, newResponseError(err, exchange.reader.n, time.Since(exchange.writeTime))
+	}
+	return exchange, nil
+}
+
+// readResponse reads the response of the exchange from `conn`. Returns the response status, or
+// a description of the failure with a [ResponseError].
+func (e *httpExchange) readResponse(ctx context.Context, conn net.Conn) (string, error) {
+	defer unblockWhenDone(ctx, conn)()
+	resp, err := http.ReadResponse(e.buffered, e.req)
+	if err != nil {
+		return e.failure(), newResponseError(err, e.reader.n, time.Since(e.writeTime))
+	}
+	resp.Body.Close()
+	return resp.Status, nil
+}
+
+// failure describes the failure of the exchange, or returns "" if the request wasn't created.
+func (e *httpExchange) failure() string {
+	switch {
+	case e == nil:
+		return ""
+	case !e.sent:
+		return "failed to send the request"
+	}
+	return fmt.Sprintf("received %d bytes, failed %d ms after the request", e.reader.n, time.Since(e.writeTime).Milliseconds())
+}
+
+// ClassifyError returns the class of `err`, one of the ErrorClass constants.
+func ClassifyError(err error) string {
+	var dnsErr *net.DNSError
+	switch {
+	case errors.Is(err, context.Canceled):
+		return ErrorClassCanceled
+	case errors.As(err, &dnsErr):
+		return ErrorClassDNS
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded):
+		return ErrorClassTimeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ErrorClassRefused
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+		return ErrorClassReset
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return ErrorClassUnreachable
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return ErrorClassClosed
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrorClassTimeout
+	}
+	return ErrorClassOther
+}
+
+// countingReader counts the bytes read from Reader.
+type countingReader struct {
+	io.Reader
+	n int
+}
+
+func (r *countingReader) Read(b []byte) (int, error) {
+	n, err := r.Reader.Read(b)
+	r.n += n
+	return n, err
+}
+
+func joinIPs(ips []net.IPAddr) string {
+	var list string
+	for i, ip := range ips {
+		if i > 0 {
+			list += ","
+		}
+		list += ip.String()
+	}
+	return list
+}
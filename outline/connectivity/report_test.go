@@ -0,0 +1,243 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline"
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/neterrors"
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/Jigsaw-Code/outline-sdk/transport/shadowsocks"
+)
+
+// pipeStreamConn adapts a [net.Pipe] connection to [transport.StreamConn].
+type pipeStreamConn struct {
+	net.Conn
+}
+
+func (c *pipeStreamConn) CloseRead() error  { return nil }
+func (c *pipeStreamConn) CloseWrite() error { return nil }
+
+// httpDialer is a [transport.StreamDialer] whose connections respond to HTTP requests with `response`.
+type httpDialer struct {
+	response string
+}
+
+func (d *httpDialer) Dial(ctx context.Context, addr string) (transport.StreamConn, error) {
+	clientConn, serverConn := net.Pipe()
+	go func() {
+		defer serverConn.Close()
+		if _, err := http.ReadRequest(bufio.NewReader(serverConn)); err != nil {
+			return
+		}
+		io.WriteString(serverConn, d.response)
+	}()
+	return &pipeStreamConn{clientConn}, nil
+}
+
+// listen returns the port of a local TCP listener, which is closed if `closed`.
+func listen(t *testing.T, closed bool) int {
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	if closed {
+		listener.Close()
+	} else {
+		t.Cleanup(func() { listener.Close() })
+	}
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestChecker_Diagnose(t *testing.T) {
+	okClient := &outline.Client{StreamDialer: &httpDialer{"HTTP/1.1 200 OK\r\n\r\n"}, PacketListener: &fakeSSClient{}}
+	tests := []struct {
+		name        string
+		client      *outline.Client
+		host        string
+		closed      bool
+		wantCode    neterrors.Error
+		wantClasses map[string]string // Error class of the failed stages, or "skipped".
+	}{
+		{name: "success", client: okClient, host: "127.0.0.1", wantCode: neterrors.NoError, wantClasses: map[string]string{}},
+		{
+			name:        "connection refused",
+			client:      okClient,
+			host:        "127.0.0.1",
+			closed:      true,
+			wantCode:    neterrors.Unreachable,
+			wantClasses: map[string]string{StageConnect: ErrorClassRefused, StageHandshake: "skipped", StageHTTP: "skipped"},
+		},
+		{
+			name:        "connection closed",
+			client:      &outline.Client{StreamDialer: &httpDialer{}, PacketListener: &fakeSSClient{}},
+			host:        "127.0.0.1",
+			wantCode:    neterrors.ProxyConnectionClosed,
+			wantClasses: map[string]string{StageHandshake: ErrorClassClosed, StageHTTP: "skipped"},
+		},
+		{
+			name:        "invalid response",
+			client:      &outline.Client{StreamDialer: &httpDialer{"SSH-2.0-OpenSSH\r\n"}, PacketListener: &fakeSSClient{}},
+			host:        "127.0.0.1",
+			wantCode:    neterrors.AuthenticationFailure,
+			wantClasses: map[string]string{StageHTTP: ErrorClassOther},
+		},
+		{
+			name:        "UDP blocked",
+			client:      &outline.Client{StreamDialer: okClient.StreamDialer, PacketListener: &fakeSSClient{failUDP: true}},
+			host:        "127.0.0.1",
+			wantCode:    neterrors.UDPConnectivity,
+			wantClasses: map[string]string{StageUDP: ErrorClassOther},
+		},
+		{
+			name:        "no client",
+			host:        "127.0.0.1",
+			wantCode:    neterrors.Unreachable,
+			wantClasses: map[string]string{StageHandshake: "skipped", StageHTTP: "skipped", StageUDP: "skipped"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := NewChecker()
+			checker.SetMaxAttempts(0, 1)
+			report := checker.Diagnose(context.Background(), tt.client, tt.host, listen(t, tt.closed))
+			if tt.wantCode == neterrors.NoError {
+				if report.Error != nil {
+					t.Errorf("Error = %v, want nil", report.Error)
+				}
+			} else if report.Error == nil || report.Error.Code != tt.wantCode {
+				t.Errorf("Error = %v, want code %v", report.Error, tt.wantCode.Number())
+			}
+			// Older apps get the codes they handle.
+			if report.ErrorCode != tt.wantCode.LegacyCode().Number() {
+				t.Errorf("ErrorCode = %v, want %v", report.ErrorCode, tt.wantCode.LegacyCode().Number())
+			}
+			for _, stage := range []string{StageResolve, StageConnect, StageHandshake, StageHTTP, StageUDP} {
+				result := report.Stage(stage)
+				if result == nil {
+					t.Fatalf("Missing stage %v", stage)
+				}
+				got := result.ErrorClass
+				if result.Skipped {
+					got = "skipped"
+				}
+				if want := tt.wantClasses[stage]; got != want {
+					t.Errorf("Stage %v = %q (%v), want %q", stage, got, result.Error, want)
+				}
+			}
+			if _, err := json.Marshal(report); err != nil {
+				t.Errorf("Failed to serialize report: %v", err)
+			}
+		})
+	}
+}
+
+// listenShadowsocks returns the address of a local Shadowsocks server with `key`, that closes
+// the connections it can't decrypt.
+func listenShadowsocks(t *testing.T, key *shadowsocks.EncryptionKey) string {
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(io.Discard, shadowsocks.NewReader(conn, key))
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestChecker_Diagnose_WrongKey(t *testing.T) {
+	serverKey, _ := shadowsocks.NewEncryptionKey("chacha20-ietf-poly1305", "SERVER")
+	clientKey, _ := shadowsocks.NewEncryptionKey("chacha20-ietf-poly1305", "CLIENT")
+	address := listenShadowsocks(t, serverKey)
+	dialer, err := shadowsocks.NewStreamDialer(&transport.TCPEndpoint{Address: address}, clientKey)
+	if err != nil {
+		t.Fatalf("Failed to create the dialer: %v", err)
+	}
+	checker := NewChecker()
+	checker.SetMaxAttempts(0, 1)
+	_, port, _ := net.SplitHostPort(address)
+	portNumber, _ := strconv.Atoi(port)
+	report := checker.Diagnose(context.Background(), &outline.Client{StreamDialer: dialer, PacketListener: &fakeSSClient{}}, "127.0.0.1", portNumber)
+
+	// The dialer connects lazily, so the proxy only rejects the key once the request is sent.
+	// The server closes the connection with unread data, which may reset it.
+	if result := report.Stage(StageConnect); result.Error != "" {
+		t.Errorf("Connect = %+v, want success", result)
+	}
+	if result := report.Stage(StageHandshake); result.ErrorClass != ErrorClassClosed && result.ErrorClass != ErrorClassReset {
+		t.Errorf("Handshake = %+v, want the connection closed or reset", result)
+	}
+	if result := report.Stage(StageHTTP); !result.Skipped {
+		t.Errorf("HTTP = %+v, want skipped", result)
+	}
+	if report.Error == nil || (report.Error.Code != neterrors.ProxyConnectionClosed && report.Error.Code != neterrors.ProxyConnectionReset) {
+		t.Errorf("Error = %v, want the connection closed or reset", report.Error)
+	}
+}
+
+func TestChecker_Diagnose_HTTPStatus(t *testing.T) {
+	client := &outline.Client{StreamDialer: &httpDialer{"HTTP/1.1 301 Moved Permanently\r\n\r\n"}, PacketListener: &fakeSSClient{}}
+	report := NewChecker().Diagnose(context.Background(), client, "127.0.0.1", listen(t, false))
+	if result := report.Stage(StageHTTP); result.Detail != "301 Moved Permanently" {
+		t.Errorf("HTTP detail = %q, want the response status", result.Detail)
+	}
+	if result := report.Stage(StageConnect); result.Detail == "" {
+		t.Error("Expected the connected address")
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{context.Canceled, ErrorClassCanceled},
+		{fmt.Errorf("wrapped: %w", context.DeadlineExceeded), ErrorClassTimeout},
+		{&net.OpError{Op: "read", Err: os.ErrDeadlineExceeded}, ErrorClassTimeout},
+		{&net.DNSError{Err: "no such host", Name: "proxy.invalid"}, ErrorClassDNS},
+		{&net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, ErrorClassRefused},
+		{&net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, ErrorClassReset},
+		{&net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ENETUNREACH)}, ErrorClassUnreachable},
+		{io.ErrUnexpectedEOF, ErrorClassClosed},
+		{errors.New("other"), ErrorClassOther},
+	}
+	for _, tt := range tests {
+		if got := ClassifyError(tt.err); got != tt.want {
+			t.Errorf("ClassifyError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
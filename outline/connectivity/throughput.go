@@ -0,0 +1,248 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+)
+
+// Defaults of the [Checker.MeasureThroughput] arguments.
+const (
+	defaultThroughputBytes     = 10 * 1024 * 1024
+	defaultThroughputTimeLimit = 15 * time.Second
+)
+
+// TransferResult is the result of a transfer of [Checker.MeasureThroughput].
+type TransferResult struct {
+	// Number of payload bytes transferred.
+	Bytes      int64   `json:"bytes"`
+	DurationMs int64   `json:"durationMs"`
+	Mbps       float64 `json:"mbps"`
+	// Whether the transfer was stopped by the time limit before completing.
+	TimeLimited bool `json:"timeLimited,omitempty"`
+	// Error message and class, if the transfer failed.
+	Error      string `json:"error,omitempty"`
+	ErrorClass string `json:"errorClass,omitempty"`
+}
+
+// ThroughputResult is the result of [Checker.MeasureThroughput]. It can be serialized to JSON.
+type ThroughputResult struct {
+	// Results of the transfers that were requested.
+	Download *TransferResult `json:"download,omitempty"`
+	Upload   *TransferResult `json:"upload,omitempty"`
+}
+
+// MeasureThroughput downloads `size` bytes from `downloadURL` with a GET request, then uploads
+// `size` bytes to `uploadURL` with a POST request, through `dialer`. Either URL may be empty to
+// skip the transfer. The URLs must be http or https, and the download server is expected to
+// send at least `size` bytes. Each transfer is stopped at `timeLimit`, in which case the
+// throughput of the bytes transferred so far is reported. Non-positive `size` and `timeLimit`
+// select 10 MiB and 15 seconds.
+// Returns an error if the arguments are invalid.
+func (c *Checker) MeasureThroughput(ctx context.Context, dialer transport.StreamDialer, downloadURL, uploadURL string, size int64, timeLimit time.Duration) (*ThroughputResult, error) {
+	if downloadURL == "" && uploadURL == "" {
+		return nil, errors.New("must provide a download or upload URL")
+	}
+	for _, targetURL := range []string{downloadURL, uploadURL} {
+		if targetURL == "" {
+			continue
+		}
+		if err := validateTransferURL(targetURL); err != nil {
+			return nil, err
+		}
+	}
+	if size <= 0 {
+		size = defaultThroughputBytes
+	}
+	if timeLimit <= 0 {
+		timeLimit = defaultThroughputTimeLimit
+	}
+	ctx, _, done := c.withCancel(ctx)
+	defer done()
+
+	// Bytes written to the connections of the transfers. Accessed atomically.
+	var written int64
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				conn, err := dialer.Dial(ctx, addr)
+				if err != nil {
+					return nil, err
+				}
+				return &countingConn{Conn: conn, written: &written}, nil
+			},
+			// Request the downloads uncompressed, so that the bytes read are the bytes transferred,
+			// and don't reuse the download connection for the upload.
+			DisableCompression: true,
+			DisableKeepAlives:  true,
+		},
+	}
+	defer client.CloseIdleConnections()
+	result := &ThroughputResult{}
+	if downloadURL != "" {
+		result.Download = download(ctx, client, downloadURL, size, timeLimit)
+	}
+	if uploadURL != "" {
+		atomic.StoreInt64(&written, 0)
+		result.Upload = upload(ctx, client, uploadURL, size, timeLimit, &written)
+	}
+	return result, nil
+}
+
+func download(ctx context.Context, client *http.Client, targetURL string, size int64, timeLimit time.Duration) *TransferResult {
+	ctx, cancel := context.WithTimeout(ctx, timeLimit)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, targetURL, nil)
+	if err != nil {
+		return transferError(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return transferError(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return transferError(fmt.Errorf("unexpected response status: %v", resp.Status))
+	}
+	// The time to first byte is excluded, to measure the throughput only.
+	start := time.Now()
+	n, err := io.Copy(io.Discard, io.LimitReader(resp.Body, size))
+	if err == nil && n < size {
+		err = fmt.Errorf("response ended after %d bytes: %w", n, io.ErrUnexpectedEOF)
+	}
+	return newTransferResult(ctx, n, time.Since(start), err)
+}
+
+// upload measures the upload of `size` bytes. `written` counts the bytes written to the
+// connection, since the body is read ahead of the bytes that are sent.
+func upload(ctx context.Context, client *http.Client, targetURL string, size int64, timeLimit time.Duration, written *int64) *TransferResult {
+	ctx, cancel := context.WithTimeout(ctx, timeLimit)
+	defer cancel()
+	body := &zeroReader{remaining: size}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, targetURL, body)
+	if err != nil {
+		return transferError(err)
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := client.Do(req)
+	// The upload ends when the server acknowledges the body with its response.
+	n, duration := body.progress()
+	if err != nil {
+		// Only the bytes written to the connection were sent, including the request headers.
+		if sent := atomic.LoadInt64(written); sent < n {
+			n = sent
+		}
+		return newTransferResult(ctx, n, duration, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return transferError(fmt.Errorf("unexpected response status: %v", resp.Status))
+	}
+	return newTransferResult(ctx, n, duration, nil)
+}
+
+// newTransferResult returns the result of a transfer of `n` bytes in `duration`, that ended with
+// `err`. Errors caused by the time limit of `ctx` are not reported, as long as some bytes were
+// transferred.
+func newTransferResult(ctx context.Context, n int64, duration time.Duration, err error) *TransferResult {
+	timeLimited := err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded)
+	if err != nil && !(timeLimited && n > 0) {
+		result := transferError(err)
+		result.Bytes, result.DurationMs = n, duration.Milliseconds()
+		return result
+	}
+	result := &TransferResult{Bytes: n, DurationMs: duration.Milliseconds(), TimeLimited: timeLimited}
+	if duration > 0 {
+		result.Mbps = float64(n*8) / duration.Seconds() / 1e6
+	}
+	return result
+}
+
+func transferError(err error) *TransferResult {
+	return &TransferResult{Error: err.Error(), ErrorClass: ClassifyError(err)}
+}
+
+func validateTransferURL(targetURL string) error {
+	parsed, err := url.Parse(targetURL)
+	if err != nil {
+		return fmt.Errorf("invalid transfer URL %q: %w", targetURL, err)
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("invalid transfer URL %q: must be of the form http(s)://host(:port)(/path)", targetURL)
+	}
+	return nil
+}
+
+// countingConn counts the bytes written to the connection in `written`, atomically.
+type countingConn struct {
+	net.Conn
+	written *int64
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddInt64(c.written, int64(n))
+	return n, err
+}
+
+// zeroReader reads `remaining` zero bytes, and keeps track of the time since the first read.
+type zeroReader struct {
+	mu        sync.Mutex
+	remaining int64
+	read      int64
+	start     time.Time
+}
+
+func (r *zeroReader) Read(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.start.IsZero() {
+		r.start = time.Now()
+	}
+	if r.remaining == 0 {
+		return 0, io.EOF
+	}
+	if int64(len(b)) > r.remaining {
+		b = b[:r.remaining]
+	}
+	for i := range b {
+		b[i] = 0
+	}
+	r.remaining -= int64(len(b))
+	r.read += int64(len(b))
+	return len(b), nil
+}
+
+// progress returns the number of bytes read, and the time since the first read.
+func (r *zeroReader) progress() (int64, time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.start.IsZero() {
+		return 0, 0
+	}
+	return r.read, time.Since(r.start)
+}
@@ -0,0 +1,195 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectivity
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+)
+
+// speedTestHandler is a speed test server stand-in. GET /down?bytes=N sends N bytes, GET /slow
+// sends bytes until the client disconnects, and POST /up discards the request body.
+func speedTestHandler(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/down":
+		n, _ := strconv.Atoi(r.URL.Query().Get("bytes"))
+		w.Write(make([]byte, n))
+	case r.Method == http.MethodGet && r.URL.Path == "/slow":
+		for r.Context().Err() == nil {
+			w.Write(make([]byte, 1000))
+			w.(http.Flusher).Flush()
+			time.Sleep(10 * time.Millisecond)
+		}
+	case r.Method == http.MethodPost && r.URL.Path == "/up":
+		io.Copy(io.Discard, r.Body)
+	default:
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestChecker_MeasureThroughput(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(speedTestHandler))
+	defer server.Close()
+	const size = 1 << 20
+	tests := []struct {
+		name         string
+		downloadURL  string
+		uploadURL    string
+		wantDownload *TransferResult
+		wantUpload   *TransferResult
+	}{
+		{
+			name:         "download and upload",
+			downloadURL:  server.URL + "/down?bytes=" + strconv.Itoa(size),
+			uploadURL:    server.URL + "/up",
+			wantDownload: &TransferResult{Bytes: size},
+			wantUpload:   &TransferResult{Bytes: size},
+		},
+		{
+			name:         "download only",
+			downloadURL:  server.URL + "/down?bytes=" + strconv.Itoa(2*size),
+			wantDownload: &TransferResult{Bytes: size},
+		},
+		{
+			name:         "time limit",
+			downloadURL:  server.URL + "/slow",
+			wantDownload: &TransferResult{TimeLimited: true},
+		},
+		{
+			name:         "short download",
+			downloadURL:  server.URL + "/down?bytes=10",
+			wantDownload: &TransferResult{Bytes: 10, ErrorClass: ErrorClassClosed},
+		},
+		{
+			name:       "upload not found",
+			uploadURL:  server.URL + "/missing",
+			wantUpload: &TransferResult{ErrorClass: ErrorClassOther},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewChecker().MeasureThroughput(context.Background(), &transport.TCPStreamDialer{}, tt.downloadURL, tt.uploadURL, size, 200*time.Millisecond)
+			if err != nil {
+				t.Fatalf("MeasureThroughput() failed: %v", err)
+			}
+			checkTransfer(t, "download", result.Download, tt.wantDownload)
+			checkTransfer(t, "upload", result.Upload, tt.wantUpload)
+		})
+	}
+}
+
+// checkTransfer compares the deterministic fields of `got` with `want`. A result with a time
+// limit must have transferred some bytes.
+func checkTransfer(t *testing.T, name string, got, want *TransferResult) {
+	if want == nil || got == nil {
+		if got != want {
+			t.Errorf("Got %v result %+v, want %+v", name, got, want)
+		}
+		return
+	}
+	if got.ErrorClass != want.ErrorClass || got.TimeLimited != want.TimeLimited {
+		t.Errorf("Got %v result %+v, want %+v", name, got, want)
+	}
+	if want.TimeLimited {
+		if got.Bytes == 0 || got.Mbps <= 0 {
+			t.Errorf("Got %v result %+v, want some bytes transferred", name, got)
+		}
+	} else if got.Bytes != want.Bytes {
+		t.Errorf("Got %v of %d bytes, want %d", name, got.Bytes, want.Bytes)
+	}
+	if want.ErrorClass == "" && !want.TimeLimited && got.Mbps <= 0 {
+		t.Errorf("Got %v result %+v, want positive Mbps", name, got)
+	}
+}
+
+func TestChecker_MeasureThroughput_Errors(t *testing.T) {
+	tests := []struct {
+		name        string
+		downloadURL string
+		uploadURL   string
+	}{
+		{name: "no URLs"},
+		{name: "unsupported scheme", downloadURL: "ftp://example.com/file"},
+		{name: "missing host", uploadURL: "http:///up"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewChecker().MeasureThroughput(context.Background(), &transport.TCPStreamDialer{}, tt.downloadURL, tt.uploadURL, 0, 0); err == nil {
+				t.Error("MeasureThroughput() expects an error")
+			}
+		})
+	}
+}
+
+// stallingDialer is a [transport.StreamDialer] whose connections stall the writes after
+// `limit` bytes, until they are closed.
+type stallingDialer struct {
+	limit int
+}
+
+func (d *stallingDialer) Dial(ctx context.Context, raddr string) (transport.StreamConn, error) {
+	conn, err := (&transport.TCPStreamDialer{}).Dial(ctx, raddr)
+	if err != nil {
+		return nil, err
+	}
+	return &stallingConn{StreamConn: conn, remaining: d.limit, closed: make(chan struct{})}, nil
+}
+
+type stallingConn struct {
+	transport.StreamConn
+	remaining int
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func (c *stallingConn) Write(b []byte) (int, error) {
+	if len(b) > c.remaining {
+		n, _ := c.StreamConn.Write(b[:c.remaining])
+		c.remaining = 0
+		<-c.closed
+		return n, net.ErrClosed
+	}
+	c.remaining -= len(b)
+	return c.StreamConn.Write(b)
+}
+
+func (c *stallingConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return c.StreamConn.Close()
+}
+
+func TestChecker_MeasureThroughput_StalledUpload(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(speedTestHandler))
+	defer server.Close()
+	const limit = 50000
+	result, err := NewChecker().MeasureThroughput(context.Background(), &stallingDialer{limit: limit}, "", server.URL+"/up", 1<<20, 200*time.Millisecond)
+	if err != nil {
+		t.Fatalf("MeasureThroughput() failed: %v", err)
+	}
+	// The bytes of the body that were read but not sent are not counted.
+	if got := result.Upload; !got.TimeLimited || got.Bytes == 0 || got.Bytes > limit {
+		t.Errorf("Got upload result %+v, want at most %d bytes before the time limit", got, limit)
+	}
+}
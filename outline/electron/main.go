@@ -15,6 +15,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io"
@@ -24,6 +25,8 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline"
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/connectivity"
 	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/internal/utf8"
 	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/neterrors"
 	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/shadowsocks"
@@ -56,6 +59,7 @@ var args struct {
 	proxyPrefix   *string
 
 	proxyConfig *string
+	proxyURL    *string
 
 	logLevel          *string
 	checkConnectivity *bool
@@ -73,12 +77,13 @@ func main() {
 	args.tunName = flag.String("tunName", "tun0", "TUN interface name")
 	args.proxyHost = flag.String("proxyHost", "", "Shadowsocks proxy hostname or IP address")
 	args.proxyPort = flag.Int("proxyPort", 0, "Shadowsocks proxy port number")
-	args.proxyPassword = flag.String("proxyPassword", "", "Shadowsocks proxy password")
-	args.proxyCipher = flag.String("proxyCipher", "chacha20-ietf-poly1305", "Shadowsocks proxy encryption cipher")
+	args.proxyPassword = flag.String("proxyPassword", "", "Shadowsocks proxy password, or the base64-encoded PSK for Shadowsocks 2022 ciphers")
+	args.proxyCipher = flag.String("proxyCipher", "chacha20-ietf-poly1305", "Shadowsocks proxy encryption cipher, including the 2022-blake3-* ciphers")
 	args.proxyPrefix = flag.String("proxyPrefix", "", "Shadowsocks connection prefix, UTF8-encoded (unsafe)")
 	args.proxyConfig = flag.String("proxyConfig", "", "A JSON object containing the proxy config, UTF8-encoded")
+	args.proxyURL = flag.String("proxyURL", "", "A SIP002 ss:// access key containing the proxy config")
 	args.logLevel = flag.String("logLevel", "info", "Logging level: debug|info|warn|error|none")
-	args.dnsFallback = flag.Bool("dnsFallback", false, "Enable DNS fallback over TCP (overrides the UDP handler).")
+	args.dnsFallback = flag.Bool("dnsFallback", false, "Enable DNS fallback over TCP (overrides the UDP handler). Uses UDP-over-TCP instead if the proxy config enables it.")
 	args.checkConnectivity = flag.Bool("checkConnectivity", false, "Check the proxy TCP and UDP connectivity and exit.")
 	args.version = flag.Bool("version", false, "Print the version and exit.")
 
@@ -94,16 +99,16 @@ func main() {
 	client, err := newShadowsocksClientFromArgs()
 	if err != nil {
 		log.Errorf("Failed to create Shadowsocks client: %v", err)
-		os.Exit(neterrors.IllegalConfiguration.Number())
+		exitWithError(neterrors.New(neterrors.IllegalConfiguration, err))
 	}
 
 	if *args.checkConnectivity {
-		connErrCode, err := shadowsocks.CheckConnectivity(client)
-		log.Debugf("Connectivity checks error code: %v", connErrCode)
-		if err != nil {
+		err := connectivity.NewChecker().Check(context.Background(), (*outline.Client)(client))
+		log.Debugf("Connectivity checks error code: %v", neterrors.CodeOf(err).Number())
+		if neterrors.CodeOf(err) == neterrors.Unexpected {
 			log.Errorf("Failed to perform connectivity checks: %v", err)
 		}
-		os.Exit(connErrCode)
+		exitWithError(err)
 	}
 
 	// Open TUN device
@@ -111,14 +116,18 @@ func main() {
 	tunDevice, err := tun.OpenTunDevice(*args.tunName, *args.tunAddr, *args.tunGw, *args.tunMask, dnsResolvers, persistTun)
 	if err != nil {
 		log.Errorf("Failed to open TUN device: %v", err)
-		os.Exit(neterrors.SystemMisconfigured.Number())
+		exitWithError(neterrors.New(neterrors.SystemMisconfigured, err))
 	}
 	// Output packets to TUN device
 	core.RegisterOutputFn(tunDevice.Write)
 
 	// Register TCP and UDP connection handlers
 	core.RegisterTCPConnHandler(tun2socks.NewTCPHandler(client))
-	if *args.dnsFallback {
+	if *args.dnsFallback && client.FallbackPacketListener != nil {
+		// UDP connectivity not supported, relay it over TCP.
+		log.Debugf("Registering UDP-over-TCP handler")
+		core.RegisterUDPConnHandler(tun2socks.NewUDPHandler(client.FallbackPacketListener, udpTimeout))
+	} else if *args.dnsFallback {
 		// UDP connectivity not supported, fall back to DNS over TCP.
 		log.Debugf("Registering DNS fallback UDP handler")
 		core.RegisterUDPConnHandler(dnsfallback.NewUDPHandler())
@@ -132,7 +141,7 @@ func main() {
 		_, err := io.CopyBuffer(lwipWriter, tunDevice, make([]byte, mtu))
 		if err != nil {
 			log.Errorf("Failed to write data to network stack: %v", err)
-			os.Exit(neterrors.Unexpected.Number())
+			exitWithError(neterrors.New(neterrors.Unexpected, err))
 		}
 	}()
 
@@ -144,6 +153,16 @@ func main() {
 	log.Debugf("Received signal: %v", sig)
 }
 
+// exitWithError exits with the legacy code of `err`, after writing it to stderr as the JSON
+// of a [neterrors.PlatformError], so that newer apps can show its details. Exits with 0 if
+// `err` is nil.
+func exitWithError(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, neterrors.ToJSON(err))
+	}
+	os.Exit(neterrors.CodeOf(err).LegacyCode().Number())
+}
+
 func setLogLevel(level string) {
 	switch strings.ToLower(level) {
 	case "debug":
@@ -166,6 +185,8 @@ func setLogLevel(level string) {
 func newShadowsocksClientFromArgs() (*shadowsocks.Client, error) {
 	if jsonConfig := *args.proxyConfig; len(jsonConfig) > 0 {
 		return shadowsocks.NewClientFromJSON(jsonConfig)
+	} else if accessKey := *args.proxyURL; len(accessKey) > 0 {
+		return shadowsocks.NewClientFromURL(accessKey)
 	} else {
 		// legacy raw flags
 		config := shadowsocks.Config{
@@ -0,0 +1,91 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This package provides a client for HTTP proxies that support the CONNECT method,
+// which the Outline tunnel can use instead of a Shadowsocks server.
+//
+// All data structures and functions will also be exposed as libraries that
+// non-golang callers can use (for example, C/Java/Objective-C).
+package httpconnect
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline"
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/connectivity"
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/internal/happyeyeballs"
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/internal/httpconnect"
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/internal/proxyclient"
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/neterrors"
+)
+
+// A client object that can be used to connect to a remote HTTP proxy.
+type Client outline.Client
+
+// NewClient creates a new client for the HTTP proxy at `host:port`. The connections to
+// the proxy use TLS if `useTLS` is true. If `username` is not empty, the client
+// authenticates with `username` and `password`.
+// HTTP proxies can't relay UDP, so the tunnel falls back to DNS over TCP.
+func NewClient(host string, port int, useTLS bool, username, password string) (*Client, error) {
+	if err := proxyclient.ValidateConfig(host, port, username, password); err != nil {
+		return nil, fmt.Errorf("invalid HTTP proxy configuration: %w", err)
+	}
+	var tlsConfig *tls.Config
+	if useTLS {
+		tlsConfig = &tls.Config{ServerName: host}
+	}
+	var credentials *httpconnect.Credentials
+	if len(username) > 0 {
+		credentials = &httpconnect.Credentials{Username: username, Password: password}
+	}
+	// The host is resolved on the first connection, and again on network changes.
+	proxyEndpoint := happyeyeballs.NewEndpoint(host, port, happyeyeballs.DefaultLookup)
+	dialer, err := httpconnect.NewStreamDialer(&happyeyeballs.StreamEndpoint{Endpoint: proxyEndpoint}, tlsConfig, credentials)
+	if err != nil {
+		return nil, fmt.Errorf("invalid HTTP proxy configuration: %w", err)
+	}
+	return &Client{StreamDialer: dialer, PacketListener: unsupportedPacketListener{}, NetworkChangeHandler: proxyEndpoint}, nil
+}
+
+// unsupportedPacketListener makes the UDP connectivity check fail, so that the tunnel
+// falls back to DNS over TCP.
+type unsupportedPacketListener struct{}
+
+func (unsupportedPacketListener) ListenPacket(ctx context.Context) (net.PacketConn, error) {
+	return nil, errors.New("HTTP proxies can't relay UDP")
+}
+
+// CheckConnectivity determines whether the HTTP proxy can relay TCP traffic under the
+// current network. Returns one of the error codes of the shadowsocks package, and an
+// error if an unexpected error ocurrs.
+func CheckConnectivity(client *Client) (int, error) {
+	return proxyclient.ErrorCode(proxyclient.Check((*outline.Client)(client), nil, false))
+}
+
+// CheckConnectivityWithChecker is like [CheckConnectivity], with the targets, timeouts and
+// retry policy of `checker`. The check can be stopped with [connectivity.Checker.Cancel].
+func CheckConnectivityWithChecker(client *Client, checker *connectivity.Checker) (int, error) {
+	return proxyclient.ErrorCode(proxyclient.Check((*outline.Client)(client), checker, false))
+}
+
+// CheckConnectivityJSON is like [CheckConnectivityWithChecker], and returns the failure as the
+// JSON of a [neterrors.PlatformError], with its code, category, message, cause and whether it
+// can be retried, or an empty string on success.
+func CheckConnectivityJSON(client *Client, checker *connectivity.Checker) string {
+	return neterrors.ToJSON(proxyclient.Check((*outline.Client)(client), checker, false))
+}
@@ -0,0 +1,108 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpconnect
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/connectivity"
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/neterrors"
+)
+
+func Test_NewClient(t *testing.T) {
+	tests := []struct {
+		name     string
+		host     string
+		port     int
+		useTLS   bool
+		username string
+		password string
+		wantErr  bool
+	}{
+		{name: "plain", host: "192.0.2.1", port: 8080},
+		{name: "TLS with auth", host: "proxy.example", port: 443, useTLS: true, username: "user", password: "secret"},
+		{name: "username without password", host: "proxy.example", port: 8080, username: "user"},
+		{name: "empty host", port: 8080, wantErr: true},
+		{name: "port 0", host: "192.0.2.1", wantErr: true},
+		{name: "port 65536", host: "192.0.2.1", port: 65536, wantErr: true},
+		{name: "password without username", host: "192.0.2.1", port: 8080, password: "secret", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewClient(tt.host, tt.port, tt.useTLS, tt.username, tt.password)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewClient() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got.StreamDialer == nil || got.PacketListener == nil || got.NetworkChangeHandler == nil {
+				t.Errorf("NewClient() = %+v, want all fields set", got)
+			}
+			if _, err := got.ListenPacket(context.Background()); err == nil {
+				t.Error("ListenPacket() expects an error")
+			}
+		})
+	}
+}
+
+// connectProxy is an HTTP proxy stand-in that tunnels the CONNECT streams to their target.
+func connectProxy(w http.ResponseWriter, r *http.Request) {
+	target, err := net.Dial("tcp", r.Host)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	defer target.Close()
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+	go io.Copy(target, conn)
+	io.Copy(conn, target)
+}
+
+func Test_CheckConnectivity(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+	proxy := httptest.NewServer(http.HandlerFunc(connectProxy))
+	defer proxy.Close()
+
+	host, portStr, _ := net.SplitHostPort(proxy.Listener.Addr().String())
+	port, _ := strconv.Atoi(portStr)
+	client, err := NewClient(host, port, false, "", "")
+	if err != nil {
+		t.Fatalf("NewClient() failed: %v", err)
+	}
+	checker := connectivity.NewChecker()
+	if err := checker.SetTCPTargetURLs(target.URL); err != nil {
+		t.Fatalf("SetTCPTargetURLs() failed: %v", err)
+	}
+	// UDP is not checked, since HTTP proxies can't relay it.
+	if code, err := CheckConnectivityWithChecker(client, checker); code != neterrors.NoError.Number() || err != nil {
+		t.Errorf("CheckConnectivityWithChecker() = %v, %v, want success", code, err)
+	}
+	if got := CheckConnectivityJSON(client, checker); got != "" {
+		t.Errorf("CheckConnectivityJSON() = %v, want success", got)
+	}
+}
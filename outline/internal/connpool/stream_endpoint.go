@@ -0,0 +1,185 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package connpool keeps pre-established connections to a proxy, so that new flows
+// don't wait for a handshake.
+package connpool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+)
+
+// connectTimeout bounds the background connections that fill the pool.
+const connectTimeout = 10 * time.Second
+
+// StreamEndpoint is a [transport.StreamEndpoint] that hands out pre-established
+// connections of a base endpoint.
+//
+// The pool is refilled in the background after each Connect. Idle connections are
+// closed after the maximum idle time and not replaced, so an unused pool stops
+// connecting to the proxy.
+type StreamEndpoint struct {
+	endpoint transport.StreamEndpoint
+	size     int
+	maxIdle  time.Duration
+
+	mu         sync.Mutex
+	idle       []*idleConn
+	connecting int
+	// generation is incremented to discard the connections started before a network change.
+	generation int
+}
+
+type idleConn struct {
+	conn  transport.StreamConn
+	timer *time.Timer
+}
+
+var _ transport.StreamEndpoint = (*StreamEndpoint)(nil)
+
+// NewStreamEndpoint creates a [StreamEndpoint] that keeps up to `size` idle connections
+// of `endpoint`, for at most `maxIdle` each.
+func NewStreamEndpoint(endpoint transport.StreamEndpoint, size int, maxIdle time.Duration) (*StreamEndpoint, error) {
+	if endpoint == nil {
+		return nil, errors.New("argument endpoint must not be nil")
+	}
+	if size <= 0 {
+		return nil, fmt.Errorf("pool size must be positive, got %d", size)
+	}
+	if maxIdle <= 0 {
+		return nil, fmt.Errorf("maximum idle time must be positive, got %v", maxIdle)
+	}
+	return &StreamEndpoint{endpoint: endpoint, size: size, maxIdle: maxIdle}, nil
+}
+
+// Connect implements [transport.StreamEndpoint.Connect]. Returns a healthy pooled
+// connection if there is one, or connects the base endpoint otherwise.
+func (e *StreamEndpoint) Connect(ctx context.Context) (transport.StreamConn, error) {
+	defer e.refill()
+	for {
+		conn := e.take()
+		if conn == nil {
+			break
+		}
+		if isHealthy(conn) {
+			return conn, nil
+		}
+		conn.Close()
+	}
+	return e.endpoint.Connect(ctx)
+}
+
+// OnNetworkChanged closes the idle connections, which belong to the previous network.
+func (e *StreamEndpoint) OnNetworkChanged() {
+	e.mu.Lock()
+	idle := e.idle
+	e.idle = nil
+	e.generation++
+	e.mu.Unlock()
+	for _, ic := range idle {
+		ic.timer.Stop()
+		ic.conn.Close()
+	}
+}
+
+// take removes and returns the newest idle connection, or nil if there is none.
+func (e *StreamEndpoint) take() transport.StreamConn {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.idle) == 0 {
+		return nil
+	}
+	ic := e.idle[len(e.idle)-1]
+	e.idle = e.idle[:len(e.idle)-1]
+	ic.timer.Stop()
+	return ic.conn
+}
+
+// refill starts the connections needed to fill the pool.
+func (e *StreamEndpoint) refill() {
+	e.mu.Lock()
+	missing := e.size - len(e.idle) - e.connecting
+	e.connecting += missing
+	generation := e.generation
+	e.mu.Unlock()
+	for i := 0; i < missing; i++ {
+		go e.connectIdle(generation)
+	}
+}
+
+func (e *StreamEndpoint) connectIdle(generation int) {
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+	conn, err := e.endpoint.Connect(ctx)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.connecting--
+	if err != nil {
+		// Don't retry, to avoid busy loops when the proxy is unreachable. The next
+		// Connect will try again.
+		return
+	}
+	if generation != e.generation {
+		conn.Close()
+		return
+	}
+	ic := &idleConn{conn: conn}
+	ic.timer = time.AfterFunc(e.maxIdle, func() { e.expire(ic) })
+	e.idle = append(e.idle, ic)
+}
+
+// expire closes `ic` if it's still idle.
+func (e *StreamEndpoint) expire(ic *idleConn) {
+	e.mu.Lock()
+	found := false
+	for i, other := range e.idle {
+		if other == ic {
+			e.idle = append(e.idle[:i], e.idle[i+1:]...)
+			found = true
+			break
+		}
+	}
+	e.mu.Unlock()
+	if found {
+		ic.conn.Close()
+	}
+}
+
+// isHealthy reports whether `conn` is still open and has no unexpected data to read,
+// using a read that times out immediately. Only TCP connections are probed, since the read
+// could consume part of a TLS record or WebSocket frame of other connections, and break
+// them. The other connections are assumed healthy, and are bounded by the maximum idle time.
+func isHealthy(conn transport.StreamConn) bool {
+	if _, ok := conn.(*net.TCPConn); !ok {
+		return true
+	}
+	if err := conn.SetReadDeadline(time.Now()); err != nil {
+		return false
+	}
+	var b [1]byte
+	_, err := conn.Read(b[:])
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,248 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connpool
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+)
+
+// testServer accepts connections and keeps them until closed by the client,
+// unless closeAccepted is set.
+type testServer struct {
+	listener      *net.TCPListener
+	mu            sync.Mutex
+	accepted      []net.Conn
+	closeAccepted bool
+	// closedByClient receives a value when a client closes a connection.
+	closedByClient chan struct{}
+}
+
+func newTestServer(t *testing.T) *testServer {
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	s := &testServer{listener: listener, closedByClient: make(chan struct{}, 100)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.accepted = append(s.accepted, conn)
+			closeAccepted := s.closeAccepted
+			s.mu.Unlock()
+			if closeAccepted {
+				conn.Close()
+				continue
+			}
+			go func() {
+				io.Copy(io.Discard, conn)
+				s.closedByClient <- struct{}{}
+			}()
+		}
+	}()
+	return s
+}
+
+func (s *testServer) acceptedCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.accepted)
+}
+
+func (s *testServer) endpoint() transport.StreamEndpoint {
+	return &transport.TCPEndpoint{Address: s.listener.Addr().String()}
+}
+
+func (e *StreamEndpoint) idleCount() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.idle)
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for condition")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+type failingEndpoint struct{}
+
+func (failingEndpoint) Connect(ctx context.Context) (transport.StreamConn, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestNewStreamEndpoint_Errors(t *testing.T) {
+	if _, err := NewStreamEndpoint(nil, 1, time.Second); err == nil {
+		t.Error("Expected error for nil endpoint")
+	}
+	if _, err := NewStreamEndpoint(failingEndpoint{}, 0, time.Second); err == nil {
+		t.Error("Expected error for zero size")
+	}
+	if _, err := NewStreamEndpoint(failingEndpoint{}, 1, 0); err == nil {
+		t.Error("Expected error for zero idle time")
+	}
+}
+
+func TestStreamEndpoint_UsesPooledConnections(t *testing.T) {
+	server := newTestServer(t)
+	defer server.listener.Close()
+	e, _ := NewStreamEndpoint(server.endpoint(), 2, time.Minute)
+
+	conn, err := e.Connect(context.Background())
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer conn.Close()
+	// The first connection is direct, and fills the pool.
+	waitFor(t, func() bool { return e.idleCount() == 2 })
+	if n := server.acceptedCount(); n != 3 {
+		t.Errorf("Server accepted %d connections, want 3", n)
+	}
+
+	pooled, err := e.Connect(context.Background())
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer pooled.Close()
+	if _, err := pooled.Write([]byte("data")); err != nil {
+		t.Errorf("Write on pooled connection failed: %v", err)
+	}
+	// The pool is refilled, but never exceeds its size.
+	waitFor(t, func() bool { return server.acceptedCount() == 4 })
+	waitFor(t, func() bool { return e.idleCount() == 2 })
+}
+
+func TestStreamEndpoint_SkipsClosedConnections(t *testing.T) {
+	server := newTestServer(t)
+	defer server.listener.Close()
+	server.mu.Lock()
+	server.closeAccepted = true
+	server.mu.Unlock()
+	e, _ := NewStreamEndpoint(server.endpoint(), 1, time.Minute)
+
+	first, err := e.Connect(context.Background())
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	first.Close()
+	waitFor(t, func() bool { return e.idleCount() == 1 })
+	time.Sleep(50 * time.Millisecond) // Let the FIN from the server arrive.
+
+	server.mu.Lock()
+	server.closeAccepted = false
+	server.mu.Unlock()
+	conn, err := e.Connect(context.Background())
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer conn.Close()
+	// The pooled connection was closed by the server, so a new one must be made.
+	if !isHealthy(conn) {
+		t.Error("Expected a direct connection instead of the closed pooled one")
+	}
+}
+
+func TestStreamEndpoint_ExpiresIdleConnections(t *testing.T) {
+	server := newTestServer(t)
+	defer server.listener.Close()
+	e, _ := NewStreamEndpoint(server.endpoint(), 1, 50*time.Millisecond)
+
+	conn, err := e.Connect(context.Background())
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	waitFor(t, func() bool { return e.idleCount() == 1 })
+	select {
+	case <-server.closedByClient:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Idle connection was not closed")
+	}
+	if n := e.idleCount(); n != 0 {
+		t.Errorf("Got %d idle connections after expiry, want 0", n)
+	}
+	conn.Close()
+}
+
+func TestStreamEndpoint_OnNetworkChanged(t *testing.T) {
+	server := newTestServer(t)
+	defer server.listener.Close()
+	e, _ := NewStreamEndpoint(server.endpoint(), 1, time.Minute)
+
+	conn, err := e.Connect(context.Background())
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer conn.Close()
+	waitFor(t, func() bool { return e.idleCount() == 1 })
+
+	e.OnNetworkChanged()
+	if n := e.idleCount(); n != 0 {
+		t.Errorf("Got %d idle connections after network change, want 0", n)
+	}
+	select {
+	case <-server.closedByClient:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Idle connection was not closed")
+	}
+}
+
+func TestStreamEndpoint_ConnectFailure(t *testing.T) {
+	e, _ := NewStreamEndpoint(failingEndpoint{}, 2, time.Minute)
+	if _, err := e.Connect(context.Background()); err == nil {
+		t.Error("Expected Connect to fail")
+	}
+	waitFor(t, func() bool {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		return e.connecting == 0
+	})
+	if n := e.idleCount(); n != 0 {
+		t.Errorf("Got %d idle connections, want 0", n)
+	}
+}
+
+// readRecordingConn is a [transport.StreamConn] that records whether it was read.
+type readRecordingConn struct {
+	transport.StreamConn
+	read bool
+}
+
+func (c *readRecordingConn) Read(b []byte) (int, error) {
+	c.read = true
+	return 0, errors.New("unexpected read")
+}
+
+func TestIsHealthy_OnlyProbesTCP(t *testing.T) {
+	// Reading a TLS or WebSocket connection could consume part of a record or frame.
+	conn := &readRecordingConn{}
+	if !isHealthy(conn) || conn.read {
+		t.Errorf("isHealthy() read a non-TCP connection")
+	}
+}
@@ -0,0 +1,323 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package happyeyeballs connects to a proxy that may have several IP addresses,
+// racing the connection attempts as described in RFC 8305.
+package happyeyeballs
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+)
+
+// ConnectionAttemptDelay is the time to wait for a connection attempt before
+// starting the next one in parallel, as recommended by RFC 8305.
+const ConnectionAttemptDelay = 250 * time.Millisecond
+
+// LookupFunc returns the IP addresses of `host`.
+type LookupFunc func(ctx context.Context, host string) ([]net.IP, error)
+
+// DefaultLookup resolves `host` with the system resolver.
+func DefaultLookup(ctx context.Context, host string) ([]net.IP, error) {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, len(addrs))
+	for i, addr := range addrs {
+		ips[i] = addr.IP
+	}
+	return ips, nil
+}
+
+// Endpoint holds the resolved addresses of a proxy host. The address of the last
+// successful stream connection is tried first, and is used for packets.
+type Endpoint struct {
+	host   string
+	port   string
+	lookup LookupFunc
+
+	mu  sync.Mutex
+	ips []net.IP // Resolved addresses in connection order. Nil if not resolved.
+	// Incremented on network changes, so that stale resolutions are discarded.
+	network int
+
+	// connectStream connects to a single address. Replaced in tests.
+	connectStream func(ctx context.Context, address string) (transport.StreamConn, error)
+}
+
+// NewEndpoint creates an [Endpoint] for `host:port`, where `host` is an IP address or a
+// domain name resolved with `lookup`.
+func NewEndpoint(host string, port int, lookup LookupFunc) *Endpoint {
+	return &Endpoint{
+		host:   host,
+		port:   strconv.Itoa(port),
+		lookup: lookup,
+		connectStream: func(ctx context.Context, address string) (transport.StreamConn, error) {
+			return (&transport.TCPEndpoint{Address: address}).Connect(ctx)
+		},
+	}
+}
+
+// Resolve looks up the addresses of the host, replacing the previous ones.
+func (e *Endpoint) Resolve(ctx context.Context) error {
+	ips, err := e.resolve(ctx)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	e.ips = ips
+	e.mu.Unlock()
+	return nil
+}
+
+// resolve returns the addresses of the host in connection order.
+func (e *Endpoint) resolve(ctx context.Context) ([]net.IP, error) {
+	if ip := net.ParseIP(e.host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	ips, err := e.lookup(ctx, e.host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %v: %w", e.host, err)
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no addresses found for %v", e.host)
+	}
+	return interleave(ips), nil
+}
+
+// networkChangeResolveTimeout bounds the resolution of the host after a network change.
+const networkChangeResolveTimeout = 5 * time.Second
+
+// OnNetworkChanged resolves the host again in the background, since its addresses and the
+// preferred one may differ in the new network. The previous addresses are kept if resolution
+// fails. Returns immediately, since it's called from the platform callbacks.
+func (e *Endpoint) OnNetworkChanged() {
+	e.refresh()
+}
+
+// refresh starts the resolution of OnNetworkChanged, and returns a channel that is closed
+// when it completes.
+func (e *Endpoint) refresh() <-chan struct{} {
+	e.mu.Lock()
+	e.network++
+	network := e.network
+	e.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ctx, cancel := context.WithTimeout(context.Background(), networkChangeResolveTimeout)
+		defer cancel()
+		ips, err := e.resolve(ctx)
+		if err != nil {
+			return
+		}
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		// A later network change supersedes this resolution.
+		if e.network == network {
+			e.ips = ips
+		}
+	}()
+	return done
+}
+
+// Addresses returns the "ip:port" addresses to connect to, in order of preference.
+// Resolves the host if needed.
+func (e *Endpoint) Addresses(ctx context.Context) ([]string, error) {
+	e.mu.Lock()
+	resolved := e.ips != nil
+	e.mu.Unlock()
+	if !resolved {
+		if err := e.Resolve(ctx); err != nil {
+			return nil, err
+		}
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	addresses := make([]string, len(e.ips))
+	for i, ip := range e.ips {
+		addresses[i] = net.JoinHostPort(ip.String(), e.port)
+	}
+	return addresses, nil
+}
+
+// prefer moves `address` to the front of the connection order.
+func (e *Endpoint) prefer(address string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for i, ip := range e.ips {
+		if net.JoinHostPort(ip.String(), e.port) == address {
+			copy(e.ips[1:i+1], e.ips[:i])
+			e.ips[0] = ip
+			return
+		}
+	}
+}
+
+// invalidate forces the host to be resolved again on the next connection.
+func (e *Endpoint) invalidate() {
+	e.mu.Lock()
+	e.ips = nil
+	e.mu.Unlock()
+}
+
+// ConnectStream races connections to all the addresses and returns the first to succeed.
+// If they all fail, the host is resolved again and the race is retried once.
+func (e *Endpoint) ConnectStream(ctx context.Context) (transport.StreamConn, error) {
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		var addresses []string
+		if addresses, err = e.Addresses(ctx); err != nil {
+			return nil, err
+		}
+		var conn transport.StreamConn
+		var address string
+		if conn, address, err = e.race(ctx, addresses); err == nil {
+			e.prefer(address)
+			return conn, nil
+		}
+		e.invalidate()
+		if ctx.Err() != nil || net.ParseIP(e.host) != nil {
+			// Nothing to gain from resolving again.
+			break
+		}
+	}
+	return nil, err
+}
+
+// ConnectPacket connects a UDP socket to the preferred address.
+func (e *Endpoint) ConnectPacket(ctx context.Context) (net.Conn, error) {
+	addresses, err := e.Addresses(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return (&transport.UDPEndpoint{Address: addresses[0]}).Connect(ctx)
+}
+
+// race starts a connection attempt to each address in order, starting the next one when
+// the previous fails or after [ConnectionAttemptDelay]. Returns the first connection
+// established and its address, after canceling the other attempts.
+func (e *Endpoint) race(ctx context.Context, addresses []string) (transport.StreamConn, string, error) {
+	raceCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		conn    transport.StreamConn
+		address string
+		err     error
+	}
+	results := make(chan result, len(addresses))
+	var lastErr error
+	next, pending := 0, 0
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			if next < len(addresses) {
+				address := addresses[next]
+				next++
+				pending++
+				go func() {
+					conn, err := e.connectStream(raceCtx, address)
+					results <- result{conn, address, err}
+				}()
+				timer.Reset(ConnectionAttemptDelay)
+			}
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				cancel()
+				// Close the connections that complete after the winner.
+				go func(n int) {
+					for ; n > 0; n-- {
+						if late := <-results; late.err == nil {
+							late.conn.Close()
+						}
+					}
+				}(pending)
+				return r.conn, r.address, nil
+			}
+			lastErr = r.err
+			if pending == 0 && next == len(addresses) {
+				return nil, "", fmt.Errorf("failed to connect to any of the %d addresses of %v: %w", len(addresses), e.host, lastErr)
+			}
+			if next < len(addresses) {
+				// Start the next attempt right away.
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				timer.Reset(0)
+			}
+		}
+	}
+}
+
+// interleave orders `ips` alternating address families, starting with the
+// family of the first address, as described in RFC 8305 section 4.
+func interleave(ips []net.IP) []net.IP {
+	var first, second []net.IP
+	firstIsV4 := ips[0].To4() != nil
+	for _, ip := range ips {
+		if (ip.To4() != nil) == firstIsV4 {
+			first = append(first, ip)
+		} else {
+			second = append(second, ip)
+		}
+	}
+	ordered := make([]net.IP, 0, len(ips))
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			ordered = append(ordered, first[i])
+		}
+		if i < len(second) {
+			ordered = append(ordered, second[i])
+		}
+	}
+	return ordered
+}
+
+// StreamEndpoint is a [transport.StreamEndpoint] that races the addresses of an [Endpoint].
+type StreamEndpoint struct {
+	*Endpoint
+}
+
+var _ transport.StreamEndpoint = (*StreamEndpoint)(nil)
+
+// Connect implements [transport.StreamEndpoint.Connect].
+func (e *StreamEndpoint) Connect(ctx context.Context) (transport.StreamConn, error) {
+	return e.ConnectStream(ctx)
+}
+
+// PacketEndpoint is a [transport.PacketEndpoint] that uses the preferred address of an [Endpoint].
+type PacketEndpoint struct {
+	*Endpoint
+}
+
+var _ transport.PacketEndpoint = (*PacketEndpoint)(nil)
+
+// Connect implements [transport.PacketEndpoint.Connect].
+func (e *PacketEndpoint) Connect(ctx context.Context) (net.Conn, error) {
+	return e.ConnectPacket(ctx)
+}
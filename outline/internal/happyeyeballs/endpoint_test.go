@@ -0,0 +1,320 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package happyeyeballs
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+)
+
+func parseIPs(ips ...string) []net.IP {
+	parsed := make([]net.IP, len(ips))
+	for i, ip := range ips {
+		parsed[i] = net.ParseIP(ip)
+	}
+	return parsed
+}
+
+// fakeLookup returns `ips` and counts the lookups.
+type fakeLookup struct {
+	mu    sync.Mutex
+	ips   []net.IP
+	count int
+}
+
+func (l *fakeLookup) lookup(ctx context.Context, host string) ([]net.IP, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.count++
+	if len(l.ips) == 0 {
+		return nil, errors.New("no such host")
+	}
+	return l.ips, nil
+}
+
+// fakeConn is a StreamConn that records whether it was closed.
+type fakeConn struct {
+	transport.StreamConn
+	address string
+	closed  chan struct{}
+}
+
+func (c *fakeConn) Close() error {
+	close(c.closed)
+	return nil
+}
+
+// fakeConnector succeeds to connect to the addresses in `delays` after the given delay,
+// and fails for all other addresses.
+type fakeConnector struct {
+	delays   map[string]time.Duration
+	mu       sync.Mutex
+	attempts []string
+}
+
+func (c *fakeConnector) connect(ctx context.Context, address string) (transport.StreamConn, error) {
+	c.mu.Lock()
+	c.attempts = append(c.attempts, address)
+	c.mu.Unlock()
+	delay, ok := c.delays[address]
+	if !ok {
+		return nil, errors.New("connection refused")
+	}
+	time.Sleep(delay)
+	return &fakeConn{address: address, closed: make(chan struct{})}, nil
+}
+
+func newTestEndpoint(lookup *fakeLookup, connector *fakeConnector) *Endpoint {
+	e := NewEndpoint("proxy.example", 443, lookup.lookup)
+	e.connectStream = connector.connect
+	return e
+}
+
+func TestInterleave(t *testing.T) {
+	got := interleave(parseIPs("2001:db8::1", "2001:db8::2", "2001:db8::3", "192.0.2.1", "192.0.2.2"))
+	want := parseIPs("2001:db8::1", "192.0.2.1", "2001:db8::2", "192.0.2.2", "2001:db8::3")
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("interleave() = %v, want %v", got, want)
+	}
+}
+
+func TestEndpoint_Addresses(t *testing.T) {
+	lookup := &fakeLookup{ips: parseIPs("192.0.2.1", "2001:db8::1")}
+	e := newTestEndpoint(lookup, &fakeConnector{})
+	got, err := e.Addresses(context.Background())
+	if err != nil {
+		t.Fatalf("Addresses failed: %v", err)
+	}
+	if want := []string{"192.0.2.1:443", "[2001:db8::1]:443"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Addresses() = %v, want %v", got, want)
+	}
+	e.Addresses(context.Background())
+	if lookup.count != 1 {
+		t.Errorf("Got %d lookups, want 1", lookup.count)
+	}
+}
+
+func TestEndpoint_IPHost(t *testing.T) {
+	lookup := &fakeLookup{}
+	e := NewEndpoint("2001:db8::1", 8080, lookup.lookup)
+	got, err := e.Addresses(context.Background())
+	if err != nil {
+		t.Fatalf("Addresses failed: %v", err)
+	}
+	if want := []string{"[2001:db8::1]:8080"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Addresses() = %v, want %v", got, want)
+	}
+	if lookup.count != 0 {
+		t.Errorf("Got %d lookups, want 0", lookup.count)
+	}
+}
+
+func TestEndpoint_ResolveFailure(t *testing.T) {
+	e := newTestEndpoint(&fakeLookup{}, &fakeConnector{})
+	if _, err := e.ConnectStream(context.Background()); err == nil {
+		t.Error("Expected resolution error")
+	}
+}
+
+func TestEndpoint_ConnectStream_FallsBackOnFailure(t *testing.T) {
+	lookup := &fakeLookup{ips: parseIPs("2001:db8::1", "192.0.2.1")}
+	connector := &fakeConnector{delays: map[string]time.Duration{"192.0.2.1:443": 0}}
+	e := newTestEndpoint(lookup, connector)
+
+	start := time.Now()
+	conn, err := e.ConnectStream(context.Background())
+	if err != nil {
+		t.Fatalf("ConnectStream failed: %v", err)
+	}
+	if address := conn.(*fakeConn).address; address != "192.0.2.1:443" {
+		t.Errorf("Connected to %v, want 192.0.2.1:443", address)
+	}
+	// The failure of the first address must start the next attempt without waiting.
+	if elapsed := time.Since(start); elapsed >= ConnectionAttemptDelay {
+		t.Errorf("Connection took %v, want less than %v", elapsed, ConnectionAttemptDelay)
+	}
+	// The winner is remembered.
+	if got, _ := e.Addresses(context.Background()); got[0] != "192.0.2.1:443" {
+		t.Errorf("Preferred address is %v, want 192.0.2.1:443", got[0])
+	}
+}
+
+func TestEndpoint_ConnectStream_RacesSlowAddress(t *testing.T) {
+	lookup := &fakeLookup{ips: parseIPs("2001:db8::1", "192.0.2.1")}
+	connector := &fakeConnector{delays: map[string]time.Duration{
+		"[2001:db8::1]:443": time.Second,
+		"192.0.2.1:443":     0,
+	}}
+	e := newTestEndpoint(lookup, connector)
+
+	start := time.Now()
+	conn, err := e.ConnectStream(context.Background())
+	if err != nil {
+		t.Fatalf("ConnectStream failed: %v", err)
+	}
+	if address := conn.(*fakeConn).address; address != "192.0.2.1:443" {
+		t.Errorf("Connected to %v, want 192.0.2.1:443", address)
+	}
+	if elapsed := time.Since(start); elapsed < ConnectionAttemptDelay || elapsed >= time.Second {
+		t.Errorf("Connection took %v, want between %v and 1s", elapsed, ConnectionAttemptDelay)
+	}
+}
+
+func TestEndpoint_ConnectStream_ClosesLateConnections(t *testing.T) {
+	lookup := &fakeLookup{ips: parseIPs("192.0.2.1", "192.0.2.2")}
+	connector := &fakeConnector{delays: map[string]time.Duration{
+		"192.0.2.1:443": ConnectionAttemptDelay + 100*time.Millisecond,
+		"192.0.2.2:443": 0,
+	}}
+	lateConns := make(chan *fakeConn, 1)
+	e := newTestEndpoint(lookup, connector)
+	e.connectStream = func(ctx context.Context, address string) (transport.StreamConn, error) {
+		conn, err := connector.connect(ctx, address)
+		if address == "192.0.2.1:443" {
+			lateConns <- conn.(*fakeConn)
+		}
+		return conn, err
+	}
+	if _, err := e.ConnectStream(context.Background()); err != nil {
+		t.Fatalf("ConnectStream failed: %v", err)
+	}
+	late := <-lateConns
+	select {
+	case <-late.closed:
+	case <-time.After(time.Second):
+		t.Error("Late connection was not closed")
+	}
+}
+
+func TestEndpoint_ConnectStream_ResolvesAgainOnFailure(t *testing.T) {
+	lookup := &fakeLookup{ips: parseIPs("192.0.2.1")}
+	connector := &fakeConnector{delays: map[string]time.Duration{"192.0.2.2:443": 0}}
+	e := newTestEndpoint(lookup, connector)
+	if _, err := e.Addresses(context.Background()); err != nil {
+		t.Fatalf("Addresses failed: %v", err)
+	}
+
+	// The proxy moved to a new address.
+	lookup.mu.Lock()
+	lookup.ips = parseIPs("192.0.2.2")
+	lookup.mu.Unlock()
+	conn, err := e.ConnectStream(context.Background())
+	if err != nil {
+		t.Fatalf("ConnectStream failed: %v", err)
+	}
+	if address := conn.(*fakeConn).address; address != "192.0.2.2:443" {
+		t.Errorf("Connected to %v, want 192.0.2.2:443", address)
+	}
+	if lookup.count != 2 {
+		t.Errorf("Got %d lookups, want 2", lookup.count)
+	}
+}
+
+func TestEndpoint_ConnectStream_AllFail(t *testing.T) {
+	lookup := &fakeLookup{ips: parseIPs("192.0.2.1", "2001:db8::1")}
+	connector := &fakeConnector{}
+	e := newTestEndpoint(lookup, connector)
+	if _, err := e.ConnectStream(context.Background()); err == nil {
+		t.Fatal("Expected ConnectStream to fail")
+	}
+	// Two races over both addresses, with a lookup before each.
+	if len(connector.attempts) != 4 || lookup.count != 2 {
+		t.Errorf("Got %d attempts and %d lookups, want 4 and 2", len(connector.attempts), lookup.count)
+	}
+}
+
+func TestEndpoint_ConnectPacket(t *testing.T) {
+	server, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer server.Close()
+	port := server.LocalAddr().(*net.UDPAddr).Port
+	e := NewEndpoint("127.0.0.1", port, (&fakeLookup{}).lookup)
+	conn, err := (&PacketEndpoint{e}).Connect(context.Background())
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer conn.Close()
+	if conn.RemoteAddr().String() != server.LocalAddr().String() {
+		t.Errorf("Connected to %v, want %v", conn.RemoteAddr(), server.LocalAddr())
+	}
+}
+
+func TestEndpoint_OnNetworkChanged(t *testing.T) {
+	lookup := &fakeLookup{ips: parseIPs("192.0.2.1", "192.0.2.2")}
+	connector := &fakeConnector{delays: map[string]time.Duration{"192.0.2.2:443": 0}}
+	e := newTestEndpoint(lookup, connector)
+	if _, err := e.ConnectStream(context.Background()); err != nil {
+		t.Fatalf("ConnectStream failed: %v", err)
+	}
+
+	// The new network has a different address, and the previous preference is dropped.
+	lookup.mu.Lock()
+	lookup.ips = parseIPs("192.0.2.1", "192.0.2.3")
+	lookup.mu.Unlock()
+	<-e.refresh()
+	got, _ := e.Addresses(context.Background())
+	if want := []string{"192.0.2.1:443", "192.0.2.3:443"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Addresses() = %v, want %v", got, want)
+	}
+
+	// Failed resolutions keep the previous addresses.
+	lookup.mu.Lock()
+	lookup.ips = nil
+	lookup.mu.Unlock()
+	<-e.refresh()
+	if got, _ := e.Addresses(context.Background()); len(got) != 2 {
+		t.Errorf("Addresses() = %v after failed resolution, want the previous ones", got)
+	}
+}
+
+func TestEndpoint_OnNetworkChanged_Background(t *testing.T) {
+	release := make(chan struct{})
+	e := NewEndpoint("proxy.example", 443, func(ctx context.Context, host string) ([]net.IP, error) {
+		<-release
+		return parseIPs("192.0.2.1"), nil
+	})
+	done := make(chan struct{})
+	go func() {
+		e.OnNetworkChanged()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Error("OnNetworkChanged() blocked on the resolution")
+	}
+
+	// A resolution started before the last network change is discarded.
+	stale := e.refresh()
+	e.mu.Lock()
+	e.network++
+	e.mu.Unlock()
+	close(release)
+	<-stale
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.ips != nil {
+		t.Errorf("Got addresses %v from a stale resolution", e.ips)
+	}
+}
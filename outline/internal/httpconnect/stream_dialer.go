@@ -0,0 +1,141 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package httpconnect implements a client for HTTP proxies that tunnels streams
+// with the CONNECT method (RFC 9110, section 9.3.6).
+package httpconnect
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline"
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+)
+
+// ErrAuthenticationFailed is returned when the proxy responds with
+// 407 Proxy Authentication Required. It wraps [outline.ErrProxyAuthentication].
+var ErrAuthenticationFailed = fmt.Errorf("HTTP %w", outline.ErrProxyAuthentication)
+
+// Credentials for the Basic authentication scheme.
+type Credentials struct {
+	Username string
+	Password string
+}
+
+// StreamDialer is a [transport.StreamDialer] that tunnels streams through an HTTP proxy.
+type StreamDialer struct {
+	endpoint    transport.StreamEndpoint
+	tlsConfig   *tls.Config
+	credentials *Credentials
+}
+
+var _ transport.StreamDialer = (*StreamDialer)(nil)
+
+// NewStreamDialer creates a [StreamDialer] for the proxy at `endpoint`. The connections
+// to the proxy use TLS if `tlsConfig` is not nil. `credentials` may be nil if the proxy
+// doesn't require authentication.
+func NewStreamDialer(endpoint transport.StreamEndpoint, tlsConfig *tls.Config, credentials *Credentials) (*StreamDialer, error) {
+	if endpoint == nil {
+		return nil, errors.New("argument endpoint must not be nil")
+	}
+	if credentials != nil && len(credentials.Username) == 0 {
+		return nil, errors.New("username must not be empty")
+	}
+	return &StreamDialer{endpoint: endpoint, tlsConfig: tlsConfig, credentials: credentials}, nil
+}
+
+// Dial implements [transport.StreamDialer.Dial].
+func (d *StreamDialer) Dial(ctx context.Context, remoteAddr string) (transport.StreamConn, error) {
+	if _, _, err := net.SplitHostPort(remoteAddr); err != nil {
+		return nil, fmt.Errorf("invalid address %v: %w", remoteAddr, err)
+	}
+	conn, err := d.endpoint.Connect(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to HTTP proxy: %w", err)
+	}
+	success := false
+	defer func() {
+		if !success {
+			conn.Close()
+		}
+	}()
+	// Bound the handshakes by the context deadline, if any.
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+	if d.tlsConfig != nil {
+		tlsConn := tls.Client(conn, d.tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return nil, fmt.Errorf("TLS handshake failed: %w", err)
+		}
+		conn = &tlsStreamConn{Conn: tlsConn, base: conn}
+	}
+
+	req := "CONNECT " + remoteAddr + " HTTP/1.1\r\nHost: " + remoteAddr + "\r\n"
+	if d.credentials != nil {
+		userPass := d.credentials.Username + ":" + d.credentials.Password
+		req += "Proxy-Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(userPass)) + "\r\n"
+	}
+	req += "\r\n"
+	if _, err := io.WriteString(conn, req); err != nil {
+		return nil, fmt.Errorf("failed to write CONNECT request: %w", err)
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CONNECT response: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusProxyAuthRequired {
+		return nil, fmt.Errorf("%w: %v", ErrAuthenticationFailed, resp.Status)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("HTTP proxy refused the connection: %v", resp.Status)
+	}
+
+	success = true
+	if reader.Buffered() > 0 {
+		// The target sent data along with the response.
+		return &bufferedStreamConn{StreamConn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+// tlsStreamConn adapts a TLS connection to [transport.StreamConn].
+type tlsStreamConn struct {
+	*tls.Conn
+	base transport.StreamConn
+}
+
+func (c *tlsStreamConn) CloseRead() error { return c.base.CloseRead() }
+
+// bufferedStreamConn is a [transport.StreamConn] that reads from `reader` first.
+type bufferedStreamConn struct {
+	transport.StreamConn
+	reader io.Reader
+}
+
+func (c *bufferedStreamConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
@@ -0,0 +1,137 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpconnect
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+)
+
+// testProxy is an HTTP proxy stand-in that echoes the CONNECT streams, prefixed by
+// `greeting`. It requires `credentials` if not nil, and refuses connections to port 0.
+type testProxy struct {
+	credentials *Credentials
+	greeting    string
+}
+
+func (p *testProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodConnect {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if p.credentials != nil {
+		want := "Basic " + base64.StdEncoding.EncodeToString([]byte(p.credentials.Username+":"+p.credentials.Password))
+		if r.Header.Get("Proxy-Authorization") != want {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+	}
+	if strings.HasSuffix(r.Host, ":0") {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	conn, rw, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n"+p.greeting)
+	io.Copy(conn, rw)
+}
+
+func startProxy(t *testing.T, proxy *testProxy, useTLS bool) (transport.StreamEndpoint, *tls.Config) {
+	var server *httptest.Server
+	var tlsConfig *tls.Config
+	if useTLS {
+		server = httptest.NewTLSServer(proxy)
+		tlsConfig = server.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+		// The test certificate is valid for example.com.
+		tlsConfig.ServerName = "example.com"
+	} else {
+		server = httptest.NewServer(proxy)
+	}
+	t.Cleanup(server.Close)
+	return &transport.TCPEndpoint{Address: server.Listener.Addr().String()}, tlsConfig
+}
+
+func TestNewStreamDialer_Errors(t *testing.T) {
+	if _, err := NewStreamDialer(nil, nil, nil); err == nil {
+		t.Error("Expected error for nil endpoint")
+	}
+	endpoint := &transport.TCPEndpoint{Address: "127.0.0.1:8080"}
+	if _, err := NewStreamDialer(endpoint, nil, &Credentials{Password: "secret"}); err == nil {
+		t.Error("Expected error for empty username")
+	}
+}
+
+func TestStreamDialer_Dial(t *testing.T) {
+	credentials := &Credentials{Username: "user", Password: "secret"}
+	tests := []struct {
+		name        string
+		proxy       *testProxy
+		tls         bool
+		credentials *Credentials
+		target      string
+		wantErr     error
+	}{
+		{name: "plain", proxy: &testProxy{}, target: "example.com:443"},
+		{name: "TLS", proxy: &testProxy{}, tls: true, target: "[2001:db8::1]:443"},
+		{name: "basic auth", proxy: &testProxy{credentials: credentials}, tls: true, credentials: credentials, target: "example.com:443"},
+		{name: "early data", proxy: &testProxy{greeting: "greeting "}, target: "example.com:443"},
+		{name: "wrong password", proxy: &testProxy{credentials: credentials}, credentials: &Credentials{"user", "wrong"}, target: "example.com:443", wantErr: ErrAuthenticationFailed},
+		{name: "missing credentials", proxy: &testProxy{credentials: credentials}, target: "example.com:443", wantErr: ErrAuthenticationFailed},
+		{name: "refused", proxy: &testProxy{}, target: "example.com:0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			endpoint, tlsConfig := startProxy(t, tt.proxy, tt.tls)
+			dialer, err := NewStreamDialer(endpoint, tlsConfig, tt.credentials)
+			if err != nil {
+				t.Fatalf("NewStreamDialer() failed: %v", err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			conn, err := dialer.Dial(ctx, tt.target)
+			if strings.HasSuffix(tt.target, ":0") || tt.wantErr != nil {
+				if err == nil || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+					t.Errorf("Dial() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Dial() failed: %v", err)
+			}
+			defer conn.Close()
+			io.WriteString(conn, "hello")
+			if err := conn.CloseWrite(); err != nil {
+				t.Errorf("CloseWrite() failed: %v", err)
+			}
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			if got, err := io.ReadAll(conn); err != nil || string(got) != tt.proxy.greeting+"hello" {
+				t.Errorf("Got %q, %v, want %q", got, err, tt.proxy.greeting+"hello")
+			}
+		})
+	}
+}
@@ -0,0 +1,285 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mux carries many logical streams over a small pool of proxy connections.
+//
+// It implements the client side of the sing-box multiplex protocol with yamux,
+// which provides per-stream flow control and half-close. A session is opened by
+// dialing the magic address "sp.mux.sing-box.arpa:444" through the proxy and sending
+// the version (0x00) and protocol (0x01 for yamux) bytes. Each yamux stream then
+// starts with a request made of 2 bytes of big-endian flags (0 for TCP) and the
+// SOCKS address of the destination. The server answers with a status byte: 0x00 for
+// success, or 0x01 followed by a uvarint-prefixed error message.
+package mux
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/hashicorp/yamux"
+	"github.com/shadowsocks/go-shadowsocks2/socks"
+)
+
+// MagicAddress is the stream destination that signals a multiplexed session to the proxy.
+const MagicAddress = "sp.mux.sing-box.arpa:444"
+
+const (
+	sessionVersion = 0x00
+	protocolYAMux  = 0x01
+	statusSuccess  = 0x00
+	statusError    = 0x01
+	// Bounds the memory used for error messages from the server.
+	maxErrorMessageSize = 1024
+	// Streams that are not closed by the peer are reset after this long.
+	streamTimeout = 5 * time.Minute
+)
+
+// StreamDialer is a [transport.StreamDialer] that multiplexes the dialed streams over
+// a pool of sessions, each carried by a single stream of the base dialer.
+type StreamDialer struct {
+	dialer         transport.StreamDialer
+	maxConnections int
+	maxStreams     int
+
+	mu       sync.Mutex
+	sessions []*yamux.Session
+	// Number of sessions being dialed.
+	dialing int
+}
+
+var _ transport.StreamDialer = (*StreamDialer)(nil)
+
+// NewStreamDialer creates a [StreamDialer] that opens at most `maxConnections` sessions
+// over `dialer`. A new session is only opened when every session carries at least
+// `maxStreams` streams.
+func NewStreamDialer(dialer transport.StreamDialer, maxConnections, maxStreams int) (*StreamDialer, error) {
+	if dialer == nil {
+		return nil, errors.New("argument dialer must not be nil")
+	}
+	if maxConnections <= 0 {
+		return nil, fmt.Errorf("maximum number of connections must be positive, got %d", maxConnections)
+	}
+	if maxStreams <= 0 {
+		return nil, fmt.Errorf("maximum number of streams must be positive, got %d", maxStreams)
+	}
+	return &StreamDialer{dialer: dialer, maxConnections: maxConnections, maxStreams: maxStreams}, nil
+}
+
+// Dial implements [transport.StreamDialer.Dial] by opening a stream to `remoteAddr` in
+// one of the pooled sessions.
+func (d *StreamDialer) Dial(ctx context.Context, remoteAddr string) (transport.StreamConn, error) {
+	request := socks.ParseAddr(remoteAddr)
+	if request == nil {
+		return nil, fmt.Errorf("failed to parse address %v", remoteAddr)
+	}
+	// Flags for a TCP stream, followed by the destination.
+	request = append([]byte{0, 0}, request...)
+
+	// Retry once on a new session, in case the peer closed the selected one.
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		var session *yamux.Session
+		session, err = d.session(ctx)
+		if err != nil {
+			return nil, err
+		}
+		var stream *yamux.Stream
+		stream, err = session.OpenStream()
+		if err != nil {
+			session.Close()
+			continue
+		}
+		if _, err = stream.Write(request); err != nil {
+			stream.Close()
+			return nil, fmt.Errorf("failed to send stream request: %w", err)
+		}
+		return &streamConn{Stream: stream}, nil
+	}
+	return nil, fmt.Errorf("failed to open stream: %w", err)
+}
+
+// OnNetworkChanged closes the pooled sessions, which are unlikely to survive the
+// network change. New sessions are opened on demand.
+func (d *StreamDialer) OnNetworkChanged() {
+	d.mu.Lock()
+	sessions := d.sessions
+	d.sessions = nil
+	d.mu.Unlock()
+	for _, session := range sessions {
+		session.Close()
+	}
+}
+
+// session returns the least loaded open session, or a new one if they are all full
+// and the pool is not. The new session is dialed without holding the lock, so that
+// concurrent dials are not blocked by it.
+func (d *StreamDialer) session(ctx context.Context) (*yamux.Session, error) {
+	d.mu.Lock()
+	best := d.leastLoaded()
+	if best != nil && (best.NumStreams() < d.maxStreams || len(d.sessions)+d.dialing >= d.maxConnections) {
+		d.mu.Unlock()
+		return best, nil
+	}
+	d.dialing++
+	d.mu.Unlock()
+
+	session, err := d.newSession(ctx)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dialing--
+	if err != nil {
+		if best = d.leastLoaded(); best != nil {
+			// Overload an existing session rather than fail.
+			return best, nil
+		}
+		return nil, err
+	}
+	if len(d.sessions) >= d.maxConnections {
+		// Concurrent dials filled the pool in the meantime.
+		if best = d.leastLoaded(); best != nil {
+			session.Close()
+			return best, nil
+		}
+	}
+	d.sessions = append(d.sessions, session)
+	return session, nil
+}
+
+// leastLoaded removes the closed sessions from the pool, and returns the open session
+// with the fewest streams, or nil if there's none. d.mu must be held.
+func (d *StreamDialer) leastLoaded() *yamux.Session {
+	var best *yamux.Session
+	open := d.sessions[:0]
+	for _, session := range d.sessions {
+		if session.IsClosed() {
+			continue
+		}
+		open = append(open, session)
+		if best == nil || session.NumStreams() < best.NumStreams() {
+			best = session
+		}
+	}
+	d.sessions = open
+	return best
+}
+
+func (d *StreamDialer) newSession(ctx context.Context) (*yamux.Session, error) {
+	conn, err := d.dialer.Dial(ctx, MagicAddress)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to multiplexing proxy: %w", err)
+	}
+	if _, err := conn.Write([]byte{sessionVersion, protocolYAMux}); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to send session request: %w", err)
+	}
+	config := yamux.DefaultConfig()
+	config.LogOutput = io.Discard
+	config.StreamOpenTimeout = streamTimeout
+	config.StreamCloseTimeout = streamTimeout
+	session, err := yamux.Client(conn, config)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to start session: %w", err)
+	}
+	return session, nil
+}
+
+// streamConn adapts a yamux stream to [transport.StreamConn], reading the
+// server's response before the first payload.
+type streamConn struct {
+	*yamux.Stream
+	responseOnce sync.Once
+	responseErr  error
+	readOnce     sync.Once
+}
+
+var _ transport.StreamConn = (*streamConn)(nil)
+
+func (c *streamConn) Read(b []byte) (int, error) {
+	c.responseOnce.Do(func() {
+		c.responseErr = readResponse(c.Stream)
+	})
+	if c.responseErr != nil {
+		return 0, c.responseErr
+	}
+	return c.Stream.Read(b)
+}
+
+// CloseRead drains the rest of the stream in the background, so that the peer isn't
+// blocked by the flow control window.
+func (c *streamConn) CloseRead() error {
+	c.readOnce.Do(func() {
+		go io.Copy(io.Discard, c)
+	})
+	return nil
+}
+
+// CloseWrite sends a FIN to the peer. The stream is released once the peer closes it too.
+func (c *streamConn) CloseWrite() error {
+	return c.Stream.Close()
+}
+
+// Close closes both directions of the stream. Pending and future reads fail immediately,
+// and the stream is released once the peer closes its side too.
+func (c *streamConn) Close() error {
+	c.Stream.SetReadDeadline(time.Now())
+	return c.Stream.Close()
+}
+
+// readResponse reads the server's response to a stream request.
+func readResponse(r io.Reader) error {
+	var status [1]byte
+	if _, err := io.ReadFull(r, status[:]); err != nil {
+		return fmt.Errorf("failed to read stream response: %w", err)
+	}
+	switch status[0] {
+	case statusSuccess:
+		return nil
+	case statusError:
+		// Don't buffer, so the reads stop at the end of the message.
+		br := byteReader{r}
+		length, err := binary.ReadUvarint(br)
+		if err != nil {
+			return fmt.Errorf("failed to read stream error: %w", err)
+		}
+		if length > maxErrorMessageSize {
+			return fmt.Errorf("stream error message of %d bytes is too long", length)
+		}
+		message := make([]byte, length)
+		if _, err := io.ReadFull(br, message); err != nil {
+			return fmt.Errorf("failed to read stream error: %w", err)
+		}
+		return fmt.Errorf("proxy failed to open stream: %s", message)
+	default:
+		return fmt.Errorf("invalid stream response status %d", status[0])
+	}
+}
+
+// byteReader reads bytes one at a time from an unbuffered reader.
+type byteReader struct {
+	io.Reader
+}
+
+func (r byteReader) ReadByte() (byte, error) {
+	var b [1]byte
+	_, err := io.ReadFull(r.Reader, b[:])
+	return b[0], err
+}
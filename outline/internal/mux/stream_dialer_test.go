@@ -0,0 +1,315 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mux
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/hashicorp/yamux"
+	"github.com/shadowsocks/go-shadowsocks2/socks"
+)
+
+// fakeProxy is a StreamDialer that connects to an in-process multiplexing server.
+// The server echoes every stream, and rejects streams to port 0.
+type fakeProxy struct {
+	t         *testing.T
+	mu        sync.Mutex
+	addresses []string
+}
+
+func (p *fakeProxy) dialCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.addresses)
+}
+
+func (p *fakeProxy) Dial(ctx context.Context, address string) (transport.StreamConn, error) {
+	p.mu.Lock()
+	p.addresses = append(p.addresses, address)
+	p.mu.Unlock()
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		var header [2]byte
+		if _, err := io.ReadFull(conn, header[:]); err != nil || header != [2]byte{sessionVersion, protocolYAMux} {
+			p.t.Errorf("Got session request %v, %v", header, err)
+			conn.Close()
+			return
+		}
+		config := yamux.DefaultConfig()
+		config.LogOutput = io.Discard
+		session, err := yamux.Server(conn, config)
+		if err != nil {
+			p.t.Errorf("Failed to start server session: %v", err)
+			return
+		}
+		for {
+			stream, err := session.AcceptStream()
+			if err != nil {
+				return
+			}
+			go serveStream(stream)
+		}
+	}()
+	return (&transport.TCPStreamDialer{}).Dial(ctx, listener.Addr().String())
+}
+
+func serveStream(stream *yamux.Stream) {
+	defer stream.Close()
+	var flags [2]byte
+	if _, err := io.ReadFull(stream, flags[:]); err != nil {
+		return
+	}
+	target, err := socks.ReadAddr(stream)
+	if err != nil {
+		return
+	}
+	if _, port, _ := net.SplitHostPort(target.String()); port == "0" {
+		message := "connection refused"
+		response := make([]byte, 1+binary.MaxVarintLen64)
+		response[0] = statusError
+		n := binary.PutUvarint(response[1:], uint64(len(message)))
+		stream.Write(append(response[:1+n], message...))
+		return
+	}
+	stream.Write([]byte{statusSuccess})
+	io.Copy(stream, stream)
+}
+
+type failingDialer struct{}
+
+func (failingDialer) Dial(ctx context.Context, address string) (transport.StreamConn, error) {
+	return nil, errors.New("dial failed")
+}
+
+func TestNewStreamDialer_Errors(t *testing.T) {
+	if _, err := NewStreamDialer(nil, 1, 1); err == nil {
+		t.Error("Expected error for nil dialer")
+	}
+	if _, err := NewStreamDialer(failingDialer{}, 0, 1); err == nil {
+		t.Error("Expected error for zero connections")
+	}
+	if _, err := NewStreamDialer(failingDialer{}, 1, 0); err == nil {
+		t.Error("Expected error for zero streams")
+	}
+}
+
+func TestStreamDialer_Echo(t *testing.T) {
+	proxy := &fakeProxy{t: t}
+	dialer, err := NewStreamDialer(proxy, 2, 8)
+	if err != nil {
+		t.Fatalf("Failed to create StreamDialer: %v", err)
+	}
+	conn, err := dialer.Dial(context.Background(), "example.com:443")
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	if proxy.addresses[0] != MagicAddress {
+		t.Errorf("Dialed %v, want %v", proxy.addresses[0], MagicAddress)
+	}
+
+	data := bytes.Repeat([]byte("0123456789"), 100000)
+	go func() {
+		conn.Write(data)
+		// The echo must complete after the half-close.
+		conn.CloseWrite()
+	}()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	got, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("Echoed %d bytes, want %d", len(got), len(data))
+	}
+}
+
+func TestStreamDialer_Pool(t *testing.T) {
+	proxy := &fakeProxy{t: t}
+	dialer, _ := NewStreamDialer(proxy, 2, 2)
+	var conns []transport.StreamConn
+	for i := 0; i < 6; i++ {
+		conn, err := dialer.Dial(context.Background(), "192.0.2.1:80")
+		if err != nil {
+			t.Fatalf("Dial %d failed: %v", i, err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+	}
+	// Two streams per session, then the two sessions are shared.
+	if n := proxy.dialCount(); n != 2 {
+		t.Errorf("Opened %d proxy connections, want 2", n)
+	}
+	for i, conn := range conns {
+		msg := []byte{byte(i)}
+		conn.Write(msg)
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		got := make([]byte, 1)
+		if _, err := io.ReadFull(conn, got); err != nil || got[0] != msg[0] {
+			t.Errorf("Stream %d echoed %v, %v", i, got, err)
+		}
+	}
+}
+
+func TestStreamDialer_StreamError(t *testing.T) {
+	dialer, _ := NewStreamDialer(&fakeProxy{t: t}, 1, 1)
+	conn, err := dialer.Dial(context.Background(), "192.0.2.1:0")
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 10)); err == nil {
+		t.Error("Expected error response from proxy")
+	}
+}
+
+func TestStreamDialer_DialFailure(t *testing.T) {
+	dialer, _ := NewStreamDialer(failingDialer{}, 1, 1)
+	if _, err := dialer.Dial(context.Background(), "192.0.2.1:80"); err == nil {
+		t.Error("Expected Dial to fail")
+	}
+	if _, err := dialer.Dial(context.Background(), "invalid"); err == nil {
+		t.Error("Expected Dial to fail for invalid address")
+	}
+}
+
+func TestStreamDialer_OnNetworkChanged(t *testing.T) {
+	proxy := &fakeProxy{t: t}
+	dialer, _ := NewStreamDialer(proxy, 1, 10)
+	conn, err := dialer.Dial(context.Background(), "192.0.2.1:80")
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	dialer.OnNetworkChanged()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Error("Expected streams of the previous network to be closed")
+	}
+	conn, err = dialer.Dial(context.Background(), "192.0.2.1:80")
+	if err != nil {
+		t.Fatalf("Dial after network change failed: %v", err)
+	}
+	defer conn.Close()
+	if n := proxy.dialCount(); n != 2 {
+		t.Errorf("Opened %d proxy connections, want 2", n)
+	}
+}
+
+// gatedDialer blocks the dials after the first one until `gate` is closed, and signals
+// them on `waiting`.
+type gatedDialer struct {
+	*fakeProxy
+	gate    chan struct{}
+	waiting chan struct{}
+}
+
+func (d *gatedDialer) Dial(ctx context.Context, address string) (transport.StreamConn, error) {
+	if d.dialCount() > 0 {
+		d.waiting <- struct{}{}
+		select {
+		case <-d.gate:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return d.fakeProxy.Dial(ctx, address)
+}
+
+func TestStreamDialer_ConcurrentDial(t *testing.T) {
+	proxy := &gatedDialer{fakeProxy: &fakeProxy{t: t}, gate: make(chan struct{}), waiting: make(chan struct{}, 2)}
+	dialer, _ := NewStreamDialer(proxy, 2, 1)
+	conn, err := dialer.Dial(context.Background(), "192.0.2.1:80")
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	// The second dial opens a new session, and blocks on the proxy.
+	blocked := make(chan error, 1)
+	go func() {
+		conn, err := dialer.Dial(context.Background(), "192.0.2.1:80")
+		if err == nil {
+			defer conn.Close()
+		}
+		blocked <- err
+	}()
+	<-proxy.waiting
+	// The third dial shares the open session, since the pool is full with the pending one.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err = dialer.Dial(ctx, "192.0.2.1:80")
+	if err != nil {
+		t.Fatalf("Dial during a pending session dial failed: %v", err)
+	}
+	defer conn.Close()
+	if len(proxy.waiting) > 0 {
+		t.Error("Dial waited for the pending session dial")
+	}
+
+	close(proxy.gate)
+	if err := <-blocked; err != nil {
+		t.Errorf("Blocked Dial failed: %v", err)
+	}
+	if n := proxy.dialCount(); n != 2 {
+		t.Errorf("Opened %d proxy connections, want 2", n)
+	}
+}
+
+func TestStreamDialer_Close(t *testing.T) {
+	dialer, _ := NewStreamDialer(&fakeProxy{t: t}, 1, 1)
+	conn, err := dialer.Dial(context.Background(), "192.0.2.1:80")
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := conn.Read(make([]byte, 1))
+		readErr <- err
+	}()
+	if err := conn.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+	select {
+	case err := <-readErr:
+		if err == nil {
+			t.Error("Expected the pending Read to fail")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Pending Read not unblocked by Close")
+	}
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Error("Expected Read after Close to fail")
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prefix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/Jigsaw-Code/outline-sdk/transport/shadowsocks"
+	"github.com/shadowsocks/go-shadowsocks2/socks"
+)
+
+// clientUDPBufferSize is the maximum supported UDP packet size in bytes.
+const clientUDPBufferSize = 16 * 1024
+
+// Assumes all ciphers have NonceSize() <= 12.
+var zeroNonce [12]byte
+
+type packetListener struct {
+	endpoint      transport.PacketEndpoint
+	key           *shadowsocks.EncryptionKey
+	saltGenerator shadowsocks.SaltGenerator
+}
+
+var _ transport.PacketListener = (*packetListener)(nil)
+
+// NewPacketListener creates a Shadowsocks PacketListener like [shadowsocks.NewPacketListener],
+// where the salt of every packet comes from `saltGenerator`.
+func NewPacketListener(endpoint transport.PacketEndpoint, key *shadowsocks.EncryptionKey, saltGenerator shadowsocks.SaltGenerator) (transport.PacketListener, error) {
+	if endpoint == nil {
+		return nil, errors.New("argument endpoint must not be nil")
+	}
+	if key == nil {
+		return nil, errors.New("argument key must not be nil")
+	}
+	if saltGenerator == nil {
+		return nil, errors.New("argument saltGenerator must not be nil")
+	}
+	return &packetListener{endpoint: endpoint, key: key, saltGenerator: saltGenerator}, nil
+}
+
+func (l *packetListener) ListenPacket(ctx context.Context) (net.PacketConn, error) {
+	proxyConn, err := l.endpoint.Connect(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to endpoint: %w", err)
+	}
+	return &packetConn{Conn: proxyConn, key: l.key, saltGenerator: l.saltGenerator, readBuf: make([]byte, clientUDPBufferSize)}, nil
+}
+
+type packetConn struct {
+	net.Conn
+	key           *shadowsocks.EncryptionKey
+	saltGenerator shadowsocks.SaltGenerator
+
+	// Protects readBuf.
+	readMu  sync.Mutex
+	readBuf []byte
+}
+
+var _ net.PacketConn = (*packetConn)(nil)
+
+// WriteTo encrypts `b` and writes to `addr` through the proxy.
+func (c *packetConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	socksTargetAddr := socks.ParseAddr(addr.String())
+	if socksTargetAddr == nil {
+		return 0, errors.New("failed to parse target address")
+	}
+	saltSize := c.key.SaltSize()
+	packet := make([]byte, saltSize, saltSize+len(socksTargetAddr)+len(b)+c.key.TagSize())
+	salt := packet[:saltSize]
+	if err := c.saltGenerator.GetSalt(salt); err != nil {
+		return 0, err
+	}
+	aead, err := c.key.NewAEAD(salt)
+	if err != nil {
+		return 0, err
+	}
+	plaintext := append(append(make([]byte, 0, len(socksTargetAddr)+len(b)), socksTargetAddr...), b...)
+	packet = aead.Seal(packet, zeroNonce[:aead.NonceSize()], plaintext, nil)
+	_, err = c.Conn.Write(packet)
+	return len(b), err
+}
+
+// ReadFrom reads from the proxy and decrypts into `b`.
+func (c *packetConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	n, err := c.Conn.Read(c.readBuf)
+	if err != nil {
+		return 0, nil, err
+	}
+	// Decrypt in-place.
+	buf, err := shadowsocks.Unpack(nil, c.readBuf[:n], c.key)
+	if err != nil {
+		return 0, nil, err
+	}
+	socksSrcAddr := socks.SplitAddr(buf)
+	if socksSrcAddr == nil {
+		return 0, nil, errors.New("failed to read source address")
+	}
+	srcAddr, err := transport.MakeNetAddr("udp", socksSrcAddr.String())
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to convert incoming address: %w", err)
+	}
+	n = copy(b, buf[len(socksSrcAddr):]) // Strip the SOCKS source address
+	if n < len(buf)-len(socksSrcAddr) {
+		return n, srcAddr, io.ErrShortBuffer
+	}
+	return n, srcAddr, nil
+}
@@ -0,0 +1,81 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prefix
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/Jigsaw-Code/outline-sdk/transport/shadowsocks"
+)
+
+func TestPacketListener_Echo(t *testing.T) {
+	key, err := shadowsocks.NewEncryptionKey("chacha20-ietf-poly1305", "SECRET")
+	if err != nil {
+		t.Fatalf("Failed to create key: %v", err)
+	}
+	server, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer server.Close()
+	salts := make(chan []byte, 1)
+	go func() {
+		// Echo the packet with the standard Shadowsocks encryption.
+		buf := make([]byte, clientUDPBufferSize)
+		n, clientAddr, err := server.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		salts <- append([]byte(nil), buf[:key.SaltSize()]...)
+		plaintext, err := shadowsocks.Unpack(nil, buf[:n], key)
+		if err != nil {
+			t.Errorf("Failed to decrypt packet: %v", err)
+			return
+		}
+		response, _ := shadowsocks.Pack(make([]byte, clientUDPBufferSize), plaintext, key)
+		server.WriteTo(response, clientAddr)
+	}()
+
+	listener, err := NewPacketListener(&transport.UDPEndpoint{Address: server.LocalAddr().String()}, key, Literal([]byte("DNS")))
+	if err != nil {
+		t.Fatalf("Failed to create PacketListener: %v", err)
+	}
+	conn, err := listener.ListenPacket(context.Background())
+	if err != nil {
+		t.Fatalf("ListenPacket failed: %v", err)
+	}
+	defer conn.Close()
+	target := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 53}
+	if _, err := conn.WriteTo([]byte("query"), target); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 100)
+	n, addr, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("ReadFrom failed: %v", err)
+	}
+	if string(buf[:n]) != "query" || addr.String() != target.String() {
+		t.Errorf("Got %q from %v, want %q from %v", buf[:n], addr, "query", target)
+	}
+	if salt := <-salts; !bytes.HasPrefix(salt, []byte("DNS")) {
+		t.Errorf("Got salt %x, want prefix %x", salt, "DNS")
+	}
+}
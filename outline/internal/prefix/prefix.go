@@ -0,0 +1,237 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package prefix generates the salt prefixes that disguise Shadowsocks connections
+// and packets, from a textual specification.
+//
+// A specification is one of:
+//
+//   - "hex:<digits>", a hex-encoded literal, like "hex:160301".
+//   - "base64:<data>", a base64-encoded literal, like "base64:FgMB".
+//   - "template:<text>", a sequence of literal characters and placeholders, like
+//     "template:GET /{rand:2-6}". Characters must be codepoints 0-255, as in the plain
+//     format, and "{{" stands for a literal "{". The placeholders are "{hex:<digits>}",
+//     "{base64:<data>}", "{rand:<n>}" for n random bytes, and "{rand:<min>-<max>}" for a
+//     random number of random bytes. Random bytes are generated for every salt.
+//   - Any other text, where each codepoint in range 0-255 is a byte.
+package prefix
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/internal/utf8"
+	"github.com/Jigsaw-Code/outline-sdk/transport/shadowsocks"
+)
+
+// segment is either literal bytes, or between minRand and maxRand random bytes.
+type segment struct {
+	literal          []byte
+	minRand, maxRand int
+}
+
+// Generator is a [shadowsocks.SaltGenerator] that starts each salt with a prefix.
+type Generator struct {
+	spec     string
+	segments []segment
+}
+
+var _ shadowsocks.SaltGenerator = (*Generator)(nil)
+
+// Literal returns a [Generator] for the constant prefix `b`.
+func Literal(b []byte) *Generator {
+	return &Generator{spec: fmt.Sprintf("hex:%x", b), segments: []segment{{literal: b}}}
+}
+
+// Parse parses a prefix specification, as described in the package documentation.
+// Returns nil for the empty specification.
+func Parse(spec string) (*Generator, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	g := &Generator{spec: spec}
+	switch {
+	case strings.HasPrefix(spec, "hex:"), strings.HasPrefix(spec, "base64:"):
+		b, err := decodeLiteral(spec)
+		if err != nil {
+			return nil, err
+		}
+		g.segments = []segment{{literal: b}}
+	case strings.HasPrefix(spec, "template:"):
+		var err error
+		if g.segments, err = parseTemplate(strings.TrimPrefix(spec, "template:")); err != nil {
+			return nil, fmt.Errorf("invalid prefix template: %w", err)
+		}
+	default:
+		b, err := utf8.DecodeUTF8CodepointsToRawBytes(spec)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse prefix string: %w", err)
+		}
+		g.segments = []segment{{literal: b}}
+	}
+	return g, nil
+}
+
+// decodeLiteral decodes a "hex:" or "base64:" literal.
+func decodeLiteral(literal string) ([]byte, error) {
+	if data, ok := strings.CutPrefix(literal, "hex:"); ok {
+		b, err := hex.DecodeString(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hex prefix %q: %w", data, err)
+		}
+		return b, nil
+	}
+	data, _ := strings.CutPrefix(literal, "base64:")
+	b, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		// Accept unpadded data too.
+		if b, err = base64.RawStdEncoding.DecodeString(data); err != nil {
+			return nil, fmt.Errorf("invalid base64 prefix %q: %w", data, err)
+		}
+	}
+	return b, nil
+}
+
+func parseTemplate(template string) ([]segment, error) {
+	var segments []segment
+	var text []byte
+	flushText := func() {
+		if len(text) > 0 {
+			segments = append(segments, segment{literal: text})
+			text = nil
+		}
+	}
+	runes := []rune(template)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if r != '{' {
+			if (r & 0xFF) != r {
+				return nil, fmt.Errorf("character out of range: %d", r)
+			}
+			text = append(text, byte(r))
+			continue
+		}
+		if i+1 < len(runes) && runes[i+1] == '{' {
+			text = append(text, '{')
+			i++
+			continue
+		}
+		end := i + 1
+		for end < len(runes) && runes[end] != '}' {
+			end++
+		}
+		if end == len(runes) {
+			return nil, errors.New("unterminated placeholder")
+		}
+		s, err := parsePlaceholder(string(runes[i+1 : end]))
+		if err != nil {
+			return nil, err
+		}
+		flushText()
+		segments = append(segments, s)
+		i = end
+	}
+	flushText()
+	return segments, nil
+}
+
+func parsePlaceholder(placeholder string) (segment, error) {
+	if strings.HasPrefix(placeholder, "hex:") || strings.HasPrefix(placeholder, "base64:") {
+		b, err := decodeLiteral(placeholder)
+		return segment{literal: b}, err
+	}
+	length, ok := strings.CutPrefix(placeholder, "rand:")
+	if !ok {
+		return segment{}, fmt.Errorf("unknown placeholder {%v}", placeholder)
+	}
+	minLength, maxLength, isRange := strings.Cut(length, "-")
+	if !isRange {
+		maxLength = minLength
+	}
+	min, err := strconv.Atoi(minLength)
+	if err != nil || min < 0 {
+		return segment{}, fmt.Errorf("invalid length in placeholder {%v}", placeholder)
+	}
+	max, err := strconv.Atoi(maxLength)
+	if err != nil || max < min {
+		return segment{}, fmt.Errorf("invalid length in placeholder {%v}", placeholder)
+	}
+	return segment{minRand: min, maxRand: max}, nil
+}
+
+// String returns the specification of the prefix.
+func (g *Generator) String() string {
+	return g.spec
+}
+
+// MaxLen returns the maximum length of the generated prefixes, or [math.MaxInt] if it
+// overflows.
+func (g *Generator) MaxLen() int {
+	n := 0
+	for _, s := range g.segments {
+		length := len(s.literal) + s.maxRand
+		if length < s.maxRand || n > math.MaxInt-length {
+			return math.MaxInt
+		}
+		n += length
+	}
+	return n
+}
+
+// Validate returns an error if the prefixes may not fit in a salt of `saltSize` bytes.
+func (g *Generator) Validate(saltSize int) error {
+	if n := g.MaxLen(); n > saltSize {
+		return fmt.Errorf("prefix %q may be %d bytes long, but the salt has %d bytes", g.spec, n, saltSize)
+	}
+	return nil
+}
+
+// GetSalt implements [shadowsocks.SaltGenerator.GetSalt]. Outputs a new prefix followed
+// by random bytes.
+func (g *Generator) GetSalt(salt []byte) error {
+	n := 0
+	for _, s := range g.segments {
+		if s.literal != nil {
+			if n+len(s.literal) > len(salt) {
+				return errors.New("prefix is too long")
+			}
+			n += copy(salt[n:], s.literal)
+			continue
+		}
+		length := s.minRand
+		if s.maxRand > s.minRand {
+			extra, err := rand.Int(rand.Reader, big.NewInt(int64(s.maxRand-s.minRand+1)))
+			if err != nil {
+				return err
+			}
+			length += int(extra.Int64())
+		}
+		if n+length > len(salt) {
+			return errors.New("prefix is too long")
+		}
+		if _, err := rand.Read(salt[n : n+length]); err != nil {
+			return err
+		}
+		n += length
+	}
+	_, err := rand.Read(salt[n:])
+	return err
+}
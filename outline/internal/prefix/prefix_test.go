@@ -0,0 +1,140 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prefix
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"testing"
+)
+
+func Test_Parse(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		want    []byte // Expected salt bytes, with 0xEE where bytes are random.
+		wantMax int
+	}{
+		{name: "plain", spec: "POST ", want: []byte("POST "), wantMax: 5},
+		{name: "plain codepoints", spec: "\u0016\u0003ÿ", want: []byte{0x16, 0x03, 0xff}, wantMax: 3},
+		{name: "hex", spec: "hex:160301", want: []byte{0x16, 0x03, 0x01}, wantMax: 3},
+		{name: "base64", spec: "base64:FgMB", want: []byte{0x16, 0x03, 0x01}, wantMax: 3},
+		{name: "base64 unpadded", spec: "base64:FgM", want: []byte{0x16, 0x03}, wantMax: 2},
+		{name: "template literals", spec: "template:GET {hex:2f}{base64:YQ==}{{", want: []byte("GET /a{"), wantMax: 7},
+		{name: "template random", spec: "template:{rand:2}/", want: []byte{0xEE, 0xEE, '/'}, wantMax: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := Parse(tt.spec)
+			if err != nil {
+				t.Fatalf("Parse() failed: %v", err)
+			}
+			if g.String() != tt.spec {
+				t.Errorf("String() = %q, want %q", g.String(), tt.spec)
+			}
+			if got := g.MaxLen(); got != tt.wantMax {
+				t.Errorf("MaxLen() = %d, want %d", got, tt.wantMax)
+			}
+			salt := make([]byte, 16)
+			if err := g.GetSalt(salt); err != nil {
+				t.Fatalf("GetSalt() failed: %v", err)
+			}
+			for i, b := range tt.want {
+				if b != 0xEE && salt[i] != b {
+					t.Errorf("GetSalt() = %x, want prefix %x", salt, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func Test_Parse_Empty(t *testing.T) {
+	if g, err := Parse(""); g != nil || err != nil {
+		t.Errorf("Parse(\"\") = %v, %v, want nil, nil", g, err)
+	}
+}
+
+func Test_Parse_Errors(t *testing.T) {
+	for _, spec := range []string{
+		"ሴ",
+		"hex:zz",
+		"hex:123",
+		"base64:!!",
+		"template:{rand:2",
+		"template:{rand:x}",
+		"template:{rand:-1}",
+		"template:{rand:4-2}",
+		"template:{unknown}",
+		"template:ሴ",
+	} {
+		if g, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q) expects an error, got = %v", spec, g)
+		}
+	}
+}
+
+func Test_GetSalt_RandomLength(t *testing.T) {
+	g, err := Parse("template:A{rand:0-3}B")
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if err := g.Validate(5); err != nil {
+		t.Errorf("Validate(5) failed: %v", err)
+	}
+	if err := g.Validate(4); err == nil {
+		t.Error("Validate(4) expects an error")
+	}
+	lengths := make(map[int]bool)
+	for i := 0; i < 200; i++ {
+		salt := make([]byte, 8)
+		if err := g.GetSalt(salt); err != nil {
+			t.Fatalf("GetSalt() failed: %v", err)
+		}
+		if salt[0] != 'A' {
+			t.Fatalf("GetSalt() = %x, want prefix A", salt)
+		}
+		// Find the B that ends the prefix.
+		end := bytes.IndexByte(salt[1:5], 'B')
+		if end == -1 {
+			t.Fatalf("GetSalt() = %x, want B within 4 bytes", salt)
+		}
+		lengths[end] = true
+	}
+	if len(lengths) < 2 {
+		t.Errorf("Random segment lengths %v, want several", lengths)
+	}
+}
+
+func Test_GetSalt_TooLong(t *testing.T) {
+	if err := Literal([]byte("0123456789")).GetSalt(make([]byte, 4)); err == nil {
+		t.Error("GetSalt() expects an error for a short salt")
+	}
+}
+
+func Test_Validate_Overflow(t *testing.T) {
+	max := strconv.Itoa(math.MaxInt)
+	g, err := Parse("template:{rand:" + max + "}{rand:" + max + "}A")
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if got := g.MaxLen(); got != math.MaxInt {
+		t.Errorf("MaxLen() = %d, want %d", got, math.MaxInt)
+	}
+	if err := g.Validate(32); err == nil {
+		t.Error("Validate(32) expects an error")
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package proxyclient implements the validation and the connectivity checks shared by the
+// proxy client packages, which export them through gomobile.
+package proxyclient
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline"
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/connectivity"
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/neterrors"
+)
+
+// ValidateConfig validates whether a proxy configuration is valid
+// (it won't do any connectivity tests)
+//
+// Returns nil if it is valid; or an error message.
+func ValidateConfig(host string, port int, username, password string) error {
+	if len(host) == 0 {
+		return fmt.Errorf("must provide a host name or IP address")
+	}
+	if port <= 0 || port > 65535 {
+		return fmt.Errorf("port must be within range [1..65535]")
+	}
+	if len(username) == 0 && len(password) > 0 {
+		return fmt.Errorf("must provide a username with the password")
+	}
+	return nil
+}
+
+// Check runs the connectivity checks of `checker`, or of [connectivity.NewChecker] if nil,
+// through `client`. UDP is only checked if `udp` is true.
+// Returns a [*neterrors.PlatformError], or nil on success.
+func Check(client *outline.Client, checker *connectivity.Checker, udp bool) error {
+	if checker == nil {
+		checker = connectivity.NewChecker()
+	}
+	if udp {
+		return checker.Check(context.Background(), client)
+	}
+	return checker.CheckTCP(context.Background(), client)
+}
+
+// ErrorCode converts the result of [Check] to the legacy error code, as in
+// [neterrors.Error.LegacyCode], and the cause of the failure if the error is unexpected.
+func ErrorCode(err error) (int, error) {
+	platformErr := neterrors.FromError(err)
+	switch {
+	case platformErr == nil:
+		return neterrors.NoError.Number(), nil
+	case platformErr.Code == neterrors.Unexpected:
+		return platformErr.Code.Number(), platformErr.Cause
+	default:
+		return platformErr.Code.LegacyCode().Number(), nil
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxyclient
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/neterrors"
+)
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		host     string
+		port     int
+		username string
+		password string
+		wantErr  bool
+	}{
+		{name: "valid", host: "192.0.2.1", port: 1080},
+		{name: "with credentials", host: "proxy.example", port: 1080, username: "user", password: "secret"},
+		{name: "empty host", port: 1080, wantErr: true},
+		{name: "port 0", host: "192.0.2.1", wantErr: true},
+		{name: "port 65536", host: "192.0.2.1", port: 65536, wantErr: true},
+		{name: "password without username", host: "192.0.2.1", port: 1080, password: "secret", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateConfig(tt.host, tt.port, tt.username, tt.password); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		wantCode neterrors.Error
+		wantErr  error
+	}{
+		{name: "success", wantCode: neterrors.NoError},
+		{name: "UDP", err: neterrors.New(neterrors.UDPConnectivity, io.EOF), wantCode: neterrors.UDPConnectivity},
+		{name: "reset", err: neterrors.New(neterrors.ProxyConnectionReset, io.EOF), wantCode: neterrors.Unreachable},
+		{name: "timeout", err: neterrors.New(neterrors.ProxyResponseTimeout, io.EOF), wantCode: neterrors.AuthenticationFailure},
+		{name: "unexpected", err: neterrors.New(neterrors.Unexpected, io.EOF), wantCode: neterrors.Unexpected, wantErr: io.EOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := ErrorCode(tt.err)
+			if code != tt.wantCode.Number() || !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Errorf("ErrorCode() = %v, %v, want %v, %v", code, err, tt.wantCode.Number(), tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,238 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package socks5 implements a SOCKS5 client (RFC 1928) with username/password
+// authentication (RFC 1929) and UDP ASSOCIATE.
+package socks5
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline"
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	sdksocks5 "github.com/Jigsaw-Code/outline-sdk/transport/socks5"
+	"github.com/shadowsocks/go-shadowsocks2/socks"
+)
+
+// Protocol constants, see https://datatracker.ietf.org/doc/html/rfc1928.
+const (
+	socksVersion         = 5
+	methodNoAuth         = 0
+	methodUserPass       = 2
+	methodNoAcceptable   = 0xff
+	userPassVersion      = 1
+	cmdConnect           = 1
+	cmdUDPAssociate      = 3
+	authStatusSuccess    = 0
+	maxCredentialsLength = 255
+)
+
+// ErrAuthenticationFailed is returned when the proxy rejects the credentials, or requires
+// credentials that were not provided. It wraps [outline.ErrProxyAuthentication].
+var ErrAuthenticationFailed = fmt.Errorf("SOCKS5 %w", outline.ErrProxyAuthentication)
+
+// Credentials for the username/password authentication method.
+type Credentials struct {
+	Username string
+	Password string
+}
+
+// Dialer is a [transport.StreamDialer] and [transport.PacketListener] that relays
+// traffic through a SOCKS5 proxy.
+type Dialer struct {
+	endpoint    transport.StreamEndpoint
+	credentials *Credentials
+	// Reaches the UDP relays. Nil for the bare network.
+	packetDialer transport.PacketDialer
+	// Replaces unspecified relay addresses. Empty for the proxy connection address.
+	proxyHost string
+}
+
+var (
+	_ transport.StreamDialer   = (*Dialer)(nil)
+	_ transport.PacketListener = (*Dialer)(nil)
+)
+
+// NewDialer creates a [Dialer] for the proxy at `endpoint`. `credentials` may be nil
+// if the proxy doesn't require authentication.
+func NewDialer(endpoint transport.StreamEndpoint, credentials *Credentials) (*Dialer, error) {
+	if endpoint == nil {
+		return nil, errors.New("argument endpoint must not be nil")
+	}
+	if credentials != nil {
+		if len(credentials.Username) == 0 || len(credentials.Username) > maxCredentialsLength {
+			return nil, fmt.Errorf("username must be 1 to %d bytes long", maxCredentialsLength)
+		}
+		if len(credentials.Password) == 0 || len(credentials.Password) > maxCredentialsLength {
+			return nil, fmt.Errorf("password must be 1 to %d bytes long", maxCredentialsLength)
+		}
+	}
+	return &Dialer{endpoint: endpoint, credentials: credentials}, nil
+}
+
+// WithPacketDialer returns a copy of the dialer that reaches the UDP relays with
+// `packetDialer`, for proxies reached through another proxy. Unspecified relay addresses
+// are replaced by `proxyHost`, since the proxy connection address is not the proxy's.
+func (d *Dialer) WithPacketDialer(packetDialer transport.PacketDialer, proxyHost string) *Dialer {
+	chained := *d
+	chained.packetDialer, chained.proxyHost = packetDialer, proxyHost
+	return &chained
+}
+
+// Dial implements [transport.StreamDialer.Dial] with the CONNECT command. Errors reported
+// by the proxy are of type [sdksocks5.ReplyCode].
+func (d *Dialer) Dial(ctx context.Context, remoteAddr string) (transport.StreamConn, error) {
+	target := socks.ParseAddr(remoteAddr)
+	if target == nil {
+		return nil, fmt.Errorf("failed to parse address %v", remoteAddr)
+	}
+	proxyConn, _, err := d.request(ctx, cmdConnect, target)
+	if err != nil {
+		return nil, err
+	}
+	return proxyConn, nil
+}
+
+// ListenPacket implements [transport.PacketListener.ListenPacket] with the UDP ASSOCIATE
+// command. The association lasts until the returned connection is closed.
+func (d *Dialer) ListenPacket(ctx context.Context) (net.PacketConn, error) {
+	// We don't know the source address of the packets, so we send the unspecified address.
+	controlConn, bound, err := d.request(ctx, cmdUDPAssociate, socks.ParseAddr("0.0.0.0:0"))
+	if err != nil {
+		return nil, err
+	}
+	relayHost, relayPort, err := net.SplitHostPort(bound.String())
+	if err != nil {
+		controlConn.Close()
+		return nil, fmt.Errorf("invalid UDP relay address %v: %w", bound, err)
+	}
+	if ip := net.ParseIP(relayHost); ip != nil && ip.IsUnspecified() {
+		// The relay is on the proxy host.
+		relayHost = d.proxyHost
+		if relayHost == "" {
+			relayHost, _, err = net.SplitHostPort(controlConn.RemoteAddr().String())
+			if err != nil {
+				controlConn.Close()
+				return nil, fmt.Errorf("invalid proxy address: %w", err)
+			}
+		}
+	}
+	var packetDialer transport.PacketDialer = &transport.UDPPacketDialer{}
+	if d.packetDialer != nil {
+		packetDialer = d.packetDialer
+	}
+	relayConn, err := packetDialer.Dial(ctx, net.JoinHostPort(relayHost, relayPort))
+	if err != nil {
+		controlConn.Close()
+		return nil, fmt.Errorf("could not connect to the UDP relay: %w", err)
+	}
+	return newPacketConn(relayConn, controlConn), nil
+}
+
+// request connects to the proxy, authenticates, and sends the command `cmd` for `target`.
+// Returns the connection and the bound address in the reply.
+func (d *Dialer) request(ctx context.Context, cmd byte, target socks.Addr) (transport.StreamConn, socks.Addr, error) {
+	proxyConn, err := d.endpoint.Connect(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not connect to SOCKS5 proxy: %w", err)
+	}
+	success := false
+	defer func() {
+		if !success {
+			proxyConn.Close()
+		}
+	}()
+	if deadline, ok := ctx.Deadline(); ok {
+		proxyConn.SetDeadline(deadline)
+		defer proxyConn.SetDeadline(time.Time{})
+	}
+
+	if err := d.authenticate(proxyConn); err != nil {
+		return nil, nil, err
+	}
+
+	// VER, CMD, RSV, DST.ADDR.
+	req := append([]byte{socksVersion, cmd, 0}, target...)
+	if _, err := proxyConn.Write(req); err != nil {
+		return nil, nil, fmt.Errorf("failed to write SOCKS5 request: %w", err)
+	}
+	// VER, REP, RSV, BND.ADDR.
+	var header [3]byte
+	if _, err := io.ReadFull(proxyConn, header[:]); err != nil {
+		return nil, nil, fmt.Errorf("failed to read SOCKS5 reply: %w", err)
+	}
+	if header[0] != socksVersion {
+		return nil, nil, fmt.Errorf("invalid protocol version %v. Expected %v", header[0], socksVersion)
+	}
+	if header[1] != 0 {
+		return nil, nil, sdksocks5.ReplyCode(header[1])
+	}
+	bound, err := socks.ReadAddr(proxyConn)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read bound address in SOCKS5 reply: %w", err)
+	}
+	success = true
+	return proxyConn, bound, nil
+}
+
+// authenticate negotiates the authentication method, and sends the credentials if needed.
+func (d *Dialer) authenticate(proxyConn transport.StreamConn) error {
+	methods := []byte{socksVersion, 1, methodNoAuth}
+	if d.credentials != nil {
+		methods = []byte{socksVersion, 2, methodNoAuth, methodUserPass}
+	}
+	if _, err := proxyConn.Write(methods); err != nil {
+		return fmt.Errorf("failed to write SOCKS5 method request: %w", err)
+	}
+	var reply [2]byte
+	if _, err := io.ReadFull(proxyConn, reply[:]); err != nil {
+		return fmt.Errorf("failed to read SOCKS5 method reply: %w", err)
+	}
+	if reply[0] != socksVersion {
+		return fmt.Errorf("invalid protocol version %v. Expected %v", reply[0], socksVersion)
+	}
+	switch reply[1] {
+	case methodNoAuth:
+		return nil
+	case methodUserPass:
+		if d.credentials == nil {
+			return fmt.Errorf("%w: the proxy requires a username and password", ErrAuthenticationFailed)
+		}
+	case methodNoAcceptable:
+		return fmt.Errorf("%w: no acceptable authentication method", ErrAuthenticationFailed)
+	default:
+		return fmt.Errorf("unsupported SOCKS5 authentication method %v", reply[1])
+	}
+
+	// See https://datatracker.ietf.org/doc/html/rfc1929#section-2.
+	req := []byte{userPassVersion, byte(len(d.credentials.Username))}
+	req = append(req, d.credentials.Username...)
+	req = append(req, byte(len(d.credentials.Password)))
+	req = append(req, d.credentials.Password...)
+	if _, err := proxyConn.Write(req); err != nil {
+		return fmt.Errorf("failed to write SOCKS5 credentials: %w", err)
+	}
+	if _, err := io.ReadFull(proxyConn, reply[:]); err != nil {
+		return fmt.Errorf("failed to read SOCKS5 authentication reply: %w", err)
+	}
+	if reply[1] != authStatusSuccess {
+		return fmt.Errorf("%w: the proxy rejected the credentials", ErrAuthenticationFailed)
+	}
+	return nil
+}
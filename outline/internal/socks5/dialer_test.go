@@ -0,0 +1,312 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/connectivity"
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	sdksocks5 "github.com/Jigsaw-Code/outline-sdk/transport/socks5"
+	"github.com/shadowsocks/go-shadowsocks2/socks"
+)
+
+// testServer is a SOCKS5 stand-in that echoes CONNECT streams and UDP packets, instead of
+// relaying them, except DNS queries that are answered. It requires `credentials` if not nil, and refuses connections to port 0.
+type testServer struct {
+	t           *testing.T
+	listener    *net.TCPListener
+	credentials *Credentials
+}
+
+func newTestServer(t *testing.T, credentials *Credentials) *testServer {
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	s := &testServer{t: t, listener: listener, credentials: credentials}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *testServer) endpoint() transport.StreamEndpoint {
+	return &transport.TCPEndpoint{Address: s.listener.Addr().String()}
+}
+
+func (s *testServer) serve(conn net.Conn) {
+	defer conn.Close()
+	if !s.authenticate(conn) {
+		return
+	}
+	var header [3]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		return
+	}
+	target, err := socks.ReadAddr(conn)
+	if err != nil {
+		return
+	}
+	switch header[1] {
+	case cmdConnect:
+		if _, port, _ := net.SplitHostPort(target.String()); port == "0" {
+			conn.Write([]byte{socksVersion, byte(sdksocks5.ErrConnectionRefused), 0})
+			conn.Write(socks.ParseAddr("0.0.0.0:0"))
+			return
+		}
+		conn.Write(append([]byte{socksVersion, 0, 0}, socks.ParseAddr("127.0.0.1:1")...))
+		io.Copy(conn, conn)
+	case cmdUDPAssociate:
+		relay, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			return
+		}
+		defer relay.Close()
+		// Report the unspecified address, so the client uses the proxy address.
+		_, port, _ := net.SplitHostPort(relay.LocalAddr().String())
+		conn.Write(append([]byte{socksVersion, 0, 0}, socks.ParseAddr(net.JoinHostPort("0.0.0.0", port))...))
+		go func() {
+			buf := make([]byte, clientUDPBufferSize)
+			for {
+				n, clientAddr, err := relay.ReadFrom(buf)
+				if err != nil {
+					return
+				}
+				// Echo the packet, which has the same format in both directions. DNS messages
+				// are flagged as responses, to answer them.
+				if addr := socks.SplitAddr(buf[3:n]); addr != nil && strings.HasSuffix(addr.String(), ":53") && n >= 3+len(addr)+12 {
+					buf[3+len(addr)+2] |= 0x80
+				}
+				relay.WriteTo(buf[:n], clientAddr)
+			}
+		}()
+		io.Copy(io.Discard, conn)
+	}
+}
+
+func (s *testServer) authenticate(conn net.Conn) bool {
+	var header [2]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		return false
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return false
+	}
+	if s.credentials == nil {
+		conn.Write([]byte{socksVersion, methodNoAuth})
+		return true
+	}
+	if !bytes.Contains(methods, []byte{methodUserPass}) {
+		conn.Write([]byte{socksVersion, methodNoAcceptable})
+		return false
+	}
+	conn.Write([]byte{socksVersion, methodUserPass})
+	readString := func() string {
+		var length [1]byte
+		io.ReadFull(conn, length[:])
+		b := make([]byte, length[0])
+		io.ReadFull(conn, b)
+		return string(b)
+	}
+	var version [1]byte
+	io.ReadFull(conn, version[:])
+	if username, password := readString(), readString(); username != s.credentials.Username || password != s.credentials.Password {
+		conn.Write([]byte{userPassVersion, 1})
+		return false
+	}
+	conn.Write([]byte{userPassVersion, authStatusSuccess})
+	return true
+}
+
+func TestNewDialer_Errors(t *testing.T) {
+	endpoint := &transport.TCPEndpoint{Address: "127.0.0.1:1080"}
+	tests := []struct {
+		name        string
+		endpoint    transport.StreamEndpoint
+		credentials *Credentials
+	}{
+		{name: "nil endpoint"},
+		{name: "empty username", endpoint: endpoint, credentials: &Credentials{Password: "secret"}},
+		{name: "empty password", endpoint: endpoint, credentials: &Credentials{Username: "user"}},
+		{name: "long username", endpoint: endpoint, credentials: &Credentials{Username: string(make([]byte, 256)), Password: "secret"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewDialer(tt.endpoint, tt.credentials); err == nil {
+				t.Error("NewDialer() expects an error")
+			}
+		})
+	}
+}
+
+func TestDialer_Dial(t *testing.T) {
+	tests := []struct {
+		name              string
+		serverCredentials *Credentials
+		credentials       *Credentials
+		target            string
+		wantErr           error
+	}{
+		{name: "no auth", target: "example.com:80"},
+		{name: "no auth with credentials", credentials: &Credentials{"user", "secret"}, target: "192.0.2.1:80"},
+		{name: "user/pass", serverCredentials: &Credentials{"user", "secret"}, credentials: &Credentials{"user", "secret"}, target: "[2001:db8::1]:443"},
+		{name: "wrong password", serverCredentials: &Credentials{"user", "secret"}, credentials: &Credentials{"user", "wrong"}, target: "example.com:80", wantErr: ErrAuthenticationFailed},
+		{name: "missing credentials", serverCredentials: &Credentials{"user", "secret"}, target: "example.com:80", wantErr: ErrAuthenticationFailed},
+		{name: "refused", target: "example.com:0", wantErr: sdksocks5.ErrConnectionRefused},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestServer(t, tt.serverCredentials)
+			defer server.listener.Close()
+			dialer, err := NewDialer(server.endpoint(), tt.credentials)
+			if err != nil {
+				t.Fatalf("NewDialer() failed: %v", err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			conn, err := dialer.Dial(ctx, tt.target)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("Dial() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Dial() failed: %v", err)
+			}
+			defer conn.Close()
+			conn.Write([]byte("hello"))
+			conn.CloseWrite()
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			if got, err := io.ReadAll(conn); err != nil || string(got) != "hello" {
+				t.Errorf("Echoed %q, %v, want %q", got, err, "hello")
+			}
+		})
+	}
+}
+
+func TestDialer_ListenPacket(t *testing.T) {
+	credentials := &Credentials{"user", "secret"}
+	server := newTestServer(t, credentials)
+	defer server.listener.Close()
+	dialer, _ := NewDialer(server.endpoint(), credentials)
+	conn, err := dialer.ListenPacket(context.Background())
+	if err != nil {
+		t.Fatalf("ListenPacket() failed: %v", err)
+	}
+	defer conn.Close()
+
+	for _, target := range []string{"192.0.2.1:53", "[2001:db8::1]:53"} {
+		targetAddr, _ := net.ResolveUDPAddr("udp", target)
+		if _, err := conn.WriteTo([]byte("query"), targetAddr); err != nil {
+			t.Fatalf("WriteTo() failed: %v", err)
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		buf := make([]byte, 100)
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("ReadFrom() failed: %v", err)
+		}
+		if string(buf[:n]) != "query" || addr.String() != targetAddr.String() {
+			t.Errorf("Got %q from %v, want %q from %v", buf[:n], addr, "query", targetAddr)
+		}
+	}
+
+	// Short buffers are reported.
+	conn.WriteTo([]byte("query"), &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 53})
+	if n, _, err := conn.ReadFrom(make([]byte, 2)); n != 2 || !errors.Is(err, io.ErrShortBuffer) {
+		t.Errorf("ReadFrom() = %d, %v, want 2, %v", n, err, io.ErrShortBuffer)
+	}
+}
+
+// recordingPacketDialer is a [transport.PacketDialer] on the bare network that records
+// the dialed addresses.
+type recordingPacketDialer struct {
+	transport.UDPPacketDialer
+	addrs []string
+}
+
+func (d *recordingPacketDialer) Dial(ctx context.Context, addr string) (net.Conn, error) {
+	d.addrs = append(d.addrs, addr)
+	return d.UDPPacketDialer.Dial(ctx, addr)
+}
+
+func TestDialer_WithPacketDialer(t *testing.T) {
+	server := newTestServer(t, nil)
+	defer server.listener.Close()
+	dialer, _ := NewDialer(server.endpoint(), nil)
+	packetDialer := &recordingPacketDialer{}
+	conn, err := dialer.WithPacketDialer(packetDialer, "localhost").ListenPacket(context.Background())
+	if err != nil {
+		t.Fatalf("ListenPacket() failed: %v", err)
+	}
+	defer conn.Close()
+	// The unspecified relay address is replaced by the proxy host.
+	if len(packetDialer.addrs) != 1 || !strings.HasPrefix(packetDialer.addrs[0], "localhost:") {
+		t.Errorf("Dialed %v, want the relay on localhost", packetDialer.addrs)
+	}
+	targetAddr := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 53}
+	conn.WriteTo([]byte("query"), targetAddr)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 100)
+	if n, _, err := conn.ReadFrom(buf); err != nil || string(buf[:n]) != "query" {
+		t.Errorf("ReadFrom() = %q, %v, want %q", buf[:n], err, "query")
+	}
+}
+
+func TestDialer_ListenPacket_ClosedByProxy(t *testing.T) {
+	server := newTestServer(t, nil)
+	defer server.listener.Close()
+	dialer, _ := NewDialer(server.endpoint(), nil)
+	conn, err := dialer.ListenPacket(context.Background())
+	if err != nil {
+		t.Fatalf("ListenPacket() failed: %v", err)
+	}
+	// The association ends with the control connection.
+	conn.(*packetConn).controlConn.CloseWrite()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := conn.ReadFrom(make([]byte, 10)); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("ReadFrom() error = %v, want %v", err, net.ErrClosed)
+	}
+}
+
+func TestDialer_ConnectivityChecks(t *testing.T) {
+	server := newTestServer(t, nil)
+	defer server.listener.Close()
+	dialer, _ := NewDialer(server.endpoint(), nil)
+	// The stand-in echoes the HTTP request, and answers the DNS query, which is enough for the checks.
+	if err := connectivity.CheckTCPConnectivityWithHTTP(dialer, "http://example.com"); err != nil {
+		t.Errorf("CheckTCPConnectivityWithHTTP() failed: %v", err)
+	}
+	resolverAddr := &net.UDPAddr{IP: net.ParseIP("192.0.2.53"), Port: 53}
+	if err := connectivity.CheckUDPConnectivityWithDNS(dialer, resolverAddr); err != nil {
+		t.Errorf("CheckUDPConnectivityWithDNS() failed: %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package socks5
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/shadowsocks/go-shadowsocks2/socks"
+)
+
+// clientUDPBufferSize is the maximum supported UDP packet size in bytes.
+const clientUDPBufferSize = 16 * 1024
+
+// packetConn relays packets through a SOCKS5 UDP relay. Each packet starts with
+// RSV (2 bytes), FRAG (1 byte) and the SOCKS address of the target or source.
+type packetConn struct {
+	net.Conn    // Connected to the UDP relay.
+	controlConn transport.StreamConn
+	closeOnce   sync.Once
+
+	// Protects readBuf.
+	readMu  sync.Mutex
+	readBuf []byte
+}
+
+var _ net.PacketConn = (*packetConn)(nil)
+
+func newPacketConn(relayConn net.Conn, controlConn transport.StreamConn) *packetConn {
+	c := &packetConn{Conn: relayConn, controlConn: controlConn, readBuf: make([]byte, clientUDPBufferSize)}
+	go func() {
+		// The association ends when the proxy closes the control connection.
+		io.Copy(io.Discard, controlConn)
+		c.Close()
+	}()
+	return c
+}
+
+// WriteTo sends `b` to `addr` through the relay.
+func (c *packetConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	target := socks.ParseAddr(addr.String())
+	if target == nil {
+		return 0, errors.New("failed to parse target address")
+	}
+	packet := make([]byte, 0, 3+len(target)+len(b))
+	packet = append(packet, 0, 0, 0) // RSV, FRAG.
+	packet = append(packet, target...)
+	packet = append(packet, b...)
+	if _, err := c.Conn.Write(packet); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// ReadFrom reads a packet from the relay into `b`. Fragmented packets are dropped.
+func (c *packetConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	for {
+		n, err := c.Conn.Read(c.readBuf)
+		if err != nil {
+			return 0, nil, err
+		}
+		packet := c.readBuf[:n]
+		if len(packet) < 3 || packet[2] != 0 {
+			continue
+		}
+		srcAddr := socks.SplitAddr(packet[3:])
+		if srcAddr == nil {
+			continue
+		}
+		addr, err := transport.MakeNetAddr("udp", srcAddr.String())
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to convert incoming address: %w", err)
+		}
+		payload := packet[3+len(srcAddr):]
+		n = copy(b, payload)
+		if n < len(payload) {
+			return n, addr, io.ErrShortBuffer
+		}
+		return n, addr, nil
+	}
+}
+
+// Close closes the relay and the control connections, which ends the association.
+func (c *packetConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		err = errors.Join(c.Conn.Close(), c.controlConn.Close())
+	})
+	return err
+}
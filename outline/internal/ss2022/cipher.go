@@ -0,0 +1,122 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ss2022 implements the client side of the Shadowsocks 2022 Edition
+// protocol, as specified in https://shadowsocks.org/doc/sip022.html.
+//
+// Only single-user servers are supported; identity headers are not.
+package ss2022
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"lukechampine.com/blake3"
+)
+
+// Supported Shadowsocks 2022 ciphers.
+const (
+	CipherAES128GCM        = "2022-blake3-aes-128-gcm"
+	CipherAES256GCM        = "2022-blake3-aes-256-gcm"
+	CipherChaCha20Poly1305 = "2022-blake3-chacha20-poly1305"
+)
+
+// Context string used to derive session subkeys with BLAKE3.
+const subkeyContext = "shadowsocks 2022 session subkey"
+
+type cipherSpec struct {
+	keySize    int
+	newAEAD    func(key []byte) (cipher.AEAD, error)
+	isChaCha20 bool
+}
+
+var cipherSpecs = map[string]*cipherSpec{
+	CipherAES128GCM:        {16, newAESGCM, false},
+	CipherAES256GCM:        {32, newAESGCM, false},
+	CipherChaCha20Poly1305: {32, chacha20poly1305.New, true},
+}
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// IsSupportedCipher returns whether `cipherName` is a Shadowsocks 2022 cipher.
+func IsSupportedCipher(cipherName string) bool {
+	_, ok := cipherSpecs[strings.ToLower(cipherName)]
+	return ok
+}
+
+// Key holds the pre-shared key (PSK) of a Shadowsocks 2022 server along with
+// the primitives derived from it.
+type Key struct {
+	spec *cipherSpec
+	psk  []byte
+	// Encrypts the UDP separate header of the AES ciphers. Nil for ChaCha20.
+	headerBlock cipher.Block
+	// Encrypts whole UDP packets with XChaCha20-Poly1305. Nil for AES.
+	packetAEAD cipher.AEAD
+}
+
+// NewKey creates a Key for `cipherName` from the base64-encoded `psk`.
+// The decoded PSK must have the key size of the cipher.
+func NewKey(cipherName, psk string) (*Key, error) {
+	spec, ok := cipherSpecs[strings.ToLower(cipherName)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported Shadowsocks 2022 cipher %q", cipherName)
+	}
+	if strings.Contains(psk, ":") {
+		return nil, errors.New("multiple PSKs (identity headers) are not supported")
+	}
+	pskBytes, err := base64.StdEncoding.DecodeString(psk)
+	if err != nil {
+		return nil, fmt.Errorf("PSK must be base64-encoded: %w", err)
+	}
+	if len(pskBytes) != spec.keySize {
+		return nil, fmt.Errorf("PSK must be %d bytes long for %s, got %d", spec.keySize, cipherName, len(pskBytes))
+	}
+	key := &Key{spec: spec, psk: pskBytes}
+	if spec.isChaCha20 {
+		key.packetAEAD, err = chacha20poly1305.NewX(pskBytes)
+	} else {
+		key.headerBlock, err = aes.NewCipher(pskBytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// SaltSize returns the size of the TCP session salt, which equals the key size.
+func (k *Key) SaltSize() int {
+	return k.spec.keySize
+}
+
+// newSessionAEAD derives the session subkey for `salt` and returns its AEAD.
+// For UDP sessions of the AES ciphers, `salt` is the 8-byte session ID.
+func (k *Key) newSessionAEAD(salt []byte) (cipher.AEAD, error) {
+	material := make([]byte, 0, len(k.psk)+len(salt))
+	material = append(append(material, k.psk...), salt...)
+	subkey := make([]byte, k.spec.keySize)
+	blake3.DeriveKey(subkey, subkeyContext, material)
+	return k.spec.newAEAD(subkey)
+}
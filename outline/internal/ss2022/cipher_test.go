@@ -0,0 +1,72 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ss2022
+
+import (
+	"testing"
+)
+
+const (
+	testPSK16 = "VowGdt5iOcZgM0iAg3ROug=="
+	testPSK32 = "RgDD8f2yDJQljw0qxCZAzxwklY4X1x3aTCZF+2DL0cE="
+)
+
+// Test keys for all the supported ciphers.
+var testKeys = map[string]string{
+	CipherAES128GCM:        testPSK16,
+	CipherAES256GCM:        testPSK32,
+	CipherChaCha20Poly1305: testPSK32,
+}
+
+func TestNewKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		cipher  string
+		psk     string
+		wantErr bool
+	}{
+		{name: "aes-128-gcm", cipher: CipherAES128GCM, psk: testPSK16},
+		{name: "aes-256-gcm", cipher: CipherAES256GCM, psk: testPSK32},
+		{name: "chacha20-poly1305", cipher: CipherChaCha20Poly1305, psk: testPSK32},
+		{name: "upper case", cipher: "2022-BLAKE3-AES-128-GCM", psk: testPSK16},
+		{name: "unsupported cipher", cipher: "chacha20-ietf-poly1305", psk: testPSK32, wantErr: true},
+		{name: "short key", cipher: CipherAES256GCM, psk: testPSK16, wantErr: true},
+		{name: "long key", cipher: CipherAES128GCM, psk: testPSK32, wantErr: true},
+		{name: "not base64", cipher: CipherAES128GCM, psk: "not a key!", wantErr: true},
+		{name: "identity PSKs", cipher: CipherAES128GCM, psk: testPSK16 + ":" + testPSK16, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := NewKey(tt.cipher, tt.psk)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && key.SaltSize() != len(key.psk) {
+				t.Errorf("SaltSize() = %d, want %d", key.SaltSize(), len(key.psk))
+			}
+		})
+	}
+}
+
+func TestIsSupportedCipher(t *testing.T) {
+	for cipher := range testKeys {
+		if !IsSupportedCipher(cipher) {
+			t.Errorf("IsSupportedCipher(%q) = false", cipher)
+		}
+	}
+	if IsSupportedCipher("aes-256-gcm") {
+		t.Errorf("IsSupportedCipher(\"aes-256-gcm\") = true")
+	}
+}
@@ -0,0 +1,260 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ss2022
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/shadowsocks/go-shadowsocks2/socks"
+)
+
+const (
+	// Size of the session ID and packet ID pair that prefixes every packet.
+	separateHeaderSize = 16
+	// Size of the XChaCha20-Poly1305 nonce used by the ChaCha20 cipher.
+	xchachaNonceSize = 24
+	// clientUDPBufferSize is the maximum supported UDP packet size in bytes.
+	clientUDPBufferSize = 16 * 1024
+)
+
+type packetListener struct {
+	endpoint transport.PacketEndpoint
+	key      *Key
+}
+
+var _ transport.PacketListener = (*packetListener)(nil)
+
+// NewPacketListener creates a PacketListener that relays packets through a Shadowsocks 2022
+// proxy listening at `endpoint`, with `key` as the pre-shared key.
+func NewPacketListener(endpoint transport.PacketEndpoint, key *Key) (transport.PacketListener, error) {
+	if endpoint == nil {
+		return nil, errors.New("argument endpoint must not be nil")
+	}
+	if key == nil {
+		return nil, errors.New("argument key must not be nil")
+	}
+	return &packetListener{endpoint: endpoint, key: key}, nil
+}
+
+// ListenPacket starts a new UDP session with the proxy.
+func (l *packetListener) ListenPacket(ctx context.Context) (net.PacketConn, error) {
+	var sessionID [8]byte
+	if _, err := rand.Read(sessionID[:]); err != nil {
+		return nil, err
+	}
+	conn := &packetConn{key: l.key, sessionID: sessionID, readBuf: make([]byte, clientUDPBufferSize)}
+	if !l.key.spec.isChaCha20 {
+		var err error
+		if conn.sessionAEAD, err = l.key.newSessionAEAD(sessionID[:]); err != nil {
+			return nil, err
+		}
+	}
+	proxyConn, err := l.endpoint.Connect(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to endpoint: %w", err)
+	}
+	conn.Conn = proxyConn
+	return conn, nil
+}
+
+// serverSession holds the state of a server UDP session.
+type serverSession struct {
+	id     [8]byte
+	aead   cipher.AEAD
+	window slidingWindow
+}
+
+type packetConn struct {
+	net.Conn
+	key         *Key
+	sessionID   [8]byte
+	sessionAEAD cipher.AEAD // Nil for ChaCha20.
+
+	// Protects packetID.
+	writeMu  sync.Mutex
+	packetID uint64
+
+	// Protects the fields below.
+	readMu  sync.Mutex
+	readBuf []byte
+	// The current and the previous server sessions, to tolerate reordering after a server restart.
+	current, previous *serverSession
+}
+
+var _ net.PacketConn = (*packetConn)(nil)
+
+func (c *packetConn) nextPacketID() uint64 {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	id := c.packetID
+	c.packetID++
+	return id
+}
+
+// WriteTo encrypts `b` and writes to `addr` through the proxy.
+func (c *packetConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	socksTargetAddr := socks.ParseAddr(addr.String())
+	if socksTargetAddr == nil {
+		return 0, errors.New("failed to parse target address")
+	}
+	header := make([]byte, 0, separateHeaderSize)
+	header = append(header, c.sessionID[:]...)
+	header = appendUint64(header, c.nextPacketID())
+
+	body := make([]byte, 0, separateHeaderSize+1+timestampSize+2+len(socksTargetAddr)+len(b))
+	if c.key.spec.isChaCha20 {
+		body = append(body, header...)
+	}
+	body = append(body, headerTypeClient)
+	body = appendUint64(body, uint64(time.Now().Unix()))
+	body = appendUint16(body, 0) // No padding.
+	body = append(body, socksTargetAddr...)
+	body = append(body, b...)
+
+	var packet []byte
+	if c.key.spec.isChaCha20 {
+		packet = make([]byte, xchachaNonceSize, xchachaNonceSize+len(body)+c.key.packetAEAD.Overhead())
+		if _, err := rand.Read(packet); err != nil {
+			return 0, err
+		}
+		packet = c.key.packetAEAD.Seal(packet, packet[:xchachaNonceSize], body, nil)
+	} else {
+		packet = make([]byte, separateHeaderSize, separateHeaderSize+len(body)+c.sessionAEAD.Overhead())
+		c.key.headerBlock.Encrypt(packet, header)
+		packet = c.sessionAEAD.Seal(packet, header[4:], body, nil)
+	}
+	_, err := c.Conn.Write(packet)
+	return len(b), err
+}
+
+// ReadFrom reads from the proxy and decrypts into `b`. Packets that fail
+// authentication or validation, including replays, are dropped.
+func (c *packetConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	for {
+		n, err := c.Conn.Read(c.readBuf)
+		if err != nil {
+			return 0, nil, err
+		}
+		payload, srcAddr, err := c.unpack(c.readBuf[:n])
+		if err != nil {
+			continue
+		}
+		n = copy(b, payload)
+		if n < len(payload) {
+			return n, srcAddr, io.ErrShortBuffer
+		}
+		return n, srcAddr, nil
+	}
+}
+
+// unpack decrypts and validates `packet`, returning the payload and its source address.
+func (c *packetConn) unpack(packet []byte) ([]byte, net.Addr, error) {
+	var header [separateHeaderSize]byte
+	var body []byte
+	var session *serverSession
+	if c.key.spec.isChaCha20 {
+		if len(packet) < xchachaNonceSize {
+			return nil, nil, errors.New("packet is too short")
+		}
+		plaintext, err := c.key.packetAEAD.Open(packet[xchachaNonceSize:xchachaNonceSize], packet[:xchachaNonceSize], packet[xchachaNonceSize:], nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(plaintext) < separateHeaderSize {
+			return nil, nil, errors.New("packet is too short")
+		}
+		copy(header[:], plaintext)
+		body = plaintext[separateHeaderSize:]
+		session = c.serverSession(header[:8])
+	} else {
+		if len(packet) < separateHeaderSize {
+			return nil, nil, errors.New("packet is too short")
+		}
+		c.key.headerBlock.Decrypt(header[:], packet)
+		session = c.serverSession(header[:8])
+		if session.aead == nil {
+			var err error
+			if session.aead, err = c.key.newSessionAEAD(session.id[:]); err != nil {
+				return nil, nil, err
+			}
+		}
+		var err error
+		body, err = session.aead.Open(packet[separateHeaderSize:separateHeaderSize], header[4:], packet[separateHeaderSize:], nil)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if len(body) < 1+timestampSize+len(c.sessionID)+2 {
+		return nil, nil, errors.New("packet is too short")
+	}
+	if body[0] != headerTypeServer {
+		return nil, nil, fmt.Errorf("unexpected packet header type %d", body[0])
+	}
+	if err := validateTimestamp(binary.BigEndian.Uint64(body[1:]), time.Now()); err != nil {
+		return nil, nil, err
+	}
+	body = body[1+timestampSize:]
+	if string(body[:len(c.sessionID)]) != string(c.sessionID[:]) {
+		return nil, nil, errors.New("packet is for a different client session")
+	}
+	body = body[len(c.sessionID):]
+	paddingLength := int(binary.BigEndian.Uint16(body))
+	if len(body) < 2+paddingLength {
+		return nil, nil, errors.New("packet is too short")
+	}
+	body = body[2+paddingLength:]
+	socksSrcAddr := socks.SplitAddr(body)
+	if socksSrcAddr == nil {
+		return nil, nil, errors.New("failed to read source address")
+	}
+	srcAddr, err := transport.MakeNetAddr("udp", socksSrcAddr.String())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to convert incoming address: %w", err)
+	}
+
+	if !session.window.accept(binary.BigEndian.Uint64(header[8:])) {
+		return nil, nil, errors.New("replayed packet")
+	}
+	if session != c.current {
+		c.previous, c.current = c.current, session
+	}
+	return body[len(socksSrcAddr):], srcAddr, nil
+}
+
+// serverSession returns the known server session with `id`, or a new one. New sessions
+// are only remembered once a packet has been successfully validated.
+func (c *packetConn) serverSession(id []byte) *serverSession {
+	for _, s := range []*serverSession{c.current, c.previous} {
+		if s != nil && string(s.id[:]) == string(id) {
+			return s
+		}
+	}
+	s := &serverSession{}
+	copy(s.id[:], id)
+	return s
+}
@@ -0,0 +1,136 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ss2022
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+)
+
+func listenTestPacket(t *testing.T, key *Key, server *fakePacketServer) net.PacketConn {
+	listener, err := NewPacketListener(&transport.UDPEndpoint{Address: server.conn.LocalAddr().String()}, key)
+	if err != nil {
+		t.Fatalf("Failed to create PacketListener: %v", err)
+	}
+	conn, err := listener.ListenPacket(context.Background())
+	if err != nil {
+		t.Fatalf("ListenPacket failed: %v", err)
+	}
+	return conn
+}
+
+func TestPacketListener_Echo(t *testing.T) {
+	for cipherName, psk := range testKeys {
+		t.Run(cipherName, func(t *testing.T) {
+			key, err := NewKey(cipherName, psk)
+			if err != nil {
+				t.Fatalf("Failed to create key: %v", err)
+			}
+			server, err := newFakePacketServer(key)
+			if err != nil {
+				t.Fatalf("Failed to start server: %v", err)
+			}
+			defer server.conn.Close()
+			go server.serve()
+
+			conn := listenTestPacket(t, key, server)
+			defer conn.Close()
+			target := &net.UDPAddr{IP: net.ParseIP("2001:db8::53"), Port: 53}
+			for _, msg := range []string{"first", "second"} {
+				if _, err := conn.WriteTo([]byte(msg), target); err != nil {
+					t.Fatalf("WriteTo failed: %v", err)
+				}
+				conn.SetReadDeadline(time.Now().Add(time.Second))
+				buf := make([]byte, 100)
+				n, addr, err := conn.ReadFrom(buf)
+				if err != nil {
+					t.Fatalf("ReadFrom failed: %v", err)
+				}
+				if string(buf[:n]) != msg {
+					t.Errorf("Got payload %q, want %q", buf[:n], msg)
+				}
+				if addr.String() != target.String() {
+					t.Errorf("Got source address %v, want %v", addr, target)
+				}
+			}
+		})
+	}
+}
+
+func TestPacketListener_DropsReplays(t *testing.T) {
+	for cipherName, psk := range testKeys {
+		t.Run(cipherName, func(t *testing.T) {
+			key, _ := NewKey(cipherName, psk)
+			server, err := newFakePacketServer(key)
+			if err != nil {
+				t.Fatalf("Failed to start server: %v", err)
+			}
+			defer server.conn.Close()
+			server.repeat = 2
+			go server.serve()
+
+			conn := listenTestPacket(t, key, server)
+			defer conn.Close()
+			target := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 53}
+			conn.WriteTo([]byte("first"), target)
+			time.Sleep(50 * time.Millisecond) // Let the duplicate response arrive.
+			conn.WriteTo([]byte("second"), target)
+
+			buf := make([]byte, 100)
+			for _, want := range []string{"first", "second"} {
+				conn.SetReadDeadline(time.Now().Add(time.Second))
+				n, _, err := conn.ReadFrom(buf)
+				if err != nil {
+					t.Fatalf("ReadFrom failed: %v", err)
+				}
+				if string(buf[:n]) != want {
+					t.Errorf("Got payload %q, want %q", buf[:n], want)
+				}
+			}
+		})
+	}
+}
+
+func TestPacketListener_DropsReflectedPackets(t *testing.T) {
+	key, _ := NewKey(CipherAES128GCM, testPSK16)
+	server, err := newFakePacketServer(key)
+	if err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.conn.Close()
+	// Echo back the raw packets, which the client can decrypt but must reject as its own.
+	go func() {
+		buf := make([]byte, clientUDPBufferSize)
+		for {
+			n, addr, err := server.conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			server.conn.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	conn := listenTestPacket(t, key, server)
+	defer conn.Close()
+	conn.WriteTo([]byte("hello"), &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 53})
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, _, err := conn.ReadFrom(make([]byte, 100)); err == nil {
+		t.Error("Expected reflected packet to be dropped")
+	}
+}
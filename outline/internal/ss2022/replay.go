@@ -0,0 +1,101 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ss2022
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// Maximum allowed difference between a message timestamp and the local clock.
+	maxTimestampDifference = 30 * time.Second
+	// How long to remember salts seen in server responses.
+	saltRetention = 60 * time.Second
+	// Number of packet IDs tracked by the UDP replay filter.
+	replayWindowSize = 64
+)
+
+// validateTimestamp checks that the Unix epoch `timestamp` is close enough to `now`.
+func validateTimestamp(timestamp uint64, now time.Time) error {
+	diff := now.Sub(time.Unix(int64(timestamp), 0))
+	if diff > maxTimestampDifference || diff < -maxTimestampDifference {
+		return fmt.Errorf("timestamp is off by %v", diff)
+	}
+	return nil
+}
+
+// saltPool remembers recently seen salts in order to detect replayed responses.
+type saltPool struct {
+	sync.Mutex
+	seen map[string]time.Time
+}
+
+func newSaltPool() *saltPool {
+	return &saltPool{seen: make(map[string]time.Time)}
+}
+
+// add records `salt` and returns false if it was already seen within the retention period.
+func (p *saltPool) add(salt []byte, now time.Time) bool {
+	p.Lock()
+	defer p.Unlock()
+	for s, t := range p.seen {
+		if now.Sub(t) > saltRetention {
+			delete(p.seen, s)
+		}
+	}
+	if _, ok := p.seen[string(salt)]; ok {
+		return false
+	}
+	p.seen[string(salt)] = now
+	return true
+}
+
+// slidingWindow is a replay filter for UDP packet IDs. It accepts each ID at most
+// once, and rejects IDs that are older than the window.
+type slidingWindow struct {
+	started bool
+	last    uint64
+	// Bit i is set if packet ID (last - i) has been seen.
+	bitmap uint64
+}
+
+// accept records `id` and returns false if it is a replay or too old.
+func (w *slidingWindow) accept(id uint64) bool {
+	if !w.started {
+		w.started, w.last, w.bitmap = true, id, 1
+		return true
+	}
+	if id > w.last {
+		if shift := id - w.last; shift < replayWindowSize {
+			w.bitmap = w.bitmap<<shift | 1
+		} else {
+			w.bitmap = 1
+		}
+		w.last = id
+		return true
+	}
+	diff := w.last - id
+	if diff >= replayWindowSize {
+		return false
+	}
+	mask := uint64(1) << diff
+	if w.bitmap&mask != 0 {
+		return false
+	}
+	w.bitmap |= mask
+	return true
+}
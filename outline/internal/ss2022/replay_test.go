@@ -0,0 +1,76 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ss2022
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSlidingWindow(t *testing.T) {
+	var w slidingWindow
+	steps := []struct {
+		id   uint64
+		want bool
+	}{
+		{10, true},
+		{10, false},
+		{12, true},
+		{11, true},
+		{11, false},
+		{9, true},
+		{100, true},
+		{12, false}, // Out of the window.
+		{37, true},
+		{37, false},
+		{200, true},
+		{199, true},
+	}
+	for i, step := range steps {
+		if got := w.accept(step.id); got != step.want {
+			t.Errorf("step %d: accept(%d) = %v, want %v", i, step.id, got, step.want)
+		}
+	}
+}
+
+func TestSaltPool(t *testing.T) {
+	pool := newSaltPool()
+	now := time.Now()
+	if !pool.add([]byte("salt1"), now) {
+		t.Fatal("First salt was rejected")
+	}
+	if pool.add([]byte("salt1"), now.Add(time.Second)) {
+		t.Error("Replayed salt was accepted")
+	}
+	if !pool.add([]byte("salt2"), now.Add(time.Second)) {
+		t.Error("New salt was rejected")
+	}
+	if !pool.add([]byte("salt1"), now.Add(saltRetention+2*time.Second)) {
+		t.Error("Expired salt was rejected")
+	}
+}
+
+func TestValidateTimestamp(t *testing.T) {
+	now := time.Now()
+	if err := validateTimestamp(uint64(now.Unix()), now); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := validateTimestamp(uint64(now.Add(-time.Minute).Unix()), now); err == nil {
+		t.Error("Expected error for old timestamp")
+	}
+	if err := validateTimestamp(uint64(now.Add(time.Minute).Unix()), now); err == nil {
+		t.Error("Expected error for future timestamp")
+	}
+}
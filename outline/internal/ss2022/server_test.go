@@ -0,0 +1,208 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ss2022
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/shadowsocks/go-shadowsocks2/socks"
+)
+
+// This file contains a minimal in-process Shadowsocks 2022 server used by the tests.
+// Servers echo the payloads they receive back to the client.
+
+// fakeStreamServer serves a single TCP connection.
+type fakeStreamServer struct {
+	key *Key
+	// Overrides the response timestamp when non-zero.
+	timestamp uint64
+	// Overrides the response salt when non-nil.
+	salt []byte
+	// Receives the target address of the request.
+	targets chan string
+}
+
+func (s *fakeStreamServer) serve(conn net.Conn) error {
+	defer conn.Close()
+	requestSalt := make([]byte, s.key.SaltSize())
+	if _, err := io.ReadFull(conn, requestSalt); err != nil {
+		return err
+	}
+	aead, err := s.key.newSessionAEAD(requestSalt)
+	if err != nil {
+		return err
+	}
+	reader := &streamReader{r: conn, stream: newAEADStream(aead)}
+	fixedHeader, err := reader.readMessage(reader.stream, 1+timestampSize+2)
+	if err != nil {
+		return err
+	}
+	if fixedHeader[0] != headerTypeClient {
+		return errors.New("bad request type")
+	}
+	if err := validateTimestamp(binary.BigEndian.Uint64(fixedHeader[1:]), time.Now()); err != nil {
+		return err
+	}
+	length := binary.BigEndian.Uint16(fixedHeader[1+timestampSize:])
+	variableHeader, err := reader.readMessage(reader.stream, int(length))
+	if err != nil {
+		return err
+	}
+	target := socks.SplitAddr(variableHeader)
+	if target == nil {
+		return errors.New("bad target address")
+	}
+	if s.targets != nil {
+		s.targets <- target.String()
+	}
+	variableHeader = variableHeader[len(target):]
+	paddingLength := binary.BigEndian.Uint16(variableHeader)
+	initialPayload := append([]byte{}, variableHeader[2+paddingLength:]...)
+
+	responseSalt := s.salt
+	if responseSalt == nil {
+		responseSalt = make([]byte, s.key.SaltSize())
+		rand.Read(responseSalt)
+	}
+	timestamp := s.timestamp
+	if timestamp == 0 {
+		timestamp = uint64(time.Now().Unix())
+	}
+	responseAEAD, err := s.key.newSessionAEAD(responseSalt)
+	if err != nil {
+		return err
+	}
+	writer := &streamWriter{w: conn, stream: newAEADStream(responseAEAD)}
+	header := append([]byte{headerTypeServer}, make([]byte, timestampSize)...)
+	binary.BigEndian.PutUint64(header[1:], timestamp)
+	header = append(header, requestSalt...)
+	header = appendUint16(header, uint16(len(initialPayload)))
+	response := append([]byte{}, responseSalt...)
+	response = writer.stream.seal(response, header)
+	response = writer.stream.seal(response, initialPayload)
+	if _, err := conn.Write(response); err != nil {
+		return err
+	}
+	_, err = io.Copy(writer, reader)
+	return err
+}
+
+// fakePacketServer echoes packets on a UDP socket.
+type fakePacketServer struct {
+	key       *Key
+	conn      net.PacketConn
+	sessionID [8]byte
+	packetID  uint64
+	// Number of times each response is sent.
+	repeat int
+}
+
+func newFakePacketServer(key *Key) (*fakePacketServer, error) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &fakePacketServer{key: key, conn: conn, repeat: 1}
+	rand.Read(s.sessionID[:])
+	return s, nil
+}
+
+func (s *fakePacketServer) serve() error {
+	buf := make([]byte, clientUDPBufferSize)
+	for {
+		n, clientAddr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		clientSessionID, target, payload, err := s.unpack(buf[:n])
+		if err != nil {
+			return err
+		}
+		response, err := s.pack(clientSessionID, target, payload)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < s.repeat; i++ {
+			if _, err := s.conn.WriteTo(response, clientAddr); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *fakePacketServer) unpack(packet []byte) (sessionID []byte, target socks.Addr, payload []byte, err error) {
+	var body []byte
+	if s.key.spec.isChaCha20 {
+		plaintext, err := s.key.packetAEAD.Open(nil, packet[:xchachaNonceSize], packet[xchachaNonceSize:], nil)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		sessionID, body = plaintext[:8], plaintext[separateHeaderSize:]
+	} else {
+		header := make([]byte, separateHeaderSize)
+		s.key.headerBlock.Decrypt(header, packet)
+		aead, err := s.key.newSessionAEAD(header[:8])
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if body, err = aead.Open(nil, header[4:], packet[separateHeaderSize:], nil); err != nil {
+			return nil, nil, nil, err
+		}
+		sessionID = header[:8]
+	}
+	if body[0] != headerTypeClient {
+		return nil, nil, nil, fmt.Errorf("bad packet type %d", body[0])
+	}
+	body = body[1+timestampSize:]
+	paddingLength := binary.BigEndian.Uint16(body)
+	body = body[2+paddingLength:]
+	target = socks.SplitAddr(body)
+	return sessionID, target, body[len(target):], nil
+}
+
+func (s *fakePacketServer) pack(clientSessionID []byte, source socks.Addr, payload []byte) ([]byte, error) {
+	header := append(append([]byte{}, s.sessionID[:]...), make([]byte, 8)...)
+	binary.BigEndian.PutUint64(header[8:], s.packetID)
+	s.packetID++
+	var body []byte
+	if s.key.spec.isChaCha20 {
+		body = append(body, header...)
+	}
+	body = append(body, headerTypeServer)
+	body = appendUint64(body, uint64(time.Now().Unix()))
+	body = append(body, clientSessionID...)
+	body = appendUint16(body, 3)
+	body = append(body, 0, 0, 0)
+	body = append(body, source...)
+	body = append(body, payload...)
+	if s.key.spec.isChaCha20 {
+		nonce := make([]byte, xchachaNonceSize)
+		rand.Read(nonce)
+		return s.key.packetAEAD.Seal(nonce, nonce, body, nil), nil
+	}
+	aead, err := s.key.newSessionAEAD(s.sessionID[:])
+	if err != nil {
+		return nil, err
+	}
+	packet := make([]byte, separateHeaderSize)
+	s.key.headerBlock.Encrypt(packet, header)
+	return aead.Seal(packet, header[4:], body, nil), nil
+}
@@ -0,0 +1,282 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ss2022
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sync"
+	"time"
+)
+
+const (
+	headerTypeClient = 0
+	headerTypeServer = 1
+	// Maximum size of a chunk payload.
+	maxPayloadSize = 0xFFFF
+	// Maximum length of the padding in requests.
+	maxPaddingLength = 900
+	// Size of a timestamp in headers.
+	timestampSize = 8
+)
+
+// aeadStream seals or opens consecutive messages with an incrementing nonce.
+type aeadStream struct {
+	aead  cipher.AEAD
+	nonce []byte
+}
+
+func newAEADStream(aead cipher.AEAD) *aeadStream {
+	return &aeadStream{aead: aead, nonce: make([]byte, aead.NonceSize())}
+}
+
+func (s *aeadStream) seal(dst, plaintext []byte) []byte {
+	dst = s.aead.Seal(dst, s.nonce, plaintext, nil)
+	increment(s.nonce)
+	return dst
+}
+
+func (s *aeadStream) open(dst, ciphertext []byte) ([]byte, error) {
+	plaintext, err := s.aead.Open(dst, s.nonce, ciphertext, nil)
+	increment(s.nonce)
+	return plaintext, err
+}
+
+// increment treats `b` as a little-endian counter.
+func increment(b []byte) {
+	for i := range b {
+		b[i]++
+		if b[i] != 0 {
+			return
+		}
+	}
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+// streamWriter encrypts a client TCP stream. The request header is held until
+// the first Write or Flush, so that it's sent along with the initial payload.
+type streamWriter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	salt   []byte
+	stream *aeadStream
+	// SOCKS address of the target, nil once the request header has been sent.
+	target []byte
+	buf    []byte
+}
+
+func newStreamWriter(w io.Writer, key *Key, salt, target []byte) (*streamWriter, error) {
+	aead, err := key.newSessionAEAD(salt)
+	if err != nil {
+		return nil, err
+	}
+	return &streamWriter{w: w, salt: salt, stream: newAEADStream(aead), target: target}, nil
+}
+
+// Write encrypts `p` and writes it to the underlying writer.
+func (sw *streamWriter) Write(p []byte) (int, error) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	written := 0
+	if sw.target != nil {
+		n := len(p)
+		if maxInitial := maxPayloadSize - len(sw.target) - 2; n > maxInitial {
+			n = maxInitial
+		}
+		if err := sw.writeRequest(p[:n]); err != nil {
+			return 0, err
+		}
+		written, p = n, p[n:]
+	}
+	for len(p) > 0 {
+		n := len(p)
+		if n > maxPayloadSize {
+			n = maxPayloadSize
+		}
+		if err := sw.writeChunk(p[:n]); err != nil {
+			return written, err
+		}
+		written, p = written+n, p[n:]
+	}
+	return written, nil
+}
+
+// Flush sends the request header with no initial payload if it hasn't been sent yet.
+func (sw *streamWriter) Flush() error {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	if sw.target == nil {
+		return nil
+	}
+	return sw.writeRequest(nil)
+}
+
+// writeRequest writes the salt and the request headers, carrying `payload` as
+// the initial payload. Requests without payload are padded.
+func (sw *streamWriter) writeRequest(payload []byte) error {
+	paddingLength := 0
+	if len(payload) == 0 {
+		n, err := rand.Int(rand.Reader, big.NewInt(maxPaddingLength))
+		if err != nil {
+			return err
+		}
+		paddingLength = 1 + int(n.Int64())
+	}
+	variableHeader := make([]byte, 0, len(sw.target)+2+paddingLength+len(payload))
+	variableHeader = append(variableHeader, sw.target...)
+	variableHeader = appendUint16(variableHeader, uint16(paddingLength))
+	variableHeader = append(variableHeader, make([]byte, paddingLength)...)
+	variableHeader = append(variableHeader, payload...)
+
+	fixedHeader := make([]byte, 0, 1+timestampSize+2)
+	fixedHeader = append(fixedHeader, headerTypeClient)
+	fixedHeader = appendUint64(fixedHeader, uint64(time.Now().Unix()))
+	fixedHeader = appendUint16(fixedHeader, uint16(len(variableHeader)))
+
+	overhead := sw.stream.aead.Overhead()
+	buf := make([]byte, 0, len(sw.salt)+len(fixedHeader)+len(variableHeader)+2*overhead)
+	buf = append(buf, sw.salt...)
+	buf = sw.stream.seal(buf, fixedHeader)
+	buf = sw.stream.seal(buf, variableHeader)
+	sw.target = nil
+	_, err := sw.w.Write(buf)
+	return err
+}
+
+// writeChunk writes `payload` as a length chunk followed by a payload chunk.
+func (sw *streamWriter) writeChunk(payload []byte) error {
+	overhead := sw.stream.aead.Overhead()
+	if need := 2 + len(payload) + 2*overhead; cap(sw.buf) < need {
+		sw.buf = make([]byte, 0, need)
+	}
+	var length [2]byte
+	binary.BigEndian.PutUint16(length[:], uint16(len(payload)))
+	buf := sw.stream.seal(sw.buf[:0], length[:])
+	buf = sw.stream.seal(buf, payload)
+	_, err := sw.w.Write(buf)
+	return err
+}
+
+// streamReader decrypts a server TCP stream, validating the response header.
+type streamReader struct {
+	r           io.Reader
+	key         *Key
+	requestSalt []byte
+	saltPool    *saltPool
+	stream      *aeadStream
+	buf         []byte
+	leftover    []byte
+}
+
+func newStreamReader(r io.Reader, key *Key, requestSalt []byte, saltPool *saltPool) *streamReader {
+	return &streamReader{r: r, key: key, requestSalt: requestSalt, saltPool: saltPool}
+}
+
+// Read decrypts data from the underlying reader into `b`.
+func (sr *streamReader) Read(b []byte) (int, error) {
+	for len(sr.leftover) == 0 {
+		var err error
+		if sr.stream == nil {
+			err = sr.readResponseHeader()
+		} else {
+			err = sr.readChunk()
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	n := copy(b, sr.leftover)
+	sr.leftover = sr.leftover[n:]
+	return n, nil
+}
+
+// readResponseHeader reads the response salt and fixed-length header, and the
+// first payload chunk.
+func (sr *streamReader) readResponseHeader() error {
+	salt := make([]byte, sr.key.SaltSize())
+	if _, err := io.ReadFull(sr.r, salt); err != nil {
+		return err
+	}
+	aead, err := sr.key.newSessionAEAD(salt)
+	if err != nil {
+		return err
+	}
+	stream := newAEADStream(aead)
+	header, err := sr.readMessage(stream, 1+timestampSize+len(sr.requestSalt)+2)
+	if err != nil {
+		return fmt.Errorf("failed to read response header: %w", err)
+	}
+	if header[0] != headerTypeServer {
+		return fmt.Errorf("unexpected response header type %d", header[0])
+	}
+	now := time.Now()
+	if err := validateTimestamp(binary.BigEndian.Uint64(header[1:]), now); err != nil {
+		return fmt.Errorf("invalid response header: %w", err)
+	}
+	header = header[1+timestampSize:]
+	if !bytes.Equal(header[:len(sr.requestSalt)], sr.requestSalt) {
+		return errors.New("response does not match the request salt")
+	}
+	if !sr.saltPool.add(salt, now) {
+		return errors.New("replayed response salt")
+	}
+	length := binary.BigEndian.Uint16(header[len(sr.requestSalt):])
+	sr.stream = stream
+	sr.leftover, err = sr.readMessage(stream, int(length))
+	return err
+}
+
+// readChunk reads a length chunk and its payload chunk.
+func (sr *streamReader) readChunk() error {
+	lengthBuf, err := sr.readMessage(sr.stream, 2)
+	if err != nil {
+		return err
+	}
+	length := binary.BigEndian.Uint16(lengthBuf)
+	payload, err := sr.readMessage(sr.stream, int(length))
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	sr.leftover = payload
+	return err
+}
+
+// readMessage reads and decrypts a sealed message of `size` plaintext bytes.
+func (sr *streamReader) readMessage(stream *aeadStream, size int) ([]byte, error) {
+	need := size + stream.aead.Overhead()
+	if cap(sr.buf) < need {
+		sr.buf = make([]byte, need)
+	}
+	buf := sr.buf[:need]
+	if _, err := io.ReadFull(sr.r, buf); err != nil {
+		return nil, err
+	}
+	return stream.open(buf[:0], buf)
+}
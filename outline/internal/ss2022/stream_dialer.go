@@ -0,0 +1,89 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ss2022
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/Jigsaw-Code/outline-sdk/transport/shadowsocks"
+	"github.com/shadowsocks/go-shadowsocks2/socks"
+)
+
+// StreamDialer routes connections to a Shadowsocks 2022 proxy.
+type StreamDialer struct {
+	endpoint transport.StreamEndpoint
+	key      *Key
+	saltPool *saltPool
+
+	// SaltGenerator is used to generate the request salts.
+	// `SaltGenerator` may be `nil`, which defaults to [shadowsocks.RandomSaltGenerator].
+	SaltGenerator shadowsocks.SaltGenerator
+
+	// ClientDataWait specifies the amount of time to wait for client data before sending
+	// the request header to the proxy server. It's 10 milliseconds by default.
+	// See [shadowsocks.StreamDialer] for the rationale.
+	ClientDataWait time.Duration
+}
+
+var _ transport.StreamDialer = (*StreamDialer)(nil)
+
+// NewStreamDialer creates a StreamDialer that routes connections to a Shadowsocks 2022
+// proxy listening at `endpoint`, with `key` as the pre-shared key.
+func NewStreamDialer(endpoint transport.StreamEndpoint, key *Key) (*StreamDialer, error) {
+	if endpoint == nil {
+		return nil, errors.New("argument endpoint must not be nil")
+	}
+	if key == nil {
+		return nil, errors.New("argument key must not be nil")
+	}
+	return &StreamDialer{endpoint: endpoint, key: key, saltPool: newSaltPool(), ClientDataWait: 10 * time.Millisecond}, nil
+}
+
+// Dial implements StreamDialer.Dial via a Shadowsocks 2022 server.
+//
+// Like the Shadowsocks StreamDialer, it returns a connection after the connection to the proxy
+// is established, but before the connection to the target is established.
+func (d *StreamDialer) Dial(ctx context.Context, remoteAddr string) (transport.StreamConn, error) {
+	socksTargetAddr := socks.ParseAddr(remoteAddr)
+	if socksTargetAddr == nil {
+		return nil, errors.New("failed to parse target address")
+	}
+	saltGenerator := d.SaltGenerator
+	if saltGenerator == nil {
+		saltGenerator = shadowsocks.RandomSaltGenerator
+	}
+	salt := make([]byte, d.key.SaltSize())
+	if err := saltGenerator.GetSalt(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	proxyConn, err := d.endpoint.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sw, err := newStreamWriter(proxyConn, d.key, salt, socksTargetAddr)
+	if err != nil {
+		proxyConn.Close()
+		return nil, err
+	}
+	time.AfterFunc(d.ClientDataWait, func() {
+		sw.Flush()
+	})
+	sr := newStreamReader(proxyConn, d.key, salt, d.saltPool)
+	return transport.WrapConn(proxyConn, sr, sw), nil
+}
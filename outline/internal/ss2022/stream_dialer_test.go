@@ -0,0 +1,193 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ss2022
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/Jigsaw-Code/outline-sdk/transport/shadowsocks"
+)
+
+// startStreamServer accepts connections on a local port and serves them with `server`.
+func startStreamServer(t *testing.T, server *fakeStreamServer) *net.TCPListener {
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return listener
+}
+
+func newTestStreamDialer(t *testing.T, key *Key, listener *net.TCPListener) *StreamDialer {
+	dialer, err := NewStreamDialer(&transport.TCPEndpoint{Address: listener.Addr().String()}, key)
+	if err != nil {
+		t.Fatalf("Failed to create StreamDialer: %v", err)
+	}
+	return dialer
+}
+
+func TestStreamDialer_Echo(t *testing.T) {
+	for cipherName, psk := range testKeys {
+		t.Run(cipherName, func(t *testing.T) {
+			key, err := NewKey(cipherName, psk)
+			if err != nil {
+				t.Fatalf("Failed to create key: %v", err)
+			}
+			server := &fakeStreamServer{key: key, targets: make(chan string, 1)}
+			listener := startStreamServer(t, server)
+			defer listener.Close()
+
+			conn, err := newTestStreamDialer(t, key, listener).Dial(context.Background(), "example.com:443")
+			if err != nil {
+				t.Fatalf("Dial failed: %v", err)
+			}
+			defer conn.Close()
+
+			// Larger than the maximum initial payload and chunk size.
+			data := make([]byte, 3*maxPayloadSize)
+			rand.Read(data)
+			go func() {
+				conn.Write(data[:10])
+				conn.Write(data[10:])
+				conn.CloseWrite()
+			}()
+			got, err := io.ReadAll(conn)
+			if err != nil {
+				t.Fatalf("Read failed: %v", err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("Echoed data doesn't match, got %d bytes, want %d", len(got), len(data))
+			}
+			if target := <-server.targets; target != "example.com:443" {
+				t.Errorf("Server got target %v, want example.com:443", target)
+			}
+		})
+	}
+}
+
+func TestStreamDialer_NoInitialPayload(t *testing.T) {
+	key, _ := NewKey(CipherAES256GCM, testPSK32)
+	server := &fakeStreamServer{key: key, targets: make(chan string, 1)}
+	listener := startStreamServer(t, server)
+	defer listener.Close()
+
+	dialer := newTestStreamDialer(t, key, listener)
+	dialer.ClientDataWait = time.Millisecond
+	conn, err := dialer.Dial(context.Background(), "192.0.2.1:25")
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	select {
+	case target := <-server.targets:
+		if target != "192.0.2.1:25" {
+			t.Errorf("Server got target %v, want 192.0.2.1:25", target)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Request header was not flushed")
+	}
+}
+
+func TestStreamDialer_SaltGenerator(t *testing.T) {
+	key, _ := NewKey(CipherAES128GCM, testPSK16)
+	listener := startStreamServer(t, &fakeStreamServer{key: key})
+	defer listener.Close()
+
+	dialer := newTestStreamDialer(t, key, listener)
+	dialer.SaltGenerator = shadowsocks.NewPrefixSaltGenerator([]byte("POST "))
+	conn, err := dialer.Dial(context.Background(), "example.com:80")
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "hello" {
+		t.Errorf("Unexpected response %q, error: %v", buf, err)
+	}
+}
+
+func TestStreamDialer_StaleTimestamp(t *testing.T) {
+	key, _ := NewKey(CipherAES128GCM, testPSK16)
+	server := &fakeStreamServer{key: key, timestamp: uint64(time.Now().Add(-time.Hour).Unix())}
+	listener := startStreamServer(t, server)
+	defer listener.Close()
+
+	conn, err := newTestStreamDialer(t, key, listener).Dial(context.Background(), "example.com:80")
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("hello"))
+	if _, err := conn.Read(make([]byte, 5)); err == nil {
+		t.Error("Expected error for stale response timestamp")
+	}
+}
+
+func TestStreamDialer_ReplayedResponse(t *testing.T) {
+	key, _ := NewKey(CipherChaCha20Poly1305, testPSK32)
+	salt := make([]byte, key.SaltSize())
+	rand.Read(salt)
+	listener := startStreamServer(t, &fakeStreamServer{key: key, salt: salt})
+	defer listener.Close()
+
+	dialer := newTestStreamDialer(t, key, listener)
+	for i, wantErr := range []bool{false, true} {
+		conn, err := dialer.Dial(context.Background(), "example.com:80")
+		if err != nil {
+			t.Fatalf("Dial %d failed: %v", i, err)
+		}
+		conn.Write([]byte("hello"))
+		_, err = conn.Read(make([]byte, 5))
+		if (err != nil) != wantErr {
+			t.Errorf("Read %d error = %v, wantErr %v", i, err, wantErr)
+		}
+		conn.Close()
+	}
+}
+
+func TestStreamDialer_WrongKey(t *testing.T) {
+	serverKey, _ := NewKey(CipherAES256GCM, testPSK32)
+	clientKey, _ := NewKey(CipherChaCha20Poly1305, testPSK32)
+	listener := startStreamServer(t, &fakeStreamServer{key: serverKey})
+	defer listener.Close()
+
+	conn, err := newTestStreamDialer(t, clientKey, listener).Dial(context.Background(), "example.com:80")
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("hello"))
+	if _, err := conn.Read(make([]byte, 5)); err == nil {
+		t.Error("Expected error with mismatched keys")
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package uot relays UDP packets over a proxied stream, for networks that block UDP.
+//
+// It implements the client side of the UDP-over-TCP version 1 protocol used by
+// sing-box and compatible Shadowsocks servers. The client opens a stream to the
+// magic address "sp.udp-over-tcp.arpa:0", and then each packet in either direction
+// is framed as:
+//
+//	+------+----------+------+--------+---------+
+//	| ATYP | ADDR     | PORT | LENGTH | PAYLOAD |
+//	+------+----------+------+--------+---------+
+//	|  1   | Variable |  2   |   2    | LENGTH  |
+//	+------+----------+------+--------+---------+
+//
+// ATYP is 0x00 for a 4-byte IPv4 address, 0x01 for a 16-byte IPv6 address, and 0x02
+// for a domain name, prefixed by its 1-byte length. PORT and LENGTH are big-endian.
+// The address is the destination for packets sent by the client, and the source for
+// packets sent by the server.
+package uot
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+)
+
+// MagicAddress is the stream destination that signals UDP-over-TCP to the proxy.
+const MagicAddress = "sp.udp-over-tcp.arpa:0"
+
+const (
+	atypIPv4   = 0x00
+	atypIPv6   = 0x01
+	atypDomain = 0x02
+	// Maximum payload that fits in the LENGTH field.
+	maxPayloadSize = 0xFFFF
+)
+
+type packetListener struct {
+	dialer transport.StreamDialer
+}
+
+var _ transport.PacketListener = (*packetListener)(nil)
+
+// NewPacketListener creates a PacketListener that relays packets over streams
+// created with `dialer`, one stream per PacketConn.
+func NewPacketListener(dialer transport.StreamDialer) (transport.PacketListener, error) {
+	if dialer == nil {
+		return nil, errors.New("argument dialer must not be nil")
+	}
+	return &packetListener{dialer}, nil
+}
+
+// ListenPacket opens a UDP-over-TCP stream and returns it as a PacketConn.
+func (l *packetListener) ListenPacket(ctx context.Context) (net.PacketConn, error) {
+	conn, err := l.dialer.Dial(ctx, MagicAddress)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open UDP-over-TCP stream: %w", err)
+	}
+	return &packetConn{StreamConn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+type packetConn struct {
+	transport.StreamConn
+	writeMu sync.Mutex
+	readMu  sync.Mutex
+	reader  *bufio.Reader
+}
+
+var _ net.PacketConn = (*packetConn)(nil)
+
+// WriteTo frames `b` with the destination `addr` and writes it to the stream.
+func (c *packetConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if len(b) > maxPayloadSize {
+		return 0, fmt.Errorf("packet of %d bytes exceeds the maximum of %d", len(b), maxPayloadSize)
+	}
+	frame, err := appendAddress(make([]byte, 0, 1+1+255+2+2+len(b)), addr.String())
+	if err != nil {
+		return 0, err
+	}
+	frame = append(frame, byte(len(b)>>8), byte(len(b)))
+	frame = append(frame, b...)
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if _, err := c.StreamConn.Write(frame); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// ReadFrom reads the next packet from the stream into `b`.
+func (c *packetConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	addr, err := readAddress(c.reader)
+	if err != nil {
+		return 0, nil, err
+	}
+	var lengthBuf [2]byte
+	if _, err := io.ReadFull(c.reader, lengthBuf[:]); err != nil {
+		return 0, nil, unexpectedEOF(err)
+	}
+	length := int(binary.BigEndian.Uint16(lengthBuf[:]))
+	n, err := io.ReadFull(c.reader, b[:min(length, len(b))])
+	if err != nil {
+		return 0, nil, unexpectedEOF(err)
+	}
+	if n < length {
+		// Discard the rest of the packet to stay in sync with the framing.
+		if _, err := c.reader.Discard(length - n); err != nil {
+			return n, addr, unexpectedEOF(err)
+		}
+		return n, addr, io.ErrShortBuffer
+	}
+	return n, addr, nil
+}
+
+// Close closes the underlying stream.
+func (c *packetConn) Close() error {
+	return c.StreamConn.Close()
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// appendAddress appends the framing encoding of the "host:port" `address` to `b`.
+func appendAddress(b []byte, address string) ([]byte, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q: %w", portStr, err)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			b = append(append(b, atypIPv4), ip4...)
+		} else {
+			b = append(append(b, atypIPv6), ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return nil, fmt.Errorf("domain name is too long: %d bytes", len(host))
+		}
+		b = append(append(b, atypDomain, byte(len(host))), host...)
+	}
+	return append(b, byte(port>>8), byte(port)), nil
+}
+
+// readAddress reads a framing-encoded address from `r`.
+func readAddress(r *bufio.Reader) (net.Addr, error) {
+	atyp, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	var host string
+	switch atyp {
+	case atypIPv4, atypIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if atyp == atypIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		host = ip.String()
+	case atypDomain:
+		length, err := r.ReadByte()
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		name := make([]byte, length)
+		if _, err := io.ReadFull(r, name); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		host = string(name)
+	default:
+		return nil, fmt.Errorf("unknown address type %d", atyp)
+	}
+	var portBuf [2]byte
+	if _, err := io.ReadFull(r, portBuf[:]); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	port := binary.BigEndian.Uint16(portBuf[:])
+	return transport.MakeNetAddr("udp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+}
@@ -0,0 +1,178 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package uot
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+)
+
+// echoDialer dials a local UDP-over-TCP server that echoes every packet back
+// with the destination as the source. It records the dialed addresses.
+type echoDialer struct {
+	t         *testing.T
+	addresses []string
+}
+
+func (d *echoDialer) Dial(ctx context.Context, address string) (transport.StreamConn, error) {
+	d.addresses = append(d.addresses, address)
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		for {
+			addr, err := readAddress(reader)
+			if err != nil {
+				return
+			}
+			var lengthBuf [2]byte
+			if _, err := io.ReadFull(reader, lengthBuf[:]); err != nil {
+				return
+			}
+			payload := make([]byte, int(lengthBuf[0])<<8|int(lengthBuf[1]))
+			if _, err := io.ReadFull(reader, payload); err != nil {
+				return
+			}
+			frame, err := appendAddress(nil, addr.String())
+			if err != nil {
+				d.t.Errorf("Failed to encode address: %v", err)
+				return
+			}
+			frame = append(append(frame, lengthBuf[:]...), payload...)
+			conn.Write(frame)
+		}
+	}()
+	return (&transport.TCPStreamDialer{}).Dial(ctx, listener.Addr().String())
+}
+
+type failingDialer struct{}
+
+func (failingDialer) Dial(ctx context.Context, address string) (transport.StreamConn, error) {
+	return nil, errors.New("dial failed")
+}
+
+func TestNewPacketListener_NilDialer(t *testing.T) {
+	if _, err := NewPacketListener(nil); err == nil {
+		t.Error("Expected error for nil dialer")
+	}
+}
+
+func TestPacketListener_Echo(t *testing.T) {
+	dialer := &echoDialer{t: t}
+	listener, err := NewPacketListener(dialer)
+	if err != nil {
+		t.Fatalf("Failed to create PacketListener: %v", err)
+	}
+	conn, err := listener.ListenPacket(context.Background())
+	if err != nil {
+		t.Fatalf("ListenPacket failed: %v", err)
+	}
+	defer conn.Close()
+	if len(dialer.addresses) != 1 || dialer.addresses[0] != MagicAddress {
+		t.Errorf("Dialed %v, want [%v]", dialer.addresses, MagicAddress)
+	}
+
+	tests := []struct {
+		payload []byte
+		addr    net.Addr
+	}{
+		{[]byte("ipv4"), &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 53}},
+		{[]byte("ipv6"), &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 443}},
+		{[]byte{}, &net.UDPAddr{IP: net.ParseIP("192.0.2.2"), Port: 1}},
+		{bytes.Repeat([]byte{0xAB}, 1500), &net.UDPAddr{IP: net.ParseIP("192.0.2.3"), Port: 65535}},
+		{[]byte("domain"), stringAddr("example.com:123")},
+	}
+	for _, tt := range tests {
+		if n, err := conn.WriteTo(tt.payload, tt.addr); err != nil || n != len(tt.payload) {
+			t.Fatalf("WriteTo(%v) = %v, %v", tt.addr, n, err)
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		buf := make([]byte, 2000)
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("ReadFrom failed: %v", err)
+		}
+		if !bytes.Equal(buf[:n], tt.payload) {
+			t.Errorf("Got payload of %d bytes, want %d bytes", n, len(tt.payload))
+		}
+		if addr.String() != tt.addr.String() {
+			t.Errorf("Got source address %v, want %v", addr, tt.addr)
+		}
+	}
+}
+
+func TestPacketListener_ShortBuffer(t *testing.T) {
+	listener, _ := NewPacketListener(&echoDialer{t: t})
+	conn, err := listener.ListenPacket(context.Background())
+	if err != nil {
+		t.Fatalf("ListenPacket failed: %v", err)
+	}
+	defer conn.Close()
+	target := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 53}
+	conn.WriteTo([]byte("too long for the buffer"), target)
+	conn.WriteTo([]byte("next"), target)
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 5)
+	if n, _, err := conn.ReadFrom(buf); err != io.ErrShortBuffer || string(buf[:n]) != "too l" {
+		t.Errorf("Got %q, %v; want \"too l\", io.ErrShortBuffer", buf[:n], err)
+	}
+	// The framing must stay in sync after a truncated packet.
+	if n, _, err := conn.ReadFrom(buf); err != nil || string(buf[:n]) != "next" {
+		t.Errorf("Got %q, %v; want \"next\", nil", buf[:n], err)
+	}
+}
+
+func TestPacketListener_DialFailure(t *testing.T) {
+	listener, _ := NewPacketListener(failingDialer{})
+	if _, err := listener.ListenPacket(context.Background()); err == nil {
+		t.Error("Expected ListenPacket to fail")
+	}
+}
+
+func TestAppendAddress_Errors(t *testing.T) {
+	for _, address := range []string{"no-port", "example.com:99999", string(bytes.Repeat([]byte("a"), 256)) + ":53"} {
+		if _, err := appendAddress(nil, address); err == nil {
+			t.Errorf("Expected error for address %q", address)
+		}
+	}
+}
+
+func TestReadAddress_UnknownType(t *testing.T) {
+	if _, err := readAddress(bufio.NewReader(bytes.NewReader([]byte{0x03, 0, 0}))); err == nil {
+		t.Error("Expected error for unknown address type")
+	}
+}
+
+type stringAddr string
+
+func (a stringAddr) Network() string { return "udp" }
+func (a stringAddr) String() string  { return string(a) }
@@ -0,0 +1,182 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package websocket provides endpoints that carry streams and packets over
+// WebSocket connections, optionally secured with TLS. This allows tunneling
+// proxy traffic through fronting servers and CDNs.
+package websocket
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	ws "golang.org/x/net/websocket"
+)
+
+// Config specifies how to establish a WebSocket connection.
+type Config struct {
+	// Endpoint is used to connect to the WebSocket server. Must be non nil.
+	Endpoint transport.StreamEndpoint
+	// Host is sent in the HTTP Host header of the WebSocket handshake.
+	Host string
+	// Path is the path of the WebSocket URL. Defaults to "/".
+	Path string
+	// TLSConfig enables TLS when non nil. Set ServerName to control the SNI.
+	TLSConfig *tls.Config
+}
+
+// connect establishes a binary WebSocket connection as specified by `c`.
+func (c *Config) connect(ctx context.Context) (*ws.Conn, error) {
+	if c.Endpoint == nil {
+		return nil, errors.New("endpoint must not be nil")
+	}
+	scheme, originScheme := "ws", "http"
+	if c.TLSConfig != nil {
+		scheme, originScheme = "wss", "https"
+	}
+	location := &url.URL{Scheme: scheme, Host: c.Host, Path: c.Path}
+	if location.Path == "" {
+		location.Path = "/"
+	}
+	config, err := ws.NewConfig(location.String(), originScheme+"://"+c.Host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid WebSocket URL: %w", err)
+	}
+
+	conn, err := c.Endpoint.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Bound the handshakes by the context deadline, if any.
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	var rwc net.Conn = conn
+	if c.TLSConfig != nil {
+		tlsConn := tls.Client(conn, c.TLSConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("TLS handshake failed: %w", err)
+		}
+		rwc = tlsConn
+	}
+	wsConn, err := ws.NewClient(config, rwc)
+	if err != nil {
+		rwc.Close()
+		return nil, fmt.Errorf("WebSocket handshake failed: %w", err)
+	}
+	conn.SetDeadline(time.Time{})
+	wsConn.PayloadType = ws.BinaryFrame
+	return wsConn, nil
+}
+
+// StreamEndpoint is a [transport.StreamEndpoint] that carries the stream as
+// the payload of WebSocket binary frames.
+type StreamEndpoint struct {
+	Config
+}
+
+var _ transport.StreamEndpoint = (*StreamEndpoint)(nil)
+
+// Connect implements [transport.StreamEndpoint.Connect].
+func (e *StreamEndpoint) Connect(ctx context.Context) (transport.StreamConn, error) {
+	conn, err := e.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &streamConn{Conn: conn}, nil
+}
+
+// streamConn adapts a WebSocket connection to [transport.StreamConn].
+// WebSocket has no half-close: a close frame ends the connection in both directions. So
+// CloseWrite doesn't reach the peer, and the connection is only closed once both halves are
+// closed, or on Close.
+type streamConn struct {
+	*ws.Conn
+	mu          sync.Mutex
+	readClosed  bool
+	writeClosed bool
+}
+
+func (c *streamConn) CloseRead() error {
+	return c.closeHalf(&c.readClosed)
+}
+
+func (c *streamConn) CloseWrite() error {
+	return c.closeHalf(&c.writeClosed)
+}
+
+// closeHalf sets the `closed` flag of a half, and closes the connection if both are set.
+func (c *streamConn) closeHalf(closed *bool) error {
+	c.mu.Lock()
+	*closed = true
+	both := c.readClosed && c.writeClosed
+	c.mu.Unlock()
+	if both {
+		return c.Conn.Close()
+	}
+	return nil
+}
+
+// PacketEndpoint is a [transport.PacketEndpoint] that carries each packet
+// in a WebSocket binary message.
+type PacketEndpoint struct {
+	Config
+}
+
+var _ transport.PacketEndpoint = (*PacketEndpoint)(nil)
+
+// Connect implements [transport.PacketEndpoint.Connect].
+func (e *PacketEndpoint) Connect(ctx context.Context) (net.Conn, error) {
+	conn, err := e.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &packetConn{conn}, nil
+}
+
+// packetConn adapts a WebSocket connection to a packet-oriented net.Conn.
+type packetConn struct {
+	*ws.Conn
+}
+
+// Read reads one message into `b`. Returns [io.ErrShortBuffer] if the message
+// doesn't fit.
+func (c *packetConn) Read(b []byte) (int, error) {
+	var msg []byte
+	if err := ws.Message.Receive(c.Conn, &msg); err != nil {
+		return 0, err
+	}
+	n := copy(b, msg)
+	if n < len(msg) {
+		return n, io.ErrShortBuffer
+	}
+	return n, nil
+}
+
+// Write sends `b` as one message.
+func (c *packetConn) Write(b []byte) (int, error) {
+	if err := ws.Message.Send(c.Conn, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
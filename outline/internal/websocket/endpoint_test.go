@@ -0,0 +1,196 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	ws "golang.org/x/net/websocket"
+)
+
+// fakeServer is a local stand-in for a WebSocket fronting server. It echoes
+// streams on /tcp and messages on /udp, and records the handshake details.
+type fakeServer struct {
+	*httptest.Server
+	hosts chan string
+	snis  chan string
+}
+
+func newFakeServer(useTLS bool) *fakeServer {
+	s := &fakeServer{hosts: make(chan string, 10), snis: make(chan string, 10)}
+	mux := http.NewServeMux()
+	mux.Handle("/tcp", ws.Handler(func(conn *ws.Conn) {
+		s.hosts <- conn.Request().Host
+		io.Copy(conn, conn)
+	}))
+	mux.Handle("/udp", ws.Handler(func(conn *ws.Conn) {
+		s.hosts <- conn.Request().Host
+		for {
+			var msg []byte
+			if err := ws.Message.Receive(conn, &msg); err != nil {
+				return
+			}
+			if err := ws.Message.Send(conn, msg); err != nil {
+				return
+			}
+		}
+	}))
+	s.Server = httptest.NewUnstartedServer(mux)
+	if useTLS {
+		s.Server.TLS = &tls.Config{
+			GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+				s.snis <- hello.ServerName
+				return nil, nil
+			},
+		}
+		s.StartTLS()
+	} else {
+		s.Start()
+	}
+	return s
+}
+
+func (s *fakeServer) config(path string) Config {
+	address := strings.TrimPrefix(strings.TrimPrefix(s.URL, "https://"), "http://")
+	return Config{
+		Endpoint: &transport.TCPEndpoint{Address: address},
+		Host:     "cdn.example.com",
+		Path:     path,
+	}
+}
+
+func (s *fakeServer) tlsConfig() *tls.Config {
+	roots := x509.NewCertPool()
+	roots.AddCert(s.Certificate())
+	// The httptest certificate is valid for example.com.
+	return &tls.Config{RootCAs: roots, ServerName: "example.com"}
+}
+
+func TestStreamEndpoint(t *testing.T) {
+	server := newFakeServer(true)
+	defer server.Close()
+
+	endpoint := &StreamEndpoint{server.config("/tcp")}
+	endpoint.TLSConfig = server.tlsConfig()
+	conn, err := endpoint.Connect(context.Background())
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer conn.Close()
+	if sni := <-server.snis; sni != "example.com" {
+		t.Errorf("Server got SNI %q, want example.com", sni)
+	}
+	if host := <-server.hosts; host != "cdn.example.com" {
+		t.Errorf("Server got Host %q, want cdn.example.com", host)
+	}
+
+	data := bytes.Repeat([]byte("0123456789"), 10000)
+	go conn.Write(data)
+	got := make([]byte, len(data))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("Echoed data doesn't match")
+	}
+	if err := conn.CloseWrite(); err != nil {
+		t.Errorf("CloseWrite failed: %v", err)
+	}
+	// Without half-close, the connection is closed once both halves are closed.
+	if err := conn.CloseRead(); err != nil {
+		t.Errorf("CloseRead failed: %v", err)
+	}
+	if _, err := conn.Write([]byte("more")); err == nil {
+		t.Error("Write succeeded after closing both halves")
+	}
+}
+
+func TestPacketEndpoint(t *testing.T) {
+	server := newFakeServer(false)
+	defer server.Close()
+
+	endpoint := &PacketEndpoint{server.config("/udp")}
+	conn, err := endpoint.Connect(context.Background())
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer conn.Close()
+	if host := <-server.hosts; host != "cdn.example.com" {
+		t.Errorf("Server got Host %q, want cdn.example.com", host)
+	}
+
+	// Each write must be read back as a whole message.
+	for _, msg := range []string{"first", "second packet", "third"} {
+		if _, err := conn.Write([]byte(msg)); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	buf := make([]byte, 100)
+	for _, want := range []string{"first", "second packet", "third"} {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		if string(buf[:n]) != want {
+			t.Errorf("Got message %q, want %q", buf[:n], want)
+		}
+	}
+
+	conn.Write([]byte("too long for the buffer"))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(buf[:5]); err != io.ErrShortBuffer {
+		t.Errorf("Expected io.ErrShortBuffer, got %v", err)
+	}
+}
+
+func TestConnect_Failures(t *testing.T) {
+	server := newFakeServer(true)
+	defer server.Close()
+
+	t.Run("UntrustedCertificate", func(t *testing.T) {
+		endpoint := &StreamEndpoint{server.config("/tcp")}
+		endpoint.TLSConfig = &tls.Config{ServerName: "example.com"}
+		if _, err := endpoint.Connect(context.Background()); err == nil {
+			t.Error("Expected TLS verification error")
+		}
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		endpoint := &StreamEndpoint{server.config("/missing")}
+		endpoint.TLSConfig = server.tlsConfig()
+		if _, err := endpoint.Connect(context.Background()); err == nil {
+			t.Error("Expected WebSocket handshake error")
+		}
+	})
+
+	t.Run("NoEndpoint", func(t *testing.T) {
+		endpoint := &PacketEndpoint{}
+		if _, err := endpoint.Connect(context.Background()); err == nil {
+			t.Error("Expected error for missing endpoint")
+		}
+	})
+}
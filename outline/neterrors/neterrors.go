@@ -12,12 +12,15 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-// Package errors contains a model for errors shared with the Outline Client application.
+// Package neterrors contains a model for errors shared with the Outline Client application.
 //
-// TODO(fortuna): Revamp error handling. This is an inverted dependency. The Go code should
-// provide its own standalone API, leaving translations to the consumer.
+// Errors are reported as a [PlatformError], with a category, a message, a cause and whether
+// they can be retried. Its [Error] code is kept stable for older apps, which only handle the
+// integer codes.
 package neterrors
 
+// Error is the code of an error shared with the Outline Client application. It's an error
+// itself, that describes the code.
 type Error int
 
 func (e Error) Number() int {
@@ -25,6 +28,8 @@ func (e Error) Number() int {
 }
 
 // Outline error codes. Must be kept in sync with definitions in https://github.com/Jigsaw-Code/outline-client/blob/master/src/www/model/errors.ts
+// The codes after SystemMisconfigured are not defined there yet, so they are only reported
+// by [PlatformError], and as their [Error.LegacyCode] elsewhere.
 const (
 	NoError                     Error = 0
 	Unexpected                  Error = 1
@@ -39,4 +44,7 @@ const (
 	NoAdminPermissions          Error = 10 // Unused
 	UnsupportedRoutingTable     Error = 11 // Unused
 	SystemMisconfigured         Error = 12 // Electron only
+	ProxyConnectionReset        Error = 13 // Reset after the request, likely by the network
+	ProxyConnectionClosed       Error = 14 // Closed without a response, likely unable to reach the target
+	ProxyResponseTimeout        Error = 15 // No response, either an invalid key or dropped traffic
 )
@@ -0,0 +1,209 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package neterrors
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Categories of the errors, by their likely cause.
+const (
+	CategoryInternal = "internal"
+	CategoryConfig   = "config"
+	CategoryProxy    = "proxy"
+	CategoryNetwork  = "network"
+	CategorySystem   = "system"
+)
+
+type errorInfo struct {
+	message   string
+	category  string
+	retryable bool
+}
+
+var errorInfos = map[Error]errorInfo{
+	NoError:                     {"no error", "", false},
+	Unexpected:                  {"unexpected error", CategoryInternal, false},
+	NoVPNPermissions:            {"missing VPN permissions", CategorySystem, false},
+	AuthenticationFailure:       {"failed to authenticate to the proxy", CategoryProxy, false},
+	UDPConnectivity:             {"the proxy or the network doesn't support UDP", CategoryNetwork, true},
+	Unreachable:                 {"the proxy is unreachable", CategoryNetwork, true},
+	VpnStartFailure:             {"failed to start the VPN", CategorySystem, true},
+	IllegalConfiguration:        {"invalid proxy configuration", CategoryConfig, false},
+	ShadowsocksStartFailure:     {"failed to start Shadowsocks", CategorySystem, true},
+	ConfigureSystemProxyFailure: {"failed to configure the system proxy", CategorySystem, true},
+	NoAdminPermissions:          {"missing administrator permissions", CategorySystem, false},
+	UnsupportedRoutingTable:     {"unsupported routing table", CategorySystem, false},
+	SystemMisconfigured:         {"the system is misconfigured", CategorySystem, false},
+	ProxyConnectionReset:        {"the proxy connection was reset, likely by the network", CategoryNetwork, true},
+	ProxyConnectionClosed:       {"the proxy closed the connection without a response, likely unable to reach the target", CategoryProxy, true},
+	ProxyResponseTimeout:        {"the proxy didn't respond, either because the access key is invalid or because the network drops the traffic", CategoryNetwork, true},
+}
+
+// legacyCodes maps the codes that older apps don't define to the codes they reported before.
+var legacyCodes = map[Error]Error{
+	ProxyConnectionReset:  Unreachable,
+	ProxyConnectionClosed: Unreachable,
+	// Timeouts after the request used to be reported as authentication failures.
+	ProxyResponseTimeout: AuthenticationFailure,
+}
+
+func (e Error) info() errorInfo {
+	if info, ok := errorInfos[e]; ok {
+		return info
+	}
+	return errorInfo{message: fmt.Sprintf("error code %d", int(e)), category: CategoryInternal}
+}
+
+// Error implements the error interface, so that the codes can be matched with [errors.Is].
+func (e Error) Error() string {
+	return e.info().message
+}
+
+// Category returns the category of the code, one of the Category constants, or empty for
+// [NoError].
+func (e Error) Category() string {
+	return e.info().category
+}
+
+// Retryable returns whether the operation that failed with the code may succeed if retried,
+// without changing the configuration or the system.
+func (e Error) Retryable() bool {
+	return e.info().retryable
+}
+
+// LegacyCode returns the code to report to older apps, which only handle the integer codes
+// up to [SystemMisconfigured]. Newer codes are mapped to the code reported before they were
+// introduced, and unknown codes to [Unexpected].
+func (e Error) LegacyCode() Error {
+	if legacy, ok := legacyCodes[e]; ok {
+		return legacy
+	}
+	if _, ok := errorInfos[e]; !ok {
+		return Unexpected
+	}
+	return e
+}
+
+// PlatformError is an error shared with the Outline Client application. Its Code is stable
+// across versions, so that older apps can keep handling it.
+//
+// [errors.Is] matches a PlatformError with its Code, as in errors.Is(err, Unreachable), and
+// with the errors of its Cause.
+type PlatformError struct {
+	Code Error
+	// One of the Category constants.
+	Category string
+	// Human-readable description of the error, without the cause.
+	Message string
+	// The underlying error. May be nil.
+	Cause error
+	// Whether the operation may succeed if retried.
+	Retryable bool
+}
+
+// New creates a [PlatformError] of `code`, with its default category, message and retryability,
+// caused by `cause`, which may be nil.
+func New(code Error, cause error) *PlatformError {
+	info := code.info()
+	return &PlatformError{Code: code, Category: info.category, Message: info.message, Cause: cause, Retryable: info.retryable}
+}
+
+func (e *PlatformError) Error() string {
+	if e.Cause == nil {
+		return e.Message
+	}
+	return e.Message + ": " + e.Cause.Error()
+}
+
+func (e *PlatformError) Unwrap() error { return e.Cause }
+
+func (e *PlatformError) Is(target error) bool {
+	code, ok := target.(Error)
+	return ok && code == e.Code
+}
+
+// platformErrorJSON is the JSON representation of a [PlatformError].
+type platformErrorJSON struct {
+	Code      int    `json:"code"`
+	Category  string `json:"category"`
+	Message   string `json:"message"`
+	Cause     string `json:"cause,omitempty"`
+	Retryable bool   `json:"retryable"`
+}
+
+// MarshalJSON implements [json.Marshaler]. The cause is serialized as its message.
+func (e *PlatformError) MarshalJSON() ([]byte, error) {
+	errJSON := platformErrorJSON{Code: e.Code.Number(), Category: e.Category, Message: e.Message, Retryable: e.Retryable}
+	if e.Cause != nil {
+		errJSON.Cause = e.Cause.Error()
+	}
+	return json.Marshal(errJSON)
+}
+
+// UnmarshalJSON implements [json.Unmarshaler]. The cause is restored as an error with its message.
+func (e *PlatformError) UnmarshalJSON(data []byte) error {
+	var errJSON platformErrorJSON
+	if err := json.Unmarshal(data, &errJSON); err != nil {
+		return err
+	}
+	*e = PlatformError{Code: Error(errJSON.Code), Category: errJSON.Category, Message: errJSON.Message, Retryable: errJSON.Retryable}
+	if errJSON.Cause != "" {
+		e.Cause = errors.New(errJSON.Cause)
+	}
+	return nil
+}
+
+// FromError returns `err` as a [PlatformError]: the first PlatformError in its chain, a new one
+// with the first code in its chain, or a new [Unexpected] one otherwise. Returns nil if `err`
+// is nil.
+func FromError(err error) *PlatformError {
+	if err == nil {
+		return nil
+	}
+	var platformErr *PlatformError
+	if errors.As(err, &platformErr) {
+		return platformErr
+	}
+	var code Error
+	if errors.As(err, &code) {
+		if err == code {
+			return New(code, nil)
+		}
+		return New(code, err)
+	}
+	return New(Unexpected, err)
+}
+
+// CodeOf returns the code of `err`, as in [FromError], or [NoError] if `err` is nil.
+func CodeOf(err error) Error {
+	if err == nil {
+		return NoError
+	}
+	return FromError(err).Code
+}
+
+// ToJSON returns the JSON representation of `err` as a [PlatformError], or an empty string if
+// `err` is nil.
+func ToJSON(err error) string {
+	platformErr := FromError(err)
+	if platformErr == nil {
+		return ""
+	}
+	errJSON, _ := platformErr.MarshalJSON()
+	return string(errJSON)
+}
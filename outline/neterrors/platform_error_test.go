@@ -0,0 +1,159 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package neterrors
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestError_Info(t *testing.T) {
+	for code := NoError; code <= ProxyResponseTimeout; code++ {
+		if _, ok := errorInfos[code]; !ok {
+			t.Errorf("Missing information for code %d", code)
+		}
+		if code != NoError && code.Category() == "" {
+			t.Errorf("Code %d has no category", code)
+		}
+	}
+	unknown := Error(99)
+	if unknown.Error() != "error code 99" || unknown.Category() != CategoryInternal || unknown.Retryable() {
+		t.Errorf("Unknown code = %q, %q, %v", unknown.Error(), unknown.Category(), unknown.Retryable())
+	}
+}
+
+func TestError_LegacyCode(t *testing.T) {
+	tests := []struct {
+		code Error
+		want Error
+	}{
+		{NoError, NoError},
+		{AuthenticationFailure, AuthenticationFailure},
+		{SystemMisconfigured, SystemMisconfigured},
+		{ProxyConnectionReset, Unreachable},
+		{ProxyConnectionClosed, Unreachable},
+		{ProxyResponseTimeout, AuthenticationFailure},
+		{Error(99), Unexpected},
+	}
+	for _, tt := range tests {
+		if got := tt.code.LegacyCode(); got != tt.want {
+			t.Errorf("LegacyCode(%d) = %d, want %d", tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestPlatformError_Is(t *testing.T) {
+	err := fmt.Errorf("check failed: %w", New(Unreachable, io.EOF))
+	if !errors.Is(err, Unreachable) {
+		t.Error("Expected the error to match its code")
+	}
+	if errors.Is(err, UDPConnectivity) {
+		t.Error("Expected the error not to match another code")
+	}
+	if !errors.Is(err, io.EOF) {
+		t.Error("Expected the error to match its cause")
+	}
+	var platformErr *PlatformError
+	if !errors.As(err, &platformErr) || platformErr.Code != Unreachable {
+		t.Errorf("errors.As() = %v, want an Unreachable PlatformError", platformErr)
+	}
+	if got, want := err.Error(), "check failed: the proxy is unreachable: EOF"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+}
+
+func TestPlatformError_JSON(t *testing.T) {
+	tests := []struct {
+		name string
+		err  *PlatformError
+		want string
+	}{
+		{
+			name: "with cause",
+			err:  New(ProxyConnectionReset, io.ErrUnexpectedEOF),
+			want: `{"code":13,"category":"network","message":"the proxy connection was reset, likely by the network","cause":"unexpected EOF","retryable":true}`,
+		},
+		{
+			name: "without cause",
+			err:  New(AuthenticationFailure, nil),
+			want: `{"code":3,"category":"proxy","message":"failed to authenticate to the proxy","retryable":false}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := json.Marshal(tt.err)
+			if err != nil {
+				t.Fatalf("json.Marshal() failed: %v", err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("Got %s, want %s", data, tt.want)
+			}
+			var got PlatformError
+			if err := json.Unmarshal(data, &got); err != nil {
+				t.Fatalf("json.Unmarshal() failed: %v", err)
+			}
+			if got.Code != tt.err.Code || got.Category != tt.err.Category || got.Message != tt.err.Message || got.Retryable != tt.err.Retryable {
+				t.Errorf("Got %+v, want %+v", got, tt.err)
+			}
+			if got.Error() != tt.err.Error() {
+				t.Errorf("Error() = %q, want %q", got.Error(), tt.err.Error())
+			}
+		})
+	}
+}
+
+func TestFromError(t *testing.T) {
+	platformErr := New(Unreachable, io.EOF)
+	wrappedCode := fmt.Errorf("dial failed: %w", AuthenticationFailure)
+	tests := []struct {
+		name     string
+		err      error
+		want     *PlatformError
+		wantCode Error
+	}{
+		{name: "nil", err: nil, want: nil, wantCode: NoError},
+		{name: "platform error", err: fmt.Errorf("wrapped: %w", platformErr), want: platformErr, wantCode: Unreachable},
+		{name: "code", err: UDPConnectivity, want: New(UDPConnectivity, nil), wantCode: UDPConnectivity},
+		{name: "wrapped code", err: wrappedCode, want: New(AuthenticationFailure, wrappedCode), wantCode: AuthenticationFailure},
+		{name: "other", err: io.EOF, want: New(Unexpected, io.EOF), wantCode: Unexpected},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FromError(tt.err); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FromError() = %+v, want %+v", got, tt.want)
+			}
+			if got := CodeOf(tt.err); got != tt.wantCode {
+				t.Errorf("CodeOf() = %v, want %v", got.Number(), tt.wantCode.Number())
+			}
+		})
+	}
+}
+
+func TestToJSON(t *testing.T) {
+	if got := ToJSON(nil); got != "" {
+		t.Errorf("ToJSON(nil) = %q, want empty", got)
+	}
+	var got PlatformError
+	if err := json.Unmarshal([]byte(ToJSON(io.EOF)), &got); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if got.Code != Unexpected || got.Cause == nil || got.Cause.Error() != "EOF" {
+		t.Errorf("Got %+v, want an Unexpected error caused by EOF", got)
+	}
+}
@@ -20,6 +20,9 @@
 package shadowsocks
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"strconv"
@@ -27,7 +30,14 @@ import (
 
 	"github.com/Jigsaw-Code/outline-go-tun2socks/outline"
 	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/connectivity"
-	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/internal/utf8"
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/internal/connpool"
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/internal/happyeyeballs"
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/internal/mux"
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/internal/prefix"
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/internal/proxyclient"
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/internal/ss2022"
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/internal/uot"
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/neterrors"
 	"github.com/Jigsaw-Code/outline-sdk/transport"
 	"github.com/Jigsaw-Code/outline-sdk/transport/shadowsocks"
 	"github.com/eycorsican/go-tun2socks/common/log"
@@ -43,76 +53,263 @@ func NewClient(config *Config) (*Client, error) {
 	if config == nil {
 		return nil, fmt.Errorf("shadowsocks configuration is required")
 	}
-	return newShadowsocksClient(config.Host, config.Port, config.CipherName, config.Password, config.Prefix)
+	conf := &configJSON{
+		Host:     config.Host,
+		Port:     uint16(config.Port),
+		Method:   config.CipherName,
+		Password: config.Password,
+	}
+	if config.Port < 0 || config.Port > 65535 {
+		// Reported as out of range.
+		conf.Port = 0
+	}
+	if len(config.Prefix) > 0 {
+		conf.Prefix = fmt.Sprintf("hex:%x", config.Prefix)
+	}
+	return newClientFromConfig(conf)
 }
 
 // NewClientFromJSON creates a new Shadowsocks client from a JSON formatted
-// configuration.
+// configuration. Invalid configurations return a [*ConfigError].
 func NewClientFromJSON(configJSON string) (*Client, error) {
 	config, err := parseConfigFromJSON(configJSON)
 	if err != nil {
+		var configErr *ConfigError
+		if errors.As(err, &configErr) {
+			return nil, configErr
+		}
 		return nil, fmt.Errorf("failed to parse Shadowsocks configuration JSON: %w", err)
 	}
-	var prefixBytes []byte = nil
-	if len(config.Prefix) > 0 {
-		if p, err := utf8.DecodeUTF8CodepointsToRawBytes(config.Prefix); err != nil {
-			return nil, fmt.Errorf("failed to parse prefix string: %w", err)
-		} else {
-			prefixBytes = p
-		}
+	return newClientFromConfig(config)
+}
+
+// NewClientFromURL creates a new Shadowsocks client from a SIP002 access key
+// of the form ss://userinfo@host:port/?prefix=...#tag.
+func NewClientFromURL(accessKey string) (*Client, error) {
+	config, err := parseConfigFromURL(accessKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Shadowsocks URL: %w", err)
 	}
-	return newShadowsocksClient(config.Host, int(config.Port), config.Method, config.Password, prefixBytes)
+	return newClientFromConfig(config)
 }
 
-func newShadowsocksClient(host string, port int, cipherName, password string, prefix []byte) (*Client, error) {
-	if err := validateConfig(host, port, cipherName, password); err != nil {
+func newClientFromConfig(config *configJSON) (*Client, error) {
+	errs := &ConfigError{}
+	validateConfig(config, errs)
+	if errs.Len() > 0 {
+		return nil, errs
+	}
+	tcpPrefix, err := prefix.Parse(config.tcpPrefix())
+	if err != nil {
 		return nil, fmt.Errorf("invalid Shadowsocks configuration: %w", err)
 	}
+	udpPrefix, err := prefix.Parse(config.UDPPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Shadowsocks configuration: UDP %w", err)
+	}
+	client, err := newShadowsocksClient(config.Host, int(config.Port), config.Method, config.Password, tcpPrefix, udpPrefix, config.proxyHops(), config.WebSocket, config.ConnectionPool)
+	if err != nil {
+		return nil, err
+	}
+	if config.Multiplex != nil {
+		muxDialer, err := newMultiplexStreamDialer(client.StreamDialer, config.Multiplex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Shadowsocks configuration: %w", err)
+		}
+		client.StreamDialer = muxDialer
+		client.NetworkChangeHandler = networkChangeHandlers{client.NetworkChangeHandler, muxDialer}
+	}
+	if config.Plugin != "" {
+		// Fail the connections through the proxy, instead of bypassing the plugin.
+		pluginErr := fmt.Errorf("plugin %q is not supported", config.Plugin)
+		client.StreamDialer = unsupportedPluginDialer{pluginErr}
+		client.PacketListener = unsupportedPluginDialer{pluginErr}
+	}
+	if config.UDPOverTCP {
+		client.FallbackPacketListener, err = uot.NewPacketListener(client.StreamDialer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create UDP-over-TCP listener: %w", err)
+		}
+	}
+	return client, nil
+}
+
+// unsupportedPluginDialer is a [transport.StreamDialer] and [transport.PacketListener] that
+// fails with `err`, for proxies that require an unsupported plugin.
+type unsupportedPluginDialer struct {
+	err error
+}
 
-	// TODO: consider using net.LookupIP to get a list of IPs, and add logic for optimal selection.
-	proxyIP, err := net.ResolveIPAddr("ip", host)
+func (d unsupportedPluginDialer) Dial(ctx context.Context, raddr string) (transport.StreamConn, error) {
+	return nil, d.err
+}
+
+func (d unsupportedPluginDialer) ListenPacket(ctx context.Context) (net.PacketConn, error) {
+	return nil, d.err
+}
+
+// networkChangeHandlers notifies all its handlers of network changes, in order.
+type networkChangeHandlers []outline.NetworkChangeHandler
+
+func (hs networkChangeHandlers) OnNetworkChanged() {
+	for _, h := range hs {
+		h.OnNetworkChanged()
+	}
+}
+
+// Defaults for the stream multiplexing options.
+const (
+	defaultMultiplexMaxConnections = 4
+	defaultMultiplexMaxStreams     = 32
+)
+
+// newMultiplexStreamDialer wraps `dialer` to multiplex its streams as specified by `config`.
+func newMultiplexStreamDialer(dialer transport.StreamDialer, config *multiplexJSON) (*mux.StreamDialer, error) {
+	maxConnections, maxStreams := config.MaxConnections, config.MaxStreams
+	if maxConnections == 0 {
+		maxConnections = defaultMultiplexMaxConnections
+	}
+	if maxStreams == 0 {
+		maxStreams = defaultMultiplexMaxStreams
+	}
+	return mux.NewStreamDialer(dialer, maxConnections, maxStreams)
+}
+
+// Defaults for the connection pool options.
+const (
+	defaultConnectionPoolSize    = 2
+	defaultConnectionPoolMaxIdle = 30 * time.Second
+)
+
+// newPooledStreamEndpoint wraps `endpoint` with a pool of connections as specified by `config`.
+func newPooledStreamEndpoint(endpoint transport.StreamEndpoint, config *connectionPoolJSON) (*connpool.StreamEndpoint, error) {
+	size, maxIdle := config.Size, time.Duration(config.MaxIdleSeconds)*time.Second
+	if size == 0 {
+		size = defaultConnectionPoolSize
+	}
+	if maxIdle == 0 {
+		maxIdle = defaultConnectionPoolMaxIdle
+	}
+	return connpool.NewStreamEndpoint(endpoint, size, maxIdle)
+}
+
+func newShadowsocksClient(host string, port int, cipherName, password string, tcpPrefix, udpPrefix *prefix.Generator, proxyHops []proxyHopJSON, webSocket *webSocketJSON, pool *connectionPoolJSON) (*Client, error) {
+	var streamEndpoint transport.StreamEndpoint
+	var packetEndpoint transport.PacketEndpoint
+	var proxyEndpoint *happyeyeballs.Endpoint
+	if len(proxyHops) > 0 {
+		var err error
+		streamEndpoint, packetEndpoint, proxyEndpoint, err = newProxyChainEndpoints(host, port, proxyHops)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// Keep all the proxy addresses, and race them when connecting.
+		proxyEndpoint = happyeyeballs.NewEndpoint(host, port, happyeyeballs.DefaultLookup)
+		if err := proxyEndpoint.Resolve(context.Background()); err != nil {
+			errs := &ConfigError{}
+			errs.add("host", ReasonUnresolvable, "failed to resolve proxy address: %v", err)
+			return nil, errs
+		}
+		streamEndpoint = &happyeyeballs.StreamEndpoint{Endpoint: proxyEndpoint}
+		packetEndpoint = &happyeyeballs.PacketEndpoint{Endpoint: proxyEndpoint}
+	}
+	if webSocket != nil {
+		streamEndpoint, packetEndpoint = newWebSocketEndpoints(host, port, streamEndpoint, webSocket)
+	}
+	var networkChangeHandler outline.NetworkChangeHandler = proxyEndpoint
+	if pool != nil {
+		poolEndpoint, err := newPooledStreamEndpoint(streamEndpoint, pool)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Shadowsocks configuration: %w", err)
+		}
+		streamEndpoint = poolEndpoint
+		networkChangeHandler = networkChangeHandlers{proxyEndpoint, poolEndpoint}
+	}
+
+	var streamDialer transport.StreamDialer
+	var packetListener transport.PacketListener
+	var err error
+	if ss2022.IsSupportedCipher(cipherName) {
+		streamDialer, packetListener, err = newShadowsocks2022Transports(streamEndpoint, packetEndpoint, cipherName, password, tcpPrefix)
+	} else {
+		streamDialer, packetListener, err = newShadowsocksTransports(streamEndpoint, packetEndpoint, cipherName, password, tcpPrefix, udpPrefix)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to resolve proxy address: %w", err)
+		return nil, err
 	}
-	proxyAddress := net.JoinHostPort(proxyIP.String(), fmt.Sprint(port))
 
+	return &Client{StreamDialer: streamDialer, PacketListener: packetListener, NetworkChangeHandler: networkChangeHandler}, nil
+}
+
+func newShadowsocksTransports(streamEndpoint transport.StreamEndpoint, packetEndpoint transport.PacketEndpoint, cipherName, password string, tcpPrefix, udpPrefix *prefix.Generator) (transport.StreamDialer, transport.PacketListener, error) {
 	cryptoKey, err := shadowsocks.NewEncryptionKey(cipherName, password)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create Shadowsocks cipher: %w", err)
+		return nil, nil, fmt.Errorf("failed to create Shadowsocks cipher: %w", err)
 	}
 
-	streamDialer, err := shadowsocks.NewStreamDialer(&transport.TCPEndpoint{Address: proxyAddress}, cryptoKey)
+	streamDialer, err := shadowsocks.NewStreamDialer(streamEndpoint, cryptoKey)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create StreamDialer: %w", err)
+		return nil, nil, fmt.Errorf("failed to create StreamDialer: %w", err)
 	}
-	if len(prefix) > 0 {
-		log.Debugf("Using salt prefix: %s", string(prefix))
-		streamDialer.SaltGenerator = shadowsocks.NewPrefixSaltGenerator(prefix)
+	if tcpPrefix != nil {
+		log.Debugf("Using salt prefix: %s", tcpPrefix)
+		streamDialer.SaltGenerator = tcpPrefix
 	}
 
-	packetListener, err := shadowsocks.NewPacketListener(&transport.UDPEndpoint{Address: proxyAddress}, cryptoKey)
+	var packetListener transport.PacketListener
+	if udpPrefix != nil {
+		log.Debugf("Using UDP salt prefix: %s", udpPrefix)
+		packetListener, err = prefix.NewPacketListener(packetEndpoint, cryptoKey, udpPrefix)
+	} else {
+		packetListener, err = shadowsocks.NewPacketListener(packetEndpoint, cryptoKey)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to create PacketListener: %w", err)
+		return nil, nil, fmt.Errorf("failed to create PacketListener: %w", err)
 	}
+	return streamDialer, packetListener, nil
+}
 
-	return &Client{StreamDialer: streamDialer, PacketListener: packetListener}, nil
+// newShadowsocks2022Transports creates the transports for the Shadowsocks 2022 ciphers,
+// where `password` is the base64-encoded pre-shared key.
+func newShadowsocks2022Transports(streamEndpoint transport.StreamEndpoint, packetEndpoint transport.PacketEndpoint, cipherName, password string, tcpPrefix *prefix.Generator) (transport.StreamDialer, transport.PacketListener, error) {
+	key, err := ss2022.NewKey(cipherName, password)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create Shadowsocks 2022 key: %w", err)
+	}
+
+	streamDialer, err := ss2022.NewStreamDialer(streamEndpoint, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create StreamDialer: %w", err)
+	}
+	if tcpPrefix != nil {
+		log.Debugf("Using salt prefix: %s", tcpPrefix)
+		streamDialer.SaltGenerator = tcpPrefix
+	}
+
+	packetListener, err := ss2022.NewPacketListener(packetEndpoint, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create PacketListener: %w", err)
+	}
+	return streamDialer, packetListener, nil
 }
 
-// Error number constants exported through gomobile
+// Error number constants exported through gomobile, defined by [neterrors]. The newer codes
+// are only reported by CheckConnectivityJSON.
 const (
-	NoError                     = 0
-	Unexpected                  = 1
-	NoVPNPermissions            = 2 // Unused
-	AuthenticationFailure       = 3
-	UDPConnectivity             = 4
-	Unreachable                 = 5
-	VpnStartFailure             = 6  // Unused
-	IllegalConfiguration        = 7  // Electron only
-	ShadowsocksStartFailure     = 8  // Unused
-	ConfigureSystemProxyFailure = 9  // Unused
-	NoAdminPermissions          = 10 // Unused
-	UnsupportedRoutingTable     = 11 // Unused
-	SystemMisconfigured         = 12 // Electron only
+	NoError                     = int(neterrors.NoError)
+	Unexpected                  = int(neterrors.Unexpected)
+	NoVPNPermissions            = int(neterrors.NoVPNPermissions) // Unused
+	AuthenticationFailure       = int(neterrors.AuthenticationFailure)
+	UDPConnectivity             = int(neterrors.UDPConnectivity)
+	Unreachable                 = int(neterrors.Unreachable)
+	VpnStartFailure             = int(neterrors.VpnStartFailure)             // Unused
+	IllegalConfiguration        = int(neterrors.IllegalConfiguration)        // Electron only
+	ShadowsocksStartFailure     = int(neterrors.ShadowsocksStartFailure)     // Unused
+	ConfigureSystemProxyFailure = int(neterrors.ConfigureSystemProxyFailure) // Unused
+	NoAdminPermissions          = int(neterrors.NoAdminPermissions)          // Unused
+	UnsupportedRoutingTable     = int(neterrors.UnsupportedRoutingTable)     // Unused
+	SystemMisconfigured         = int(neterrors.SystemMisconfigured)         // Electron only
 )
 
 const reachabilityTimeout = 10 * time.Second
@@ -122,8 +319,89 @@ const reachabilityTimeout = 10 * time.Second
 // error code to return accounting for transient network failures.
 // Returns an error if an unexpected error ocurrs.
 func CheckConnectivity(client *Client) (int, error) {
-	errCode, err := connectivity.CheckConnectivity((*outline.Client)(client))
-	return errCode.Number(), err
+	return proxyclient.ErrorCode(proxyclient.Check((*outline.Client)(client), nil, true))
+}
+
+// CheckConnectivityWithChecker is like [CheckConnectivity], with the targets, timeouts and
+// retry policy of `checker`. The check can be stopped with [connectivity.Checker.Cancel].
+func CheckConnectivityWithChecker(client *Client, checker *connectivity.Checker) (int, error) {
+	return proxyclient.ErrorCode(proxyclient.Check((*outline.Client)(client), checker, true))
+}
+
+// CheckConnectivityJSON is like [CheckConnectivityWithChecker], and returns the failure as the
+// JSON of a [neterrors.PlatformError], with its code, category, message, cause and whether it
+// can be retried, or an empty string on success.
+func CheckConnectivityJSON(client *Client, checker *connectivity.Checker) string {
+	return neterrors.ToJSON(proxyclient.Check((*outline.Client)(client), checker, true))
+}
+
+// ProbeMaxUDPPayload returns the largest UDP payload that round-trips through the Shadowsocks
+// proxy, with the options of `checker`, or the defaults if nil. The result can be passed to
+// the SetMaxUDPPayloadSize method of the tunnel.
+// Returns an error if UDP is not supported.
+func ProbeMaxUDPPayload(client *Client, checker *connectivity.Checker) (int, error) {
+	if checker == nil {
+		checker = connectivity.NewChecker()
+	}
+	return checker.ProbeMaxUDPPayload(context.Background(), client)
+}
+
+// CheckIPv6Connectivity determines whether the Shadowsocks proxy can reach IPv6 destinations
+// over TCP and UDP, with the options of `checker`, or the defaults if nil. The result can be
+// passed to the SetIPv6Support method of the tunnel.
+func CheckIPv6Connectivity(client *Client, checker *connectivity.Checker) (*connectivity.IPv6Support, error) {
+	if checker == nil {
+		checker = connectivity.NewChecker()
+	}
+	return checker.CheckIPv6Connectivity(context.Background(), (*outline.Client)(client))
+}
+
+// NewMonitor creates a [connectivity.Monitor] of the Shadowsocks proxy, with the options of
+// `checker`, or the defaults if nil, that notifies `listener` of the connectivity state
+// transitions once started.
+func NewMonitor(client *Client, checker *connectivity.Checker, listener connectivity.MonitorListener) *connectivity.Monitor {
+	if checker == nil {
+		checker = connectivity.NewChecker()
+	}
+	return connectivity.NewMonitor(checker, (*outline.Client)(client), listener)
+}
+
+// DiagnoseConnectivity runs the connectivity checks of the Shadowsocks proxy configured by
+// `configJSON` stage by stage, with the options of `checker`, or the defaults if nil.
+// Returns a JSON [connectivity.Report] with the timing and the error class of each stage.
+// Invalid configurations return a [*ConfigError].
+func DiagnoseConnectivity(configJSON string, checker *connectivity.Checker) (string, error) {
+	config, err := parseConfigFromJSON(configJSON)
+	if err != nil {
+		var configErr *ConfigError
+		if errors.As(err, &configErr) {
+			return "", configErr
+		}
+		return "", fmt.Errorf("failed to parse Shadowsocks configuration JSON: %w", err)
+	}
+	if checker == nil {
+		checker = connectivity.NewChecker()
+	}
+	// The first hop is the one connected to directly.
+	host, port := config.Host, int(config.Port)
+	if hops := config.proxyHops(); len(hops) > 0 {
+		host, port = hops[0].Host, int(hops[0].Port)
+	}
+	client, err := newClientFromConfig(config)
+	if err != nil {
+		var configErr *ConfigError
+		if !errors.As(err, &configErr) || configErr.Len() != 1 || configErr.Get(0).Reason != ReasonUnresolvable {
+			return "", err
+		}
+		// The resolution failure is diagnosed by the report.
+		client = nil
+	}
+	report := checker.Diagnose(context.Background(), (*outline.Client)(client), host, port)
+	reportJSON, err := json.Marshal(report)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize the report: %w", err)
+	}
+	return string(reportJSON), nil
 }
 
 // CheckServerReachable determines whether the server at `host:port` is reachable over TCP.
@@ -14,7 +14,13 @@
 
 package shadowsocks
 
-import "testing"
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/connectivity"
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/internal/mux"
+)
 
 func Test_NewClientFromJSON_Errors(t *testing.T) {
 	tests := []struct {
@@ -65,6 +71,46 @@ func Test_NewClientFromJSON_Errors(t *testing.T) {
 			name:  "prefix out-of-range",
 			input: `{"host":"192.0.2.1","port":8080,"method":"some-cipher","password":"abcd1234","prefix":"\x1234"}`,
 		},
+		{
+			name:  "websocket path without slash",
+			input: `{"host":"192.0.2.1","port":443,"method":"chacha20-ietf-poly1305","password":"abcd1234","websocket":{"path":"tcp"}}`,
+		},
+		{
+			name:  "websocket UDP path without slash",
+			input: `{"host":"192.0.2.1","port":443,"method":"chacha20-ietf-poly1305","password":"abcd1234","websocket":{"path":"/tcp","udpPath":"udp"}}`,
+		},
+		{
+			name:  "2022 cipher with non-base64 PSK",
+			input: `{"host":"192.0.2.1","port":8080,"method":"2022-blake3-aes-128-gcm","password":"abcd1234"}`,
+		},
+		{
+			name:  "2022 cipher with wrong PSK size",
+			input: `{"host":"192.0.2.1","port":8080,"method":"2022-blake3-aes-256-gcm","password":"VowGdt5iOcZgM0iAg3ROug=="}`,
+		},
+		{
+			name:  "negative multiplex connections",
+			input: `{"host":"192.0.2.1","port":8080,"method":"chacha20-ietf-poly1305","password":"SECRET","multiplex":{"maxConnections":-1}}`,
+		},
+		{
+			name:  "invalid hex prefix",
+			input: `{"host":"192.0.2.1","port":8080,"method":"chacha20-ietf-poly1305","password":"SECRET","prefix":"hex:xyz"}`,
+		},
+		{
+			name:  "prefix longer than salt",
+			input: `{"host":"192.0.2.1","port":8080,"method":"aes-128-gcm","password":"SECRET","prefix":"template:POST {rand:8-12}"}`,
+		},
+		{
+			name:  "invalid UDP prefix",
+			input: `{"host":"192.0.2.1","port":8080,"method":"chacha20-ietf-poly1305","password":"SECRET","udpPrefix":"template:{rand}"}`,
+		},
+		{
+			name:  "UDP prefix with 2022 cipher",
+			input: `{"host":"192.0.2.1","port":8080,"method":"2022-blake3-aes-128-gcm","password":"VowGdt5iOcZgM0iAg3ROug==","udpPrefix":"hex:00"}`,
+		},
+		{
+			name:  "negative connection pool size",
+			input: `{"host":"192.0.2.1","port":8080,"method":"chacha20-ietf-poly1305","password":"SECRET","connectionPool":{"size":-1}}`,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -76,3 +122,131 @@ func Test_NewClientFromJSON_Errors(t *testing.T) {
 		})
 	}
 }
+
+func Test_NewClientFromJSON_Shadowsocks2022(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{
+			name:  "aes-128-gcm",
+			input: `{"host":"192.0.2.1","port":8080,"method":"2022-blake3-aes-128-gcm","password":"VowGdt5iOcZgM0iAg3ROug=="}`,
+		},
+		{
+			name:  "aes-256-gcm",
+			input: `{"host":"192.0.2.1","port":8080,"method":"2022-blake3-aes-256-gcm","password":"RgDD8f2yDJQljw0qxCZAzxwklY4X1x3aTCZF+2DL0cE="}`,
+		},
+		{
+			name:  "chacha20-poly1305 with prefix",
+			input: `{"host":"192.0.2.1","port":8080,"method":"2022-blake3-chacha20-poly1305","password":"RgDD8f2yDJQljw0qxCZAzxwklY4X1x3aTCZF+2DL0cE=","prefix":"POST "}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewClientFromJSON(tt.input)
+			if err != nil || got == nil {
+				t.Errorf("NewClientFromJSON() failed: %v", err)
+			}
+		})
+	}
+}
+
+func Test_NewClientFromJSON_UDPOverTCP(t *testing.T) {
+	tests := []struct {
+		name         string
+		input        string
+		wantFallback bool
+	}{
+		{
+			name:         "enabled",
+			input:        `{"host":"192.0.2.1","port":8080,"method":"chacha20-ietf-poly1305","password":"SECRET","udpOverTcp":true}`,
+			wantFallback: true,
+		},
+		{
+			name:  "default",
+			input: `{"host":"192.0.2.1","port":8080,"method":"chacha20-ietf-poly1305","password":"SECRET"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewClientFromJSON(tt.input)
+			if err != nil {
+				t.Fatalf("NewClientFromJSON() failed: %v", err)
+			}
+			if hasFallback := got.FallbackPacketListener != nil; hasFallback != tt.wantFallback {
+				t.Errorf("Got FallbackPacketListener %v, want present = %v", got.FallbackPacketListener, tt.wantFallback)
+			}
+		})
+	}
+}
+
+func Test_NewClientFromJSON_Multiplex(t *testing.T) {
+	got, err := NewClientFromJSON(`{"host":"192.0.2.1","port":8080,"method":"chacha20-ietf-poly1305","password":"SECRET","multiplex":{"maxStreams":8}}`)
+	if err != nil {
+		t.Fatalf("NewClientFromJSON() failed: %v", err)
+	}
+	if _, ok := got.StreamDialer.(*mux.StreamDialer); !ok {
+		t.Errorf("Got StreamDialer of type %T, want *mux.StreamDialer", got.StreamDialer)
+	}
+}
+
+func Test_NewClientFromJSON_ConnectionPool(t *testing.T) {
+	got, err := NewClientFromJSON(`{"host":"192.0.2.1","port":8080,"method":"chacha20-ietf-poly1305","password":"SECRET","connectionPool":{"size":1,"maxIdleSeconds":10}}`)
+	if err != nil || got == nil {
+		t.Fatalf("NewClientFromJSON() failed: %v", err)
+	}
+	if _, ok := got.NetworkChangeHandler.(networkChangeHandlers); !ok {
+		t.Errorf("Got NetworkChangeHandler of type %T, want networkChangeHandlers", got.NetworkChangeHandler)
+	}
+}
+
+func Test_NewClientFromJSON_PrefixFormats(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{
+			name:  "hex",
+			input: `{"host":"192.0.2.1","port":8080,"method":"chacha20-ietf-poly1305","password":"SECRET","prefix":"hex:160301"}`,
+		},
+		{
+			name:  "base64",
+			input: `{"host":"192.0.2.1","port":8080,"method":"chacha20-ietf-poly1305","password":"SECRET","prefix":"base64:FgMB"}`,
+		},
+		{
+			name:  "template with UDP prefix",
+			input: `{"host":"192.0.2.1","port":8080,"method":"aes-128-gcm","password":"SECRET","prefix":"template:GET /{rand:2-6}","udpPrefix":"hex:0000"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewClientFromJSON(tt.input)
+			if err != nil || got == nil {
+				t.Errorf("NewClientFromJSON() failed: %v", err)
+			}
+		})
+	}
+}
+
+func Test_DiagnoseConnectivity(t *testing.T) {
+	if _, err := DiagnoseConnectivity(`{"host":"192.0.2.1"}`, nil); err == nil {
+		t.Error("DiagnoseConnectivity() expects an error for an invalid configuration")
+	}
+
+	// Unresolvable hosts are reported, instead of failing.
+	checker := connectivity.NewChecker()
+	reportJSON, err := DiagnoseConnectivity(`{"host":"proxy.invalid","port":8388,"method":"chacha20-ietf-poly1305","password":"abcd1234"}`, checker)
+	if err != nil {
+		t.Fatalf("DiagnoseConnectivity() failed: %v", err)
+	}
+	var report connectivity.Report
+	if err := json.Unmarshal([]byte(reportJSON), &report); err != nil {
+		t.Fatalf("Invalid report %v: %v", reportJSON, err)
+	}
+	if result := report.Stage(connectivity.StageResolve); result == nil || result.ErrorClass != connectivity.ErrorClassDNS {
+		t.Errorf("Resolve stage = %+v, want a DNS error", result)
+	}
+	if result := report.Stage(connectivity.StageHandshake); result == nil || !result.Skipped {
+		t.Errorf("Handshake stage = %+v, want skipped", result)
+	}
+}
@@ -16,7 +16,13 @@ package shadowsocks
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
+
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/internal/prefix"
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/internal/ss2022"
+	"github.com/Jigsaw-Code/outline-sdk/transport/shadowsocks"
 )
 
 // Config represents a (legacy) shadowsocks server configuration. You can use
@@ -39,36 +45,274 @@ type configJSON struct {
 	Port     uint16 `json:"port"`
 	Password string `json:"password"`
 	Method   string `json:"method"`
-	Prefix   string `json:"prefix"`
+	// Optional prefix of the TCP salts, in the format of the prefix package.
+	Prefix string `json:"prefix"`
+	// Optional prefix of the UDP salts, in the same format as Prefix.
+	UDPPrefix string `json:"udpPrefix"`
+	// Optional prefixes to probe in order with ProbePrefix, in the same format as Prefix.
+	// The clients use the first one, since creating a client doesn't probe the network.
+	PrefixCandidates []string `json:"prefixCandidates"`
+	// Optional HTTP proxy to reach the Shadowsocks proxy through. UDP is not relayed.
+	// Shorthand for a ProxyChain with a single "http" hop.
+	HTTPProxy *proxyHopJSON `json:"httpProxy"`
+	// Optional proxies to reach the Shadowsocks proxy through, in order. Each hop is
+	// reached through the previous one.
+	ProxyChain []proxyHopJSON `json:"proxyChain"`
+	// Optional WebSocket transport, used to reach the proxy through a fronting server.
+	WebSocket *webSocketJSON `json:"websocket"`
+	// Whether the proxy supports UDP-over-TCP, used when the network blocks UDP.
+	UDPOverTCP bool `json:"udpOverTcp"`
+	// Optional multiplexing of the TCP streams over a pool of proxy connections.
+	Multiplex *multiplexJSON `json:"multiplex"`
+	// Optional pool of pre-established TCP connections to the proxy.
+	ConnectionPool *connectionPoolJSON `json:"connectionPool"`
+	// Optional SIP003 plugin name and its options, as "opt=val;...". Plugins are not
+	// supported yet, so the connections through the proxy fail.
+	Plugin        string `json:"plugin"`
+	PluginOptions string `json:"pluginOptions"`
+}
+
+// An internal data structure to be used by JSON deserialization of the
+// stream multiplexing options. Zero values select the defaults.
+type multiplexJSON struct {
+	// Maximum number of proxy connections.
+	MaxConnections int `json:"maxConnections"`
+	// Number of streams per proxy connection before another connection is opened.
+	MaxStreams int `json:"maxStreams"`
+}
+
+// Types of proxy hops.
+const (
+	proxyTypeHTTP   = "http"
+	proxyTypeSOCKS5 = "socks5"
+)
+
+// An internal data structure to be used by JSON deserialization of a proxy hop.
+type proxyHopJSON struct {
+	// Either "http" or "socks5". Only "http" is allowed, and the default, for httpProxy.
+	Type string `json:"type"`
+	Host string `json:"host"`
+	Port uint16 `json:"port"`
+	// Whether the connections to an HTTP proxy use TLS.
+	TLS bool `json:"tls"`
+	// TLS Server Name Indication. Defaults to Host.
+	SNI string `json:"sni"`
+	// Optional credentials.
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// An internal data structure to be used by JSON deserialization of the
+// WebSocket transport options. Connections are always secured with TLS.
+type webSocketJSON struct {
+	// Path of the WebSocket URL for TCP streams.
+	Path string `json:"path"`
+	// Path of the WebSocket URL for UDP packets. UDP is not supported if empty.
+	UDPPath string `json:"udpPath"`
+	// HTTP Host header. Defaults to the proxy host.
+	Host string `json:"host"`
+	// TLS Server Name Indication. Defaults to the host name of the Host header.
+	SNI string `json:"sni"`
+}
+
+// An internal data structure to be used by JSON deserialization of the
+// connection pool options. Zero values select the defaults.
+type connectionPoolJSON struct {
+	// Number of idle connections to keep.
+	Size int `json:"size"`
+	// Seconds after which an idle connection is closed.
+	MaxIdleSeconds int `json:"maxIdleSeconds"`
 }
 
 // ParseConfigFromJSON parses a JSON string `in` as a configJSON object.
 // The JSON string `in` must match the ShadowsocksSessionConfig interface
 // defined in Outline Client.
+//
+// If a field has the wrong type, returns a [*ConfigError] that also includes
+// the problems of the other fields.
 func parseConfigFromJSON(in string) (*configJSON, error) {
 	var conf configJSON
 	if err := json.Unmarshal([]byte(in), &conf); err != nil {
-		return nil, err
+		var typeErr *json.UnmarshalTypeError
+		if !errors.As(err, &typeErr) {
+			return nil, err
+		}
+		// The decoding continues after type errors, so the other fields can be validated.
+		errs := &ConfigError{}
+		if typeErr.Field == "port" {
+			errs.add("port", ReasonOutOfRange, "port must be within range [1..65535]")
+		} else {
+			errs.add(typeErr.Field, ReasonMalformed, "unexpected JSON %v", typeErr.Value)
+		}
+		validateConfig(&conf, errs)
+		return nil, errs
 	}
 	return &conf, nil
 }
 
 // validateConfig validates whether a Shadowsocks server configuration is valid
-// (it won't do any connectivity tests)
-//
-// Returns nil if it is valid; or an error message.
-func validateConfig(host string, port int, cipher, password string) error {
-	if len(host) == 0 {
-		return fmt.Errorf("must provide a host name or IP address")
+// (it won't do any connectivity tests), adding the problems found to `errs`.
+func validateConfig(config *configJSON, errs *ConfigError) {
+	if len(config.Host) == 0 {
+		errs.add("host", ReasonMissing, "must provide a host name or IP address")
+	}
+	if config.Port == 0 {
+		errs.add("port", ReasonOutOfRange, "port must be within range [1..65535]")
+	}
+	if len(config.Method) == 0 {
+		errs.add("method", ReasonMissing, "must provide an encryption cipher method")
+	}
+	if len(config.Password) == 0 {
+		errs.add("password", ReasonMissing, "must provide a password")
+	}
+	saltSize := validateCipher(config.Method, config.Password, errs)
+
+	tcpPrefix, err := prefix.Parse(config.Prefix)
+	if err != nil {
+		errs.add("prefix", ReasonMalformed, "%v", err)
+	} else if tcpPrefix != nil && saltSize > 0 {
+		if err := tcpPrefix.Validate(saltSize); err != nil {
+			errs.add("prefix", ReasonOutOfRange, "%v", err)
+		}
+	}
+	udpPrefix, err := prefix.Parse(config.UDPPrefix)
+	if err != nil {
+		errs.add("udpPrefix", ReasonMalformed, "%v", err)
+	} else if udpPrefix != nil && ss2022.IsSupportedCipher(config.Method) {
+		// The packets start with an encrypted header instead of a salt.
+		errs.add("udpPrefix", ReasonUnsupported, "UDP prefixes are not supported by %v", config.Method)
+	} else if udpPrefix != nil && saltSize > 0 {
+		if err := udpPrefix.Validate(saltSize); err != nil {
+			errs.add("udpPrefix", ReasonOutOfRange, "%v", err)
+		}
+	}
+	if len(config.PrefixCandidates) > 0 && len(config.Prefix) > 0 {
+		errs.add("prefixCandidates", ReasonConflict, "prefix and prefixCandidates are mutually exclusive")
 	}
-	if port <= 0 || port > 65535 {
-		return fmt.Errorf("port must be within range [1..65535]")
+	for i, candidate := range config.PrefixCandidates {
+		field := fmt.Sprintf("prefixCandidates[%d]", i)
+		candidatePrefix, err := prefix.Parse(candidate)
+		if err != nil {
+			errs.add(field, ReasonMalformed, "%v", err)
+		} else if candidatePrefix != nil && saltSize > 0 {
+			if err := candidatePrefix.Validate(saltSize); err != nil {
+				errs.add(field, ReasonOutOfRange, "%v", err)
+			}
+		}
+	}
+
+	if config.HTTPProxy != nil {
+		if config.HTTPProxy.Type != "" && config.HTTPProxy.Type != proxyTypeHTTP {
+			errs.add("httpProxy.type", ReasonUnsupported, "httpProxy must be of type %q", proxyTypeHTTP)
+		}
+		validateProxyHop("httpProxy", proxyTypeHTTP, config.HTTPProxy, errs)
+		if len(config.ProxyChain) > 0 {
+			errs.add("proxyChain", ReasonConflict, "httpProxy and proxyChain are mutually exclusive")
+		}
+	}
+	for i := range config.ProxyChain {
+		hop := &config.ProxyChain[i]
+		field := fmt.Sprintf("proxyChain[%d]", i)
+		if hop.Type != proxyTypeHTTP && hop.Type != proxyTypeSOCKS5 {
+			errs.add(field+".type", ReasonUnsupported, "proxy type must be %q or %q", proxyTypeHTTP, proxyTypeSOCKS5)
+			continue
+		}
+		validateProxyHop(field, hop.Type, hop, errs)
+	}
+	if ws := config.WebSocket; ws != nil {
+		if !strings.HasPrefix(ws.Path, "/") {
+			errs.add("websocket.path", ReasonMalformed, "WebSocket path must start with \"/\"")
+		}
+		if ws.UDPPath != "" && !strings.HasPrefix(ws.UDPPath, "/") {
+			errs.add("websocket.udpPath", ReasonMalformed, "WebSocket UDP path must start with \"/\"")
+		}
+	}
+	if mux := config.Multiplex; mux != nil {
+		if mux.MaxConnections < 0 {
+			errs.add("multiplex.maxConnections", ReasonOutOfRange, "must not be negative")
+		}
+		if mux.MaxStreams < 0 {
+			errs.add("multiplex.maxStreams", ReasonOutOfRange, "must not be negative")
+		}
+	}
+	if pool := config.ConnectionPool; pool != nil {
+		if pool.Size < 0 {
+			errs.add("connectionPool.size", ReasonOutOfRange, "must not be negative")
+		}
+		if pool.MaxIdleSeconds < 0 {
+			errs.add("connectionPool.maxIdleSeconds", ReasonOutOfRange, "must not be negative")
+		}
+	}
+}
+
+// validateProxyHop checks the options of a proxy hop of type `proxyType`, reported as `field`.
+func validateProxyHop(field, proxyType string, hop *proxyHopJSON, errs *ConfigError) {
+	if len(hop.Host) == 0 {
+		errs.add(field+".host", ReasonMissing, "must provide a host name or IP address")
+	}
+	if hop.Port == 0 {
+		errs.add(field+".port", ReasonOutOfRange, "port must be within range [1..65535]")
+	}
+	if len(hop.Username) == 0 && len(hop.Password) > 0 {
+		errs.add(field+".username", ReasonMissing, "must provide a username with the password")
+	}
+	switch proxyType {
+	case proxyTypeHTTP:
+		if len(hop.SNI) > 0 && !hop.TLS {
+			errs.add(field+".sni", ReasonConflict, "sni requires tls")
+		}
+	case proxyTypeSOCKS5:
+		if hop.TLS || len(hop.SNI) > 0 {
+			errs.add(field+".tls", ReasonUnsupported, "TLS is not supported for SOCKS5 proxies")
+		}
+		if len(hop.Username) > 0 && len(hop.Password) == 0 {
+			errs.add(field+".password", ReasonMissing, "must provide a password with the username")
+		}
+		if len(hop.Username) > 255 {
+			errs.add(field+".username", ReasonOutOfRange, "username must be at most 255 bytes long")
+		}
+		if len(hop.Password) > 255 {
+			errs.add(field+".password", ReasonOutOfRange, "password must be at most 255 bytes long")
+		}
+	}
+}
+
+// proxyHops returns the proxy hops to reach the Shadowsocks proxy through, in order.
+func (c *configJSON) proxyHops() []proxyHopJSON {
+	if c.HTTPProxy != nil {
+		hop := *c.HTTPProxy
+		hop.Type = proxyTypeHTTP
+		return []proxyHopJSON{hop}
+	}
+	return c.ProxyChain
+}
+
+// tcpPrefix returns the prefix of the TCP salts: Prefix, or the first of PrefixCandidates.
+func (c *configJSON) tcpPrefix() string {
+	if len(c.PrefixCandidates) > 0 {
+		return c.PrefixCandidates[0]
+	}
+	return c.Prefix
+}
+
+// validateCipher checks that `method` is supported and that `password` is valid for it.
+// Returns the salt size of the cipher, or 0 if it can't be determined.
+func validateCipher(method, password string, errs *ConfigError) int {
+	if len(method) == 0 || len(password) == 0 {
+		return 0
 	}
-	if len(cipher) == 0 {
-		return fmt.Errorf("must provide an encryption cipher method")
+	if ss2022.IsSupportedCipher(method) {
+		key, err := ss2022.NewKey(method, password)
+		if err != nil {
+			errs.add("password", ReasonMalformed, "%v", err)
+			return 0
+		}
+		return key.SaltSize()
 	}
-	if len(password) == 0 {
-		return fmt.Errorf("must provide a password")
+	key, err := shadowsocks.NewEncryptionKey(method, password)
+	if err != nil {
+		errs.add("method", ReasonUnsupported, "%v", err)
+		return 0
 	}
-	return nil
+	return key.SaltSize()
 }
@@ -0,0 +1,151 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shadowsocks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/internal/happyeyeballs"
+)
+
+// Reasons of a FieldError exported through gomobile
+const (
+	ReasonMissing      = 1 // A required field is empty
+	ReasonOutOfRange   = 2 // A number or a length is out of range
+	ReasonUnsupported  = 3 // The value is valid but not supported, like an unknown cipher
+	ReasonMalformed    = 4 // The value can't be parsed, like a bad prefix
+	ReasonConflict     = 5 // The field can't be combined with another field
+	ReasonUnresolvable = 6 // The host name doesn't resolve
+)
+
+// FieldError describes a problem with a field of a Shadowsocks configuration.
+type FieldError struct {
+	// JSON path of the field, like "port" or "websocket.path". Empty if the problem
+	// is not about a single field, like a JSON syntax error.
+	Field string
+	// One of the Reason constants.
+	Reason int
+	// Human-readable description of the problem.
+	Message string
+}
+
+func (e *FieldError) Error() string {
+	if e.Field == "" {
+		return e.Message
+	}
+	return e.Field + ": " + e.Message
+}
+
+// ConfigError lists all the problems found in a Shadowsocks configuration.
+// Since gomobile doesn't support slices of objects, use Len and Get to iterate
+// over the problems.
+type ConfigError struct {
+	fieldErrors []*FieldError
+}
+
+func (e *ConfigError) Error() string {
+	messages := make([]string, len(e.fieldErrors))
+	for i, fieldErr := range e.fieldErrors {
+		messages[i] = fieldErr.Error()
+	}
+	return "invalid Shadowsocks configuration: " + strings.Join(messages, "; ")
+}
+
+// Len returns the number of problems.
+func (e *ConfigError) Len() int {
+	return len(e.fieldErrors)
+}
+
+// Get returns the problem at index `i`, or nil if `i` is out of range.
+func (e *ConfigError) Get(i int) *FieldError {
+	if i < 0 || i >= len(e.fieldErrors) {
+		return nil
+	}
+	return e.fieldErrors[i]
+}
+
+// Field returns the problem of `field`, or nil if there is none.
+func (e *ConfigError) Field(field string) *FieldError {
+	for _, fieldErr := range e.fieldErrors {
+		if fieldErr.Field == field {
+			return fieldErr
+		}
+	}
+	return nil
+}
+
+// add records a problem of `field`, unless the field already has one.
+func (e *ConfigError) add(field string, reason int, format string, args ...interface{}) {
+	if field != "" && e.Field(field) != nil {
+		return
+	}
+	e.fieldErrors = append(e.fieldErrors, &FieldError{Field: field, Reason: reason, Message: fmt.Sprintf(format, args...)})
+}
+
+// resolveTimeout bounds the resolution of the host by ValidateConfigJSON.
+const resolveTimeout = 10 * time.Second
+
+// ValidateConfigJSON checks a JSON formatted configuration, including whether its
+// host resolves. Returns all the problems found, or nil if the configuration is valid.
+//
+// The result is a concrete pointer, for gomobile. Go callers must check it for nil before
+// assigning it to an error, which would otherwise hold a nil pointer and be non-nil.
+// [CheckConfigJSON] returns an error instead.
+func ValidateConfigJSON(configJSON string) *ConfigError {
+	if err := CheckConfigJSON(configJSON); err != nil {
+		return err.(*ConfigError)
+	}
+	return nil
+}
+
+// CheckConfigJSON is like [ValidateConfigJSON], and returns the [*ConfigError] as an error, or
+// nil if the configuration is valid.
+func CheckConfigJSON(configJSON string) error {
+	config, err := parseConfigFromJSON(configJSON)
+	if err != nil {
+		var configErr *ConfigError
+		if errors.As(err, &configErr) {
+			return configErr
+		}
+		configErr = &ConfigError{}
+		configErr.add("", ReasonMalformed, "failed to parse Shadowsocks configuration JSON: %v", err)
+		return configErr
+	}
+	errs := &ConfigError{}
+	validateConfig(config, errs)
+	// With proxy hops, only the first hop host must be resolvable locally.
+	hostField, host, port := "host", config.Host, int(config.Port)
+	if config.HTTPProxy != nil {
+		hostField, host, port = "httpProxy.host", config.HTTPProxy.Host, int(config.HTTPProxy.Port)
+	} else if len(config.ProxyChain) > 0 {
+		hostField, host, port = "proxyChain[0].host", config.ProxyChain[0].Host, int(config.ProxyChain[0].Port)
+	}
+	if errs.Field(hostField) == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+		defer cancel()
+		endpoint := happyeyeballs.NewEndpoint(host, port, happyeyeballs.DefaultLookup)
+		if err := endpoint.Resolve(ctx); err != nil {
+			errs.add(hostField, ReasonUnresolvable, "%v", err)
+		}
+	}
+	if errs.Len() == 0 {
+		return nil
+	}
+	return errs
+}
@@ -0,0 +1,155 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shadowsocks
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// fieldReasons returns the reason of each field error in `err`.
+func fieldReasons(err *ConfigError) map[string]int {
+	reasons := make(map[string]int)
+	for i := 0; i < err.Len(); i++ {
+		reasons[err.Get(i).Field] = err.Get(i).Reason
+	}
+	return reasons
+}
+
+func Test_NewClientFromJSON_ConfigError(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  map[string]int
+	}{
+		{
+			name:  "all missing",
+			input: `{}`,
+			want:  map[string]int{"host": ReasonMissing, "port": ReasonOutOfRange, "method": ReasonMissing, "password": ReasonMissing},
+		},
+		{
+			name:  "port with wrong type",
+			input: `{"port":65536,"method":"chacha20-ietf-poly1305"}`,
+			want:  map[string]int{"host": ReasonMissing, "port": ReasonOutOfRange, "password": ReasonMissing},
+		},
+		{
+			name:  "unknown cipher and bad prefix",
+			input: `{"host":"192.0.2.1","port":8080,"method":"rot13","password":"SECRET","prefix":"hex:xyz"}`,
+			want:  map[string]int{"method": ReasonUnsupported, "prefix": ReasonMalformed},
+		},
+		{
+			name:  "bad 2022 PSK and UDP prefix",
+			input: `{"host":"192.0.2.1","port":8080,"method":"2022-blake3-aes-128-gcm","password":"SECRET","udpPrefix":"hex:00"}`,
+			want:  map[string]int{"password": ReasonMalformed, "udpPrefix": ReasonUnsupported},
+		},
+		{
+			name:  "prefix too long",
+			input: `{"host":"192.0.2.1","port":8080,"method":"aes-128-gcm","password":"SECRET","prefix":"template:{rand:17}"}`,
+			want:  map[string]int{"prefix": ReasonOutOfRange},
+		},
+		{
+			name:  "conflicting prefixes",
+			input: `{"host":"192.0.2.1","port":8080,"method":"aes-128-gcm","password":"SECRET","prefix":"POST ","prefixCandidates":["GET ","ሴ"]}`,
+			want:  map[string]int{"prefixCandidates": ReasonConflict, "prefixCandidates[1]": ReasonMalformed},
+		},
+		{
+			name:  "prefix candidate too long",
+			input: `{"host":"192.0.2.1","port":8080,"method":"aes-128-gcm","password":"SECRET","prefixCandidates":["GET ","template:{rand:17}"]}`,
+			want:  map[string]int{"prefixCandidates[1]": ReasonOutOfRange},
+		},
+		{
+			name:  "transport options",
+			input: `{"host":"192.0.2.1","port":8080,"method":"aes-128-gcm","password":"SECRET","websocket":{"path":"tcp"},"multiplex":{"maxStreams":-1},"connectionPool":{"maxIdleSeconds":-1}}`,
+			want:  map[string]int{"websocket.path": ReasonMalformed, "multiplex.maxStreams": ReasonOutOfRange, "connectionPool.maxIdleSeconds": ReasonOutOfRange},
+		},
+		{
+			name:  "HTTP proxy options",
+			input: `{"host":"192.0.2.1","port":8080,"method":"aes-128-gcm","password":"SECRET","httpProxy":{"port":0,"password":"secret","sni":"proxy.example"}}`,
+			want:  map[string]int{"httpProxy.host": ReasonMissing, "httpProxy.port": ReasonOutOfRange, "httpProxy.username": ReasonMissing, "httpProxy.sni": ReasonConflict},
+		},
+		{
+			name: "proxy chain options",
+			input: `{"host":"192.0.2.1","port":8080,"method":"aes-128-gcm","password":"SECRET",` +
+				`"proxyChain":[{"type":"socks5","host":"192.0.2.2","port":1080,"tls":true,"username":"user"},{"type":"ftp","host":"192.0.2.3","port":21}]}`,
+			want: map[string]int{"proxyChain[0].tls": ReasonUnsupported, "proxyChain[0].password": ReasonMissing, "proxyChain[1].type": ReasonUnsupported},
+		},
+		{
+			name:  "conflicting proxies",
+			input: `{"host":"192.0.2.1","port":8080,"method":"aes-128-gcm","password":"SECRET","httpProxy":{"type":"socks5","host":"192.0.2.2","port":1080},"proxyChain":[{"type":"http","host":"192.0.2.3","port":3128}]}`,
+			want:  map[string]int{"httpProxy.type": ReasonUnsupported, "proxyChain": ReasonConflict},
+		},
+		{
+			name:  "nested field with wrong type",
+			input: `{"host":"192.0.2.1","port":8080,"method":"aes-128-gcm","password":"SECRET","websocket":{"path":1}}`,
+			want:  map[string]int{"websocket.path": ReasonMalformed},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewClientFromJSON(tt.input)
+			var configErr *ConfigError
+			if !errors.As(err, &configErr) {
+				t.Fatalf("NewClientFromJSON() error = %v, want a ConfigError", err)
+			}
+			if got := fieldReasons(configErr); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NewClientFromJSON() error reasons = %v, want %v (error: %v)", got, tt.want, err)
+			}
+		})
+	}
+}
+
+func Test_NewClient_ConfigError(t *testing.T) {
+	_, err := NewClient(&Config{Host: "192.0.2.1", Port: 70000, CipherName: "chacha20-ietf-poly1305", Password: "SECRET"})
+	var configErr *ConfigError
+	if !errors.As(err, &configErr) || configErr.Field("port") == nil {
+		t.Errorf("NewClient() error = %v, want a port error", err)
+	}
+}
+
+func Test_ValidateConfigJSON(t *testing.T) {
+	if err := ValidateConfigJSON(`{"host":"192.0.2.1","port":8080,"method":"chacha20-ietf-poly1305","password":"SECRET"}`); err != nil {
+		t.Errorf("ValidateConfigJSON() = %v, want nil", err)
+	}
+
+	err := ValidateConfigJSON(`{"host":"proxy.invalid","port":8080,"method":"chacha20-ietf-poly1305"}`)
+	if want := map[string]int{"host": ReasonUnresolvable, "password": ReasonMissing}; err == nil || !reflect.DeepEqual(fieldReasons(err), want) {
+		t.Errorf("ValidateConfigJSON() = %v, want reasons %v", err, want)
+	}
+
+	// The Shadowsocks host is resolved by the HTTP proxy.
+	err = ValidateConfigJSON(`{"host":"ss.invalid","port":8080,"method":"chacha20-ietf-poly1305","password":"SECRET","httpProxy":{"host":"proxy.invalid","port":3128}}`)
+	if want := map[string]int{"httpProxy.host": ReasonUnresolvable}; err == nil || !reflect.DeepEqual(fieldReasons(err), want) {
+		t.Errorf("ValidateConfigJSON() = %v, want reasons %v", err, want)
+	}
+
+	err = ValidateConfigJSON(`{"host":`)
+	if err == nil || err.Len() != 1 || err.Get(0).Field != "" || err.Get(0).Reason != ReasonMalformed {
+		t.Errorf("ValidateConfigJSON() = %v, want a JSON syntax error", err)
+	}
+	if err.Get(-1) != nil || err.Get(1) != nil {
+		t.Error("Get() expects nil out of range")
+	}
+}
+
+func Test_CheckConfigJSON(t *testing.T) {
+	if err := CheckConfigJSON(`{"host":"192.0.2.1","port":8080,"method":"chacha20-ietf-poly1305","password":"SECRET"}`); err != nil {
+		t.Errorf("CheckConfigJSON() = %#v, want nil", err)
+	}
+	var configErr *ConfigError
+	if err := CheckConfigJSON(`{"host":"192.0.2.1","port":8080}`); !errors.As(err, &configErr) || configErr.Len() != 2 {
+		t.Errorf("CheckConfigJSON() = %v, want a ConfigError", err)
+	}
+}
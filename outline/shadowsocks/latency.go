@@ -0,0 +1,59 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shadowsocks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline"
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/connectivity"
+)
+
+// LatencyTest measures the latency of several Shadowsocks clients, to rank them.
+type LatencyTest struct {
+	clients []*outline.Client
+}
+
+// NewLatencyTest creates a [LatencyTest] without clients.
+func NewLatencyTest() *LatencyTest {
+	return &LatencyTest{}
+}
+
+// AddClient adds `client` to the test. Its index in the results is the number of clients
+// added before it.
+func (t *LatencyTest) AddClient(client *Client) {
+	t.clients = append(t.clients, (*outline.Client)(client))
+}
+
+// Run measures the latency of the clients concurrently, with `samples` requests each, and the
+// options of `checker`, or the defaults if nil. Returns a JSON array of
+// [connectivity.LatencyResult], ranked from the fastest client to the slowest.
+func (t *LatencyTest) Run(checker *connectivity.Checker, samples int) (string, error) {
+	if len(t.clients) == 0 {
+		return "", errors.New("must add at least one client")
+	}
+	if checker == nil {
+		checker = connectivity.NewChecker()
+	}
+	results := checker.MeasureLatency(context.Background(), t.clients, samples)
+	resultsJSON, err := json.Marshal(results)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize the results: %w", err)
+	}
+	return string(resultsJSON), nil
+}
@@ -0,0 +1,48 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shadowsocks
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/connectivity"
+)
+
+func Test_LatencyTest(t *testing.T) {
+	if _, err := NewLatencyTest().Run(nil, 1); err == nil {
+		t.Error("Run() expects an error without clients")
+	}
+
+	// The test proxy refuses connections.
+	client, err := NewClientFromJSON(`{"host":"127.0.0.1","port":1,"method":"chacha20-ietf-poly1305","password":"SECRET"}`)
+	if err != nil {
+		t.Fatalf("NewClientFromJSON() failed: %v", err)
+	}
+	test := NewLatencyTest()
+	test.AddClient(client)
+	test.AddClient(client)
+	resultsJSON, err := test.Run(nil, 1)
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	var results []connectivity.LatencyResult
+	if err := json.Unmarshal([]byte(resultsJSON), &results); err != nil {
+		t.Fatalf("Invalid results %v: %v", resultsJSON, err)
+	}
+	if len(results) != 2 || results[0].Error == "" || results[0].ErrorCode != Unreachable {
+		t.Errorf("Got results %v, want 2 unreachable clients", resultsJSON)
+	}
+}
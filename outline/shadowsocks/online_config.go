@@ -0,0 +1,203 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shadowsocks
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Jigsaw-Code/outline-go-tun2socks/https"
+	"github.com/eycorsican/go-tun2socks/common/log"
+)
+
+// Maximum number of HTTP redirects to follow when fetching an online config.
+const maxOnlineConfigRedirects = 5
+
+// ClientList is a list of Shadowsocks clients that can be exported through gomobile.
+type ClientList struct {
+	clients []*Client
+}
+
+// Len returns the number of clients in the list.
+func (l *ClientList) Len() int {
+	return len(l.clients)
+}
+
+// Get returns the client at index `i`, or nil if `i` is out of range.
+func (l *ClientList) Get(i int) *Client {
+	if i < 0 || i >= len(l.clients) {
+		return nil
+	}
+	return l.clients[i]
+}
+
+// NewClientsFromOnlineConfig fetches the server list of a dynamic access key and
+// creates a Shadowsocks client for each server in it.
+//
+// `accessKey` is an ssconf:// or https:// URL pointing to either a SIP008 online
+// configuration (https://shadowsocks.org/doc/sip008.html), a single server JSON
+// object, or a SIP002 ss:// access key.
+// `trustedCertFingerprint` is the optional SHA-256 fingerprint of the server's
+// TLS certificate. When set, the certificate is pinned instead of being
+// validated against the system's trusted roots.
+//
+// Invalid servers are skipped, so that the others can still be used. Returns an error,
+// joining the errors of the servers, only if none of them is usable.
+func NewClientsFromOnlineConfig(accessKey string, trustedCertFingerprint []byte) (*ClientList, error) {
+	configs, serverErrs, err := fetchOnlineConfig(accessKey, trustedCertFingerprint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch online configuration: %w", err)
+	}
+	list := &ClientList{clients: make([]*Client, 0, len(configs))}
+	for i, config := range configs {
+		if config == nil {
+			continue
+		}
+		client, err := newClientFromConfig(config)
+		if err != nil {
+			serverErrs = append(serverErrs, fmt.Errorf("server %d: %w", i, err))
+			continue
+		}
+		list.clients = append(list.clients, client)
+	}
+	for _, serverErr := range serverErrs {
+		log.Warnf("Skipped invalid server in online configuration: %v", serverErr)
+	}
+	if len(list.clients) == 0 {
+		return nil, fmt.Errorf("no usable server in online configuration: %w", errors.Join(serverErrs...))
+	}
+	return list, nil
+}
+
+// fetchOnlineConfig downloads and parses the online configuration at `accessKey`.
+// The results are those of [parseOnlineConfig].
+func fetchOnlineConfig(accessKey string, trustedCertFingerprint []byte) ([]*configJSON, []error, error) {
+	u, err := url.Parse(strings.TrimSpace(accessKey))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid URL: %w", err)
+	}
+	switch u.Scheme {
+	case "ssconf":
+		u.Scheme = "https"
+	case "https":
+	default:
+		return nil, nil, fmt.Errorf("unsupported URL scheme %q, must be \"ssconf\" or \"https\"", u.Scheme)
+	}
+	// The fragment may carry a tag meant for the user, it must not be sent to the server.
+	u.Fragment = ""
+
+	req := https.Request{URL: u.String(), Method: http.MethodGet, TrustedCertFingerprint: trustedCertFingerprint}
+	for redirects := 0; ; redirects++ {
+		res, err := https.Fetch(req)
+		if err != nil {
+			return nil, nil, err
+		}
+		if res.RedirectURL != "" {
+			if redirects >= maxOnlineConfigRedirects {
+				return nil, nil, errors.New("too many redirects")
+			}
+			redirectURL, err := url.Parse(res.RedirectURL)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid redirect URL: %w", err)
+			}
+			u = u.ResolveReference(redirectURL)
+			req.URL = u.String()
+			continue
+		}
+		if res.HTTPStatusCode != http.StatusOK {
+			return nil, nil, fmt.Errorf("server returned HTTP status %d", res.HTTPStatusCode)
+		}
+		return parseOnlineConfig(res.Data)
+	}
+}
+
+// An internal data structure to be used by JSON deserialization of SIP008
+// server entries and of single-server dynamic access key responses.
+type onlineServerJSON struct {
+	Server     string `json:"server"`
+	ServerPort uint16 `json:"server_port"`
+	Password   string `json:"password"`
+	Method     string `json:"method"`
+	Prefix     string `json:"prefix"`
+	Plugin     string `json:"plugin"`
+	PluginOpts string `json:"plugin_opts"`
+}
+
+// An internal data structure to be used by JSON deserialization of SIP008 documents.
+type onlineConfigJSON struct {
+	Version int               `json:"version"`
+	Servers []json.RawMessage `json:"servers"`
+}
+
+// parseOnlineConfig parses the body of an online configuration response, which
+// can be a SIP008 document, a single server JSON object or a SIP002 access key.
+// Returns the configuration of each server, which is nil if the server is invalid, the
+// errors of the invalid servers, and an error if the document itself is invalid.
+func parseOnlineConfig(data []byte) ([]*configJSON, []error, error) {
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("ss://")) {
+		config, err := parseConfigFromURL(string(data))
+		if err != nil {
+			return nil, nil, err
+		}
+		return []*configJSON{config}, nil, nil
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	var servers []json.RawMessage
+	if _, ok := raw["servers"]; ok {
+		var doc onlineConfigJSON
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return nil, nil, fmt.Errorf("invalid SIP008 document: %w", err)
+		}
+		if doc.Version != 1 {
+			return nil, nil, fmt.Errorf("unsupported SIP008 version %d", doc.Version)
+		}
+		servers = doc.Servers
+	} else {
+		servers = []json.RawMessage{data}
+	}
+	if len(servers) == 0 {
+		return nil, nil, errors.New("online configuration has no servers")
+	}
+
+	configs := make([]*configJSON, len(servers))
+	var serverErrs []error
+	for i, serverData := range servers {
+		var server onlineServerJSON
+		if err := json.Unmarshal(serverData, &server); err != nil {
+			serverErrs = append(serverErrs, fmt.Errorf("server %d: invalid server JSON: %w", i, err))
+			continue
+		}
+		configs[i] = &configJSON{
+			Host:          server.Server,
+			Port:          server.ServerPort,
+			Password:      server.Password,
+			Method:        server.Method,
+			Prefix:        server.Prefix,
+			Plugin:        server.Plugin,
+			PluginOptions: server.PluginOpts,
+		}
+	}
+	return configs, serverErrs, nil
+}
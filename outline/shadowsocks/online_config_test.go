@@ -0,0 +1,211 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shadowsocks
+
+import (
+	"crypto/sha256"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const sip008Document = `{
+  "version": 1,
+  "servers": [
+    {
+      "id": "27b8a625-4f4b-4428-9f0f-8a2317db7c79",
+      "remarks": "Name of the server",
+      "server": "192.0.2.1",
+      "server_port": 8388,
+      "password": "example",
+      "method": "chacha20-ietf-poly1305"
+    },
+    {
+      "server": "2001:db8::1",
+      "server_port": 443,
+      "password": "example2",
+      "method": "aes-256-gcm",
+      "prefix": "\u0016\u0003\u0001"
+    }
+  ],
+  "bytes_used": 274877906944
+}`
+
+func Test_parseOnlineConfig(t *testing.T) {
+	tests := []struct {
+		name           string
+		input          string
+		want           []*configJSON
+		wantServerErrs int
+		wantErr        bool
+	}{
+		{
+			name:  "SIP008",
+			input: sip008Document,
+			want: []*configJSON{
+				{Host: "192.0.2.1", Port: 8388, Password: "example", Method: "chacha20-ietf-poly1305"},
+				{Host: "2001:db8::1", Port: 443, Password: "example2", Method: "aes-256-gcm", Prefix: "\u0016\u0003\u0001"},
+			},
+		},
+		{
+			name:  "single server",
+			input: `{"server":"192.0.2.1","server_port":8388,"password":"example","method":"chacha20-ietf-poly1305","prefix":"POST "}`,
+			want: []*configJSON{
+				{Host: "192.0.2.1", Port: 8388, Password: "example", Method: "chacha20-ietf-poly1305", Prefix: "POST "},
+			},
+		},
+		{
+			name:  "access key",
+			input: "ss://Y2hhY2hhMjAtaWV0Zi1wb2x5MTMwNTpTZWNyZXQ@192.0.2.1:8388#Example\n",
+			want: []*configJSON{
+				{Host: "192.0.2.1", Port: 8388, Password: "Secret", Method: "chacha20-ietf-poly1305"},
+			},
+		},
+		{
+			name:    "not JSON",
+			input:   "<html></html>",
+			wantErr: true,
+		},
+		{
+			name:    "unsupported version",
+			input:   `{"version":2,"servers":[{"server":"192.0.2.1","server_port":8388,"password":"example","method":"aes-256-gcm"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "no servers",
+			input:   `{"version":1,"servers":[]}`,
+			wantErr: true,
+		},
+		{
+			name:  "plugin",
+			input: `{"version":1,"servers":[{"server":"192.0.2.1","server_port":8388,"password":"example","method":"aes-256-gcm","plugin":"v2ray-plugin","plugin_opts":"tls;host=example.com"}]}`,
+			want: []*configJSON{
+				{Host: "192.0.2.1", Port: 8388, Password: "example", Method: "aes-256-gcm", Plugin: "v2ray-plugin", PluginOptions: "tls;host=example.com"},
+			},
+		},
+		{
+			name:  "invalid server",
+			input: `{"version":1,"servers":[{"server":"192.0.2.1","server_port":"8388"},{"server":"192.0.2.2","server_port":8388,"password":"example","method":"aes-256-gcm"}]}`,
+			want: []*configJSON{
+				nil,
+				{Host: "192.0.2.2", Port: 8388, Password: "example", Method: "aes-256-gcm"},
+			},
+			wantServerErrs: 1,
+		},
+		{
+			name:           "port out of range",
+			input:          `{"server":"192.0.2.1","server_port":65536,"password":"example","method":"aes-256-gcm"}`,
+			want:           []*configJSON{nil},
+			wantServerErrs: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, serverErrs, err := parseOnlineConfig([]byte(tt.input))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseOnlineConfig() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if len(serverErrs) != tt.wantServerErrs {
+				t.Errorf("parseOnlineConfig() server errors = %v, want %d", serverErrs, tt.wantServerErrs)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseOnlineConfig() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewClientsFromOnlineConfig(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/sip008":
+			w.Write([]byte(sip008Document))
+		case "/moved":
+			http.Redirect(w, req, "/sip008", http.StatusMovedPermanently)
+		case "/invalid":
+			w.Write([]byte(`{"server":"192.0.2.1","server_port":8388,"password":"example","method":"some-cipher"}`))
+		case "/partially-invalid":
+			w.Write([]byte(`{"version":1,"servers":[
+				{"server":"192.0.2.1","server_port":8388,"password":"example","method":"some-cipher"},
+				{"server":"192.0.2.2","server_port":"8388"},
+				{"server":"192.0.2.3","server_port":8388,"password":"example","method":"aes-256-gcm"}]}`))
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer server.Close()
+	fingerprint := sha256.Sum256(server.Certificate().Raw)
+	ssconfURL := strings.Replace(server.URL, "https://", "ssconf://", 1)
+
+	t.Run("Success", func(t *testing.T) {
+		list, err := NewClientsFromOnlineConfig(ssconfURL+"/sip008#Tag", fingerprint[:])
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if list.Len() != 2 {
+			t.Fatalf("Expected 2 clients, got %d", list.Len())
+		}
+		if list.Get(0) == nil || list.Get(1) == nil || list.Get(2) != nil {
+			t.Errorf("Unexpected client list contents")
+		}
+	})
+
+	t.Run("Redirect", func(t *testing.T) {
+		list, err := NewClientsFromOnlineConfig(server.URL+"/moved", fingerprint[:])
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if list.Len() != 2 {
+			t.Errorf("Expected 2 clients, got %d", list.Len())
+		}
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		if _, err := NewClientsFromOnlineConfig(ssconfURL+"/missing", fingerprint[:]); err == nil {
+			t.Errorf("Expected error for missing online configuration")
+		}
+	})
+
+	t.Run("InvalidServer", func(t *testing.T) {
+		if _, err := NewClientsFromOnlineConfig(ssconfURL+"/invalid", fingerprint[:]); err == nil || !strings.Contains(err.Error(), "some-cipher") {
+			t.Errorf("Expected the error of the invalid server, got %v", err)
+		}
+	})
+
+	t.Run("PartiallyInvalid", func(t *testing.T) {
+		list, err := NewClientsFromOnlineConfig(ssconfURL+"/partially-invalid", fingerprint[:])
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if list.Len() != 1 {
+			t.Errorf("Expected 1 client, got %d", list.Len())
+		}
+	})
+
+	t.Run("WrongCertificateFingerprint", func(t *testing.T) {
+		if _, err := NewClientsFromOnlineConfig(ssconfURL+"/sip008", []byte{0, 1, 2, 3}); err == nil {
+			t.Errorf("Expected TLS certificate validation error")
+		}
+	})
+
+	t.Run("UnsupportedScheme", func(t *testing.T) {
+		if _, err := NewClientsFromOnlineConfig("http://192.0.2.1/sip008", nil); err == nil {
+			t.Errorf("Expected error for unsupported scheme")
+		}
+	})
+}
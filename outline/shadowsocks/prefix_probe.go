@@ -0,0 +1,75 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shadowsocks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/connectivity"
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+	"github.com/eycorsican/go-tun2socks/common/log"
+)
+
+// checkPrefix determines whether the proxy can relay TCP traffic through `dialer`, with the
+// TCP targets and timeouts of `checker`. Replaced in tests.
+var checkPrefix = func(checker *connectivity.Checker, dialer transport.StreamDialer) error {
+	return checker.CheckTCPConnectivity(context.Background(), dialer)
+}
+
+// ProbePrefix tries the `prefixCandidates` of a JSON formatted configuration in order,
+// and returns the first one that can reach the proxy under the current network, with the
+// TCP checks of `checker`, or the defaults if nil. The probe can be stopped with
+// [connectivity.Checker.Cancel]. The result can be used as the `prefix` of the configuration.
+// Invalid configurations return a [*ConfigError], like [NewClientFromJSON].
+func ProbePrefix(configJSON string, checker *connectivity.Checker) (string, error) {
+	config, err := parseConfigFromJSON(configJSON)
+	if err != nil {
+		var configErr *ConfigError
+		if errors.As(err, &configErr) {
+			return "", configErr
+		}
+		return "", fmt.Errorf("failed to parse Shadowsocks configuration JSON: %w", err)
+	}
+	if len(config.PrefixCandidates) == 0 {
+		return "", fmt.Errorf("must provide prefixCandidates")
+	}
+	if checker == nil {
+		checker = connectivity.NewChecker()
+	}
+	return probePrefix(config, checker)
+}
+
+// probePrefix returns the first of `config.PrefixCandidates` that passes [checkPrefix].
+func probePrefix(config *configJSON, checker *connectivity.Checker) (string, error) {
+	var lastErr error
+	for _, candidate := range config.PrefixCandidates {
+		probeConfig := *config
+		probeConfig.Prefix, probeConfig.PrefixCandidates = candidate, nil
+		// Test new proxy connections, which are the ones that carry the prefix.
+		probeConfig.Multiplex, probeConfig.ConnectionPool = nil, nil
+		client, err := newClientFromConfig(&probeConfig)
+		if err != nil {
+			return "", err
+		}
+		if lastErr = checkPrefix(checker, client.StreamDialer); lastErr == nil {
+			log.Infof("Selected Shadowsocks prefix %q", candidate)
+			return candidate, nil
+		}
+		log.Debugf("Shadowsocks prefix %q failed: %v", candidate, lastErr)
+	}
+	return "", fmt.Errorf("none of the %d prefix candidates can reach the proxy: %w", len(config.PrefixCandidates), lastErr)
+}
@@ -0,0 +1,134 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shadowsocks
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/connectivity"
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+)
+
+// fakePrefixCheck replaces checkPrefix with a check that passes on attempt `okAttempt`,
+// starting at 0, and returns a pointer to the number of attempts.
+func fakePrefixCheck(t *testing.T, okAttempt int) *int {
+	attempts := 0
+	saved := checkPrefix
+	checkPrefix = func(checker *connectivity.Checker, dialer transport.StreamDialer) error {
+		if checker == nil {
+			t.Error("checkPrefix() called without a checker")
+		}
+		attempts++
+		if attempts-1 == okAttempt {
+			return nil
+		}
+		return errors.New("blocked")
+	}
+	t.Cleanup(func() { checkPrefix = saved })
+	return &attempts
+}
+
+func Test_ProbePrefix(t *testing.T) {
+	tests := []struct {
+		name         string
+		okAttempt    int
+		want         string
+		wantAttempts int
+		wantErr      bool
+	}{
+		{name: "first", okAttempt: 0, want: "POST ", wantAttempts: 1},
+		{name: "second", okAttempt: 1, want: "\u0016\u0003\u0001", wantAttempts: 2},
+		{name: "no prefix", okAttempt: 2, want: "", wantAttempts: 3},
+		{name: "none", okAttempt: -1, wantAttempts: 3, wantErr: true},
+	}
+	config := `{"host":"192.0.2.1","port":8080,"method":"chacha20-ietf-poly1305","password":"SECRET","prefixCandidates":["POST ","\u0016\u0003\u0001",""]}`
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := fakePrefixCheck(t, tt.okAttempt)
+			got, err := ProbePrefix(config, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ProbePrefix() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want || *attempts != tt.wantAttempts {
+				t.Errorf("ProbePrefix() = %q after %d attempts, want %q after %d", got, *attempts, tt.want, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func Test_ProbePrefix_Checker(t *testing.T) {
+	checker := connectivity.NewChecker()
+	var got *connectivity.Checker
+	saved := checkPrefix
+	checkPrefix = func(c *connectivity.Checker, dialer transport.StreamDialer) error {
+		got = c
+		return nil
+	}
+	t.Cleanup(func() { checkPrefix = saved })
+	if _, err := ProbePrefix(`{"host":"192.0.2.1","port":8080,"method":"chacha20-ietf-poly1305","password":"SECRET","prefixCandidates":["POST "]}`, checker); err != nil {
+		t.Fatalf("ProbePrefix() failed: %v", err)
+	}
+	if got != checker {
+		t.Error("ProbePrefix() didn't probe with the given checker")
+	}
+}
+
+func Test_ProbePrefix_Errors(t *testing.T) {
+	fakePrefixCheck(t, 0)
+	tests := []struct {
+		name          string
+		input         string
+		wantConfigErr bool
+	}{
+		{
+			name:  "no candidates",
+			input: `{"host":"192.0.2.1","port":8080,"method":"chacha20-ietf-poly1305","password":"SECRET"}`,
+		},
+		{
+			name:          "invalid candidate",
+			input:         `{"host":"192.0.2.1","port":8080,"method":"chacha20-ietf-poly1305","password":"SECRET","prefixCandidates":["ሴ"]}`,
+			wantConfigErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ProbePrefix(tt.input, nil)
+			if err == nil {
+				t.Fatalf("ProbePrefix() expects an error, got = %q", got)
+			}
+			// The configuration errors are returned as is, for the gomobile callers.
+			if _, ok := err.(*ConfigError); ok != tt.wantConfigErr {
+				t.Errorf("ProbePrefix() error = %#v, want a ConfigError: %v", err, tt.wantConfigErr)
+			}
+		})
+	}
+}
+
+func Test_NewClientFromJSON_PrefixCandidates(t *testing.T) {
+	attempts := fakePrefixCheck(t, 1)
+	got, err := NewClientFromJSON(`{"host":"192.0.2.1","port":8080,"method":"chacha20-ietf-poly1305","password":"SECRET","prefixCandidates":["POST ","HTTP/1.1 "],"multiplex":{}}`)
+	if err != nil || got == nil {
+		t.Fatalf("NewClientFromJSON() failed: %v", err)
+	}
+	// Creating a client doesn't probe the candidates.
+	if *attempts != 0 {
+		t.Errorf("Got %d probe attempts, want 0", *attempts)
+	}
+
+	if got, err := NewClientFromJSON(`{"host":"192.0.2.1","port":8080,"method":"chacha20-ietf-poly1305","password":"SECRET","prefix":"POST ","prefixCandidates":["POST "]}`); err == nil {
+		t.Errorf("NewClientFromJSON() expects an error with both prefix and prefixCandidates, got = %v", got)
+	}
+}
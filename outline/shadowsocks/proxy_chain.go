@@ -0,0 +1,136 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shadowsocks
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/internal/happyeyeballs"
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/internal/httpconnect"
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/internal/socks5"
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+)
+
+// newProxyChainEndpoints returns the endpoints that reach the Shadowsocks proxy at `host:port`
+// through `hops`, and the endpoint of the first hop, which must be notified of network
+// changes. Each hop is reached through the previous one, and resolves the next host.
+// UDP is relayed only if all the hops are SOCKS5 proxies.
+func newProxyChainEndpoints(host string, port int, hops []proxyHopJSON) (transport.StreamEndpoint, transport.PacketEndpoint, *happyeyeballs.Endpoint, error) {
+	firstEndpoint := happyeyeballs.NewEndpoint(hops[0].Host, int(hops[0].Port), happyeyeballs.DefaultLookup)
+	var hopEndpoint transport.StreamEndpoint = &happyeyeballs.StreamEndpoint{Endpoint: firstEndpoint}
+	var streamDialer transport.StreamDialer
+	// Reaches the UDP relay of the next hop, or nil for the bare network.
+	var packetDialer transport.PacketDialer
+	udpSupported := true
+	for i, hop := range hops {
+		if i > 0 {
+			hopEndpoint = &transport.StreamDialerEndpoint{Dialer: streamDialer, Address: net.JoinHostPort(hop.Host, strconv.Itoa(int(hop.Port)))}
+		}
+		switch hop.Type {
+		case proxyTypeHTTP:
+			dialer, err := newHTTPProxyDialer(hopEndpoint, &hop)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("invalid proxy hop %d: %w", i, err)
+			}
+			streamDialer, packetDialer, udpSupported = dialer, nil, false
+		case proxyTypeSOCKS5:
+			var credentials *socks5.Credentials
+			if len(hop.Username) > 0 {
+				credentials = &socks5.Credentials{Username: hop.Username, Password: hop.Password}
+			}
+			dialer, err := socks5.NewDialer(hopEndpoint, credentials)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("invalid proxy hop %d: %w", i, err)
+			}
+			if udpSupported && packetDialer != nil {
+				dialer = dialer.WithPacketDialer(packetDialer, hop.Host)
+			}
+			streamDialer = dialer
+			if udpSupported {
+				packetDialer = &packetListenerDialer{listener: dialer}
+			}
+		default:
+			return nil, nil, nil, fmt.Errorf("unsupported type of proxy hop %d: %v", i, hop.Type)
+		}
+	}
+
+	address := net.JoinHostPort(host, strconv.Itoa(port))
+	streamEndpoint := &transport.StreamDialerEndpoint{Dialer: streamDialer, Address: address}
+	if !udpSupported {
+		return streamEndpoint, unsupportedPacketEndpoint{"UDP can't be relayed through an HTTP proxy"}, firstEndpoint, nil
+	}
+	return streamEndpoint, &transport.PacketDialerEndpoint{Dialer: packetDialer, Address: address}, firstEndpoint, nil
+}
+
+// newHTTPProxyDialer returns a dialer for the HTTP proxy `hop`, connected with `endpoint`.
+func newHTTPProxyDialer(endpoint transport.StreamEndpoint, hop *proxyHopJSON) (*httpconnect.StreamDialer, error) {
+	var tlsConfig *tls.Config
+	if hop.TLS {
+		sni := hop.SNI
+		if sni == "" {
+			sni = hop.Host
+		}
+		tlsConfig = &tls.Config{ServerName: sni}
+	}
+	var credentials *httpconnect.Credentials
+	if len(hop.Username) > 0 {
+		credentials = &httpconnect.Credentials{Username: hop.Username, Password: hop.Password}
+	}
+	return httpconnect.NewStreamDialer(endpoint, tlsConfig, credentials)
+}
+
+// packetListenerDialer is a [transport.PacketDialer] that sends the packets with a new
+// PacketConn of `listener`. Unlike [transport.PacketListenerDialer], it doesn't drop the
+// packets from other addresses, since relays report resolved addresses as the source of
+// the packets from targets given by name.
+type packetListenerDialer struct {
+	listener transport.PacketListener
+}
+
+func (d *packetListenerDialer) Dial(ctx context.Context, address string) (net.Conn, error) {
+	remoteAddr, err := transport.MakeNetAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := d.listener.ListenPacket(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &boundPacketConn{PacketConn: conn, remoteAddr: remoteAddr}, nil
+}
+
+// boundPacketConn is a [net.Conn] that sends all the packets of a [net.PacketConn] to
+// `remoteAddr`.
+type boundPacketConn struct {
+	net.PacketConn
+	remoteAddr net.Addr
+}
+
+func (c *boundPacketConn) Read(b []byte) (int, error) {
+	n, _, err := c.PacketConn.ReadFrom(b)
+	return n, err
+}
+
+func (c *boundPacketConn) Write(b []byte) (int, error) {
+	return c.PacketConn.WriteTo(b, c.remoteAddr)
+}
+
+func (c *boundPacketConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
@@ -0,0 +1,145 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shadowsocks
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// startHTTPProxy starts an HTTP proxy stand-in that relays CONNECT streams, and sends
+// their targets to `targets`. Returns the proxy hop configuration.
+func startHTTPProxy(t *testing.T, targets chan<- string) proxyHopJSON {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		targets <- r.Host
+		targetConn, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer targetConn.Close()
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		go func() {
+			io.Copy(targetConn, rw)
+			targetConn.(*net.TCPConn).CloseWrite()
+		}()
+		io.Copy(conn, targetConn)
+	}))
+	t.Cleanup(server.Close)
+	host, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	portNumber, _ := strconv.Atoi(port)
+	return proxyHopJSON{Type: proxyTypeHTTP, Host: host, Port: uint16(portNumber)}
+}
+
+// startEchoServer starts a TCP server that echoes the streams. Returns its host and port.
+func startEchoServer(t *testing.T) (string, int) {
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.AcceptTCP()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	addr := listener.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port
+}
+
+func Test_newProxyChainEndpoints(t *testing.T) {
+	firstTargets, secondTargets := make(chan string, 1), make(chan string, 1)
+	hops := []proxyHopJSON{startHTTPProxy(t, firstTargets), startHTTPProxy(t, secondTargets)}
+	host, port := startEchoServer(t)
+
+	streamEndpoint, packetEndpoint, firstEndpoint, err := newProxyChainEndpoints(host, port, hops)
+	if err != nil {
+		t.Fatalf("newProxyChainEndpoints() failed: %v", err)
+	}
+	if firstEndpoint == nil {
+		t.Error("Expected the first hop endpoint")
+	}
+	conn, err := streamEndpoint.Connect(context.Background())
+	if err != nil {
+		t.Fatalf("Connect() failed: %v", err)
+	}
+	defer conn.Close()
+	secondAddr := net.JoinHostPort(hops[1].Host, strconv.Itoa(int(hops[1].Port)))
+	if target := <-firstTargets; target != secondAddr {
+		t.Errorf("First hop target = %v, want %v", target, secondAddr)
+	}
+	if target, want := <-secondTargets, net.JoinHostPort(host, strconv.Itoa(port)); target != want {
+		t.Errorf("Second hop target = %v, want %v", target, want)
+	}
+	io.WriteString(conn, "hello")
+	conn.CloseWrite()
+	if got, err := io.ReadAll(conn); err != nil || string(got) != "hello" {
+		t.Errorf("Echoed %q, %v, want %q", got, err, "hello")
+	}
+	if _, err := packetEndpoint.Connect(context.Background()); err == nil {
+		t.Error("Expected UDP to be unsupported through an HTTP proxy")
+	}
+}
+
+func Test_NewClientFromJSON_ProxyChain(t *testing.T) {
+	// The Shadowsocks host is not resolved locally, so it may be unresolvable.
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{
+			name: "HTTP proxy",
+			config: `{"host":"ss.invalid","port":8388,"method":"chacha20-ietf-poly1305","password":"abcd1234",` +
+				`"httpProxy":{"host":"192.0.2.1","port":443,"tls":true,"sni":"front.example.com","username":"user","password":"secret"},` +
+				`"websocket":{"path":"/tcp"}}`,
+		},
+		{
+			name: "SOCKS5 then HTTP",
+			config: `{"host":"ss.invalid","port":8388,"method":"chacha20-ietf-poly1305","password":"abcd1234",` +
+				`"proxyChain":[{"type":"socks5","host":"192.0.2.1","port":1080},{"type":"http","host":"proxy.invalid","port":3128}]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := NewClientFromJSON(tt.config)
+			if err != nil {
+				t.Fatalf("NewClientFromJSON() failed: %v", err)
+			}
+			if client.NetworkChangeHandler == nil {
+				t.Error("Expected a NetworkChangeHandler")
+			}
+			if _, err := client.ListenPacket(context.Background()); err == nil {
+				t.Error("ListenPacket() expects an error")
+			}
+		})
+	}
+}
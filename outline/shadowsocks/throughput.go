@@ -0,0 +1,46 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shadowsocks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/connectivity"
+)
+
+// MeasureThroughput downloads `sizeBytes` from `downloadURL` and uploads them to `uploadURL`
+// through the Shadowsocks proxy, stopping each transfer after `timeLimitMillis`. Either URL may
+// be empty to skip the transfer, and non-positive values select the defaults of
+// [connectivity.Checker.MeasureThroughput]. The transfers can be stopped with
+// [connectivity.Checker.Cancel] if `checker` is not nil.
+// Returns a JSON [connectivity.ThroughputResult].
+func MeasureThroughput(client *Client, checker *connectivity.Checker, downloadURL, uploadURL string, sizeBytes, timeLimitMillis int) (string, error) {
+	if checker == nil {
+		checker = connectivity.NewChecker()
+	}
+	timeLimit := time.Duration(timeLimitMillis) * time.Millisecond
+	result, err := checker.MeasureThroughput(context.Background(), client, downloadURL, uploadURL, int64(sizeBytes), timeLimit)
+	if err != nil {
+		return "", err
+	}
+	resultJSON, err := json.Marshal(result)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize the result: %w", err)
+	}
+	return string(resultJSON), nil
+}
@@ -0,0 +1,45 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shadowsocks
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/connectivity"
+)
+
+func Test_MeasureThroughput(t *testing.T) {
+	client, err := NewClientFromJSON(`{"host":"127.0.0.1","port":1,"method":"chacha20-ietf-poly1305","password":"SECRET"}`)
+	if err != nil {
+		t.Fatalf("NewClientFromJSON() failed: %v", err)
+	}
+	if _, err := MeasureThroughput(client, nil, "", "", 0, 0); err == nil {
+		t.Error("MeasureThroughput() expects an error without URLs")
+	}
+
+	// The test proxy refuses connections.
+	resultJSON, err := MeasureThroughput(client, nil, "http://example.com/file", "", 1000, 1000)
+	if err != nil {
+		t.Fatalf("MeasureThroughput() failed: %v", err)
+	}
+	var result connectivity.ThroughputResult
+	if err := json.Unmarshal([]byte(resultJSON), &result); err != nil {
+		t.Fatalf("Invalid result %v: %v", resultJSON, err)
+	}
+	if result.Download == nil || result.Download.Error == "" || result.Upload != nil {
+		t.Errorf("Got result %v, want a failed download", resultJSON)
+	}
+}
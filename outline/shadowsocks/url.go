@@ -0,0 +1,115 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shadowsocks
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// parseConfigFromURL parses a SIP002 access key `in` as a configJSON object.
+// See https://shadowsocks.org/doc/sip002.html for the format specification.
+//
+// The user info can be either the URL-safe base64 encoding of "method:password"
+// (with or without padding), or a percent-encoded "method:password" pair.
+// The optional `prefix` query parameter is the percent-encoded UTF-8 string
+// of the salt prefix codepoints, as in the JSON configuration.
+// The optional `plugin` query parameter is of the form "name;opt=val;...", and is
+// parsed into the plugin name and options. The fragment (tag) is ignored.
+func parseConfigFromURL(in string) (*configJSON, error) {
+	u, err := url.Parse(strings.TrimSpace(in))
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %w", err)
+	}
+	if u.Scheme != "ss" {
+		return nil, fmt.Errorf("unsupported URL scheme %q, must be \"ss\"", u.Scheme)
+	}
+	if u.Opaque != "" {
+		return nil, errors.New("URL must be of the form ss://userinfo@host:port")
+	}
+	if u.User == nil {
+		return nil, errors.New("URL is missing the user info")
+	}
+
+	conf := &configJSON{}
+	if conf.Method, conf.Password, err = parseUserInfo(u.User); err != nil {
+		return nil, err
+	}
+
+	conf.Host = u.Hostname()
+	if conf.Host == "" {
+		return nil, errors.New("URL is missing the host")
+	}
+	portStr := u.Port()
+	if portStr == "" {
+		return nil, errors.New("URL is missing the port")
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil || port == 0 {
+		return nil, fmt.Errorf("invalid port %q, must be within range [1..65535]", portStr)
+	}
+	conf.Port = uint16(port)
+
+	query, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL query: %w", err)
+	}
+	if plugin := query.Get("plugin"); plugin != "" {
+		conf.Plugin, conf.PluginOptions, _ = strings.Cut(plugin, ";")
+		if conf.Plugin == "" {
+			return nil, errors.New("URL plugin parameter is missing the plugin name")
+		}
+	}
+	conf.Prefix = query.Get("prefix")
+	return conf, nil
+}
+
+// parseUserInfo extracts the cipher method and password from the SIP002 user
+// info, which is either base64-encoded or a plain "method:password" pair.
+func parseUserInfo(userInfo *url.Userinfo) (method, password string, err error) {
+	if pass, hasPassword := userInfo.Password(); hasPassword {
+		method, password = userInfo.Username(), pass
+	} else {
+		decoded, err := decodeBase64(userInfo.Username())
+		if err != nil {
+			return "", "", fmt.Errorf("failed to decode user info: %w", err)
+		}
+		var found bool
+		if method, password, found = strings.Cut(string(decoded), ":"); !found {
+			return "", "", errors.New("user info must be of the form method:password")
+		}
+	}
+	if method == "" {
+		return "", "", errors.New("user info is missing the cipher method")
+	}
+	if password == "" {
+		return "", "", errors.New("user info is missing the password")
+	}
+	return method, password, nil
+}
+
+// decodeBase64 decodes `s` as base64, accepting both the standard and the
+// URL-safe alphabets, with or without padding.
+func decodeBase64(s string) ([]byte, error) {
+	s = strings.TrimRight(s, "=")
+	if strings.ContainsAny(s, "+/") {
+		return base64.RawStdEncoding.DecodeString(s)
+	}
+	return base64.RawURLEncoding.DecodeString(s)
+}
@@ -0,0 +1,258 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shadowsocks
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_parseConfigFromURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    *configJSON
+		wantErr bool
+	}{
+		{
+			name:  "base64 user info",
+			input: "ss://Y2hhY2hhMjAtaWV0Zi1wb2x5MTMwNTpTZWNyZXQ@192.0.2.1:8388#Example",
+			want: &configJSON{
+				Host:     "192.0.2.1",
+				Port:     8388,
+				Method:   "chacha20-ietf-poly1305",
+				Password: "Secret",
+			},
+		},
+		{
+			name:  "padded base64 user info",
+			input: "ss://YWVzLTEyOC1nY206dGVzdA==@example.com:443",
+			want: &configJSON{
+				Host:     "example.com",
+				Port:     443,
+				Method:   "aes-128-gcm",
+				Password: "test",
+			},
+		},
+		{
+			name:  "percent-encoded standard base64 alphabet",
+			input: "ss://YWVzLTEyOC1nY206Pj4%2FPz8%2B@example.com:443",
+			want: &configJSON{
+				Host:     "example.com",
+				Port:     443,
+				Method:   "aes-128-gcm",
+				Password: ">>???>",
+			},
+		},
+		{
+			name:  "url-safe base64 alphabet",
+			input: "ss://YWVzLTEyOC1nY206Pj4_Pz8-@example.com:443",
+			want: &configJSON{
+				Host:     "example.com",
+				Port:     443,
+				Method:   "aes-128-gcm",
+				Password: ">>???>",
+			},
+		},
+		{
+			name:  "plain user info",
+			input: "ss://aes-256-gcm:p%40ss%3Aword@192.0.2.1:8388/",
+			want: &configJSON{
+				Host:     "192.0.2.1",
+				Port:     8388,
+				Method:   "aes-256-gcm",
+				Password: "p@ss:word",
+			},
+		},
+		{
+			name:  "IPv6 host",
+			input: "ss://Y2hhY2hhMjAtaWV0Zi1wb2x5MTMwNTpTZWNyZXQ@[2001:db8::1]:8388",
+			want: &configJSON{
+				Host:     "2001:db8::1",
+				Port:     8388,
+				Method:   "chacha20-ietf-poly1305",
+				Password: "Secret",
+			},
+		},
+		{
+			name:  "prefix",
+			input: "ss://Y2hhY2hhMjAtaWV0Zi1wb2x5MTMwNTpTZWNyZXQ@192.0.2.1:8388/?prefix=%16%03%01%00%C2%A8%01%01#Example",
+			want: &configJSON{
+				Host:     "192.0.2.1",
+				Port:     8388,
+				Method:   "chacha20-ietf-poly1305",
+				Password: "Secret",
+				Prefix:   "\u0016\u0003\u0001\u0000¨\u0001\u0001",
+			},
+		},
+		{
+			name:  "surrounding whitespace",
+			input: "  ss://Y2hhY2hhMjAtaWV0Zi1wb2x5MTMwNTpTZWNyZXQ@192.0.2.1:8388\n",
+			want: &configJSON{
+				Host:     "192.0.2.1",
+				Port:     8388,
+				Method:   "chacha20-ietf-poly1305",
+				Password: "Secret",
+			},
+		},
+		{
+			name:    "wrong scheme",
+			input:   "http://Y2hhY2hhMjAtaWV0Zi1wb2x5MTMwNTpTZWNyZXQ@192.0.2.1:8388",
+			wantErr: true,
+		},
+		{
+			name:    "opaque",
+			input:   "ss:Y2hhY2hhMjAtaWV0Zi1wb2x5MTMwNTpTZWNyZXQ@192.0.2.1:8388",
+			wantErr: true,
+		},
+		{
+			name:    "missing user info",
+			input:   "ss://192.0.2.1:8388",
+			wantErr: true,
+		},
+		{
+			name:    "invalid base64",
+			input:   "ss://not*base64@192.0.2.1:8388",
+			wantErr: true,
+		},
+		{
+			name:    "base64 without colon",
+			input:   "ss://YWVzLTEyOC1nY20@192.0.2.1:8388",
+			wantErr: true,
+		},
+		{
+			name:    "empty method",
+			input:   "ss://:Secret@192.0.2.1:8388",
+			wantErr: true,
+		},
+		{
+			name:    "empty password",
+			input:   "ss://aes-128-gcm:@192.0.2.1:8388",
+			wantErr: true,
+		},
+		{
+			name:    "missing host",
+			input:   "ss://Y2hhY2hhMjAtaWV0Zi1wb2x5MTMwNTpTZWNyZXQ@:8388",
+			wantErr: true,
+		},
+		{
+			name:    "missing port",
+			input:   "ss://Y2hhY2hhMjAtaWV0Zi1wb2x5MTMwNTpTZWNyZXQ@192.0.2.1",
+			wantErr: true,
+		},
+		{
+			name:    "port 0",
+			input:   "ss://Y2hhY2hhMjAtaWV0Zi1wb2x5MTMwNTpTZWNyZXQ@192.0.2.1:0",
+			wantErr: true,
+		},
+		{
+			name:    "port 65536",
+			input:   "ss://Y2hhY2hhMjAtaWV0Zi1wb2x5MTMwNTpTZWNyZXQ@192.0.2.1:65536",
+			wantErr: true,
+		},
+		{
+			name:  "plugin",
+			input: "ss://Y2hhY2hhMjAtaWV0Zi1wb2x5MTMwNTpTZWNyZXQ@192.0.2.1:8388/?plugin=obfs-local%3Bobfs%3Dhttp%3Bobfs-host%3Dexample.com",
+			want: &configJSON{
+				Host:          "192.0.2.1",
+				Port:          8388,
+				Method:        "chacha20-ietf-poly1305",
+				Password:      "Secret",
+				Plugin:        "obfs-local",
+				PluginOptions: "obfs=http;obfs-host=example.com",
+			},
+		},
+		{
+			name:  "plugin without options",
+			input: "ss://Y2hhY2hhMjAtaWV0Zi1wb2x5MTMwNTpTZWNyZXQ@192.0.2.1:8388/?plugin=v2ray-plugin",
+			want: &configJSON{
+				Host:     "192.0.2.1",
+				Port:     8388,
+				Method:   "chacha20-ietf-poly1305",
+				Password: "Secret",
+				Plugin:   "v2ray-plugin",
+			},
+		},
+		{
+			name:    "plugin without name",
+			input:   "ss://Y2hhY2hhMjAtaWV0Zi1wb2x5MTMwNTpTZWNyZXQ@192.0.2.1:8388/?plugin=%3Bobfs%3Dhttp",
+			wantErr: true,
+		},
+		{
+			name:    "malformed query",
+			input:   "ss://Y2hhY2hhMjAtaWV0Zi1wb2x5MTMwNTpTZWNyZXQ@192.0.2.1:8388/?prefix=%zz",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseConfigFromURL(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseConfigFromURL() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseConfigFromURL() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_NewClientFromURL_Errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{
+			name:  "empty",
+			input: "",
+		},
+		{
+			name:  "prefix out-of-range",
+			input: "ss://Y2hhY2hhMjAtaWV0Zi1wb2x5MTMwNTpTZWNyZXQ@192.0.2.1:8388/?prefix=%C4%80",
+		},
+		{
+			name:  "unsupported cipher",
+			input: "ss://some-cipher:Secret@192.0.2.1:8388",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewClientFromURL(tt.input)
+			if err == nil || got != nil {
+				t.Errorf("NewClientFromURL() expects an error, got = %v", got)
+				return
+			}
+		})
+	}
+}
+
+func Test_NewClientFromURL_Plugin(t *testing.T) {
+	client, err := NewClientFromURL("ss://Y2hhY2hhMjAtaWV0Zi1wb2x5MTMwNTpTZWNyZXQ@192.0.2.1:8388/?plugin=obfs-local%3Bobfs%3Dhttp")
+	if err != nil {
+		t.Fatalf("NewClientFromURL() failed: %v", err)
+	}
+	if _, err := client.Dial(context.Background(), "example.com:443"); err == nil || !strings.Contains(err.Error(), "obfs-local") {
+		t.Errorf("Dial() error = %v, want unsupported plugin", err)
+	}
+	if _, err := client.ListenPacket(context.Background()); err == nil || !strings.Contains(err.Error(), "obfs-local") {
+		t.Errorf("ListenPacket() error = %v, want unsupported plugin", err)
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shadowsocks
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/internal/websocket"
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+)
+
+// newWebSocketEndpoints returns the endpoints that tunnel the Shadowsocks traffic over
+// TLS+WebSocket connections established with `tcpEndpoint` to the proxy at `host:port`.
+func newWebSocketEndpoints(host string, port int, tcpEndpoint transport.StreamEndpoint, config *webSocketJSON) (transport.StreamEndpoint, transport.PacketEndpoint) {
+	hostHeader, sni := config.Host, config.SNI
+	if hostHeader == "" {
+		hostHeader = net.JoinHostPort(host, strconv.Itoa(port))
+		if port == 443 {
+			// The default port is omitted, but IPv6 literals keep their brackets.
+			hostHeader = strings.TrimSuffix(hostHeader, ":443")
+		}
+		if sni == "" {
+			sni = host
+		}
+	}
+	if sni == "" {
+		sni = hostHeader
+		if h, _, err := net.SplitHostPort(hostHeader); err == nil {
+			sni = h
+		}
+		sni = strings.TrimSuffix(strings.TrimPrefix(sni, "["), "]")
+	}
+	makeConfig := func(path string) websocket.Config {
+		return websocket.Config{
+			Endpoint:  tcpEndpoint,
+			Host:      hostHeader,
+			Path:      path,
+			TLSConfig: &tls.Config{ServerName: sni},
+		}
+	}
+
+	streamEndpoint := &websocket.StreamEndpoint{Config: makeConfig(config.Path)}
+	if config.UDPPath == "" {
+		return streamEndpoint, unsupportedPacketEndpoint{"UDP is not configured for the WebSocket transport"}
+	}
+	return streamEndpoint, &websocket.PacketEndpoint{Config: makeConfig(config.UDPPath)}
+}
+
+// unsupportedPacketEndpoint is used when UDP can't be relayed, so that the UDP
+// connectivity check fails and the tunnel falls back to DNS over TCP.
+type unsupportedPacketEndpoint struct {
+	reason string
+}
+
+func (e unsupportedPacketEndpoint) Connect(ctx context.Context) (net.Conn, error) {
+	return nil, errors.New(e.reason)
+}
@@ -0,0 +1,131 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shadowsocks
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/internal/websocket"
+	"github.com/Jigsaw-Code/outline-sdk/transport"
+)
+
+func Test_newWebSocketEndpoints(t *testing.T) {
+	tests := []struct {
+		name     string
+		host     string
+		port     int
+		config   webSocketJSON
+		wantHost string
+		wantSNI  string
+	}{
+		{
+			name:     "defaults",
+			host:     "proxy.example.com",
+			port:     443,
+			config:   webSocketJSON{Path: "/tcp"},
+			wantHost: "proxy.example.com",
+			wantSNI:  "proxy.example.com",
+		},
+		{
+			name:     "non-default port",
+			host:     "proxy.example.com",
+			port:     8443,
+			config:   webSocketJSON{Path: "/tcp"},
+			wantHost: "proxy.example.com:8443",
+			wantSNI:  "proxy.example.com",
+		},
+		{
+			name:     "host header",
+			host:     "192.0.2.1",
+			port:     443,
+			config:   webSocketJSON{Path: "/tcp", Host: "backend.example.com"},
+			wantHost: "backend.example.com",
+			wantSNI:  "backend.example.com",
+		},
+		{
+			name:     "domain fronting",
+			host:     "192.0.2.1",
+			port:     443,
+			config:   webSocketJSON{Path: "/tcp", Host: "backend.example.com", SNI: "front.example.com"},
+			wantHost: "backend.example.com",
+			wantSNI:  "front.example.com",
+		},
+		{
+			name:     "IPv6",
+			host:     "2001:db8::1",
+			port:     443,
+			config:   webSocketJSON{Path: "/tcp"},
+			wantHost: "[2001:db8::1]",
+			wantSNI:  "2001:db8::1",
+		},
+		{
+			name:     "IPv6 with port",
+			host:     "2001:db8::1",
+			port:     8443,
+			config:   webSocketJSON{Path: "/tcp"},
+			wantHost: "[2001:db8::1]:8443",
+			wantSNI:  "2001:db8::1",
+		},
+		{
+			name:     "IPv6 host header",
+			host:     "192.0.2.1",
+			port:     443,
+			config:   webSocketJSON{Path: "/tcp", Host: "[2001:db8::2]"},
+			wantHost: "[2001:db8::2]",
+			wantSNI:  "2001:db8::2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tcpEndpoint := &transport.TCPEndpoint{Address: "192.0.2.1:443"}
+			streamEndpoint, _ := newWebSocketEndpoints(tt.host, tt.port, tcpEndpoint, &tt.config)
+			config := streamEndpoint.(*websocket.StreamEndpoint).Config
+			if config.Endpoint != tcpEndpoint {
+				t.Errorf("Endpoint = %v, want %v", config.Endpoint, tcpEndpoint)
+			}
+			if config.Host != tt.wantHost {
+				t.Errorf("Host = %q, want %q", config.Host, tt.wantHost)
+			}
+			if config.TLSConfig.ServerName != tt.wantSNI {
+				t.Errorf("SNI = %q, want %q", config.TLSConfig.ServerName, tt.wantSNI)
+			}
+			if config.Path != tt.config.Path {
+				t.Errorf("Path = %q, want %q", config.Path, tt.config.Path)
+			}
+		})
+	}
+}
+
+func Test_newWebSocketEndpoints_UDP(t *testing.T) {
+	tcpEndpoint := &transport.TCPEndpoint{Address: "192.0.2.1:443"}
+	_, packetEndpoint := newWebSocketEndpoints("192.0.2.1", 443, tcpEndpoint, &webSocketJSON{Path: "/tcp", UDPPath: "/udp"})
+	if path := packetEndpoint.(*websocket.PacketEndpoint).Path; path != "/udp" {
+		t.Errorf("UDP path = %q, want /udp", path)
+	}
+
+	_, packetEndpoint = newWebSocketEndpoints("192.0.2.1", 443, tcpEndpoint, &webSocketJSON{Path: "/tcp"})
+	if _, err := packetEndpoint.Connect(context.Background()); err == nil {
+		t.Error("Expected UDP to be unsupported without a UDP path")
+	}
+}
+
+func Test_NewClientFromJSON_WebSocket(t *testing.T) {
+	client, err := NewClientFromJSON(`{"host":"192.0.2.1","port":443,"method":"chacha20-ietf-poly1305","password":"abcd1234",` +
+		`"websocket":{"path":"/tcp","udpPath":"/udp","host":"backend.example.com","sni":"front.example.com"}}`)
+	if err != nil || client == nil {
+		t.Errorf("NewClientFromJSON() failed: %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This package provides a client for SOCKS5 proxies, which the Outline tunnel
+// can use instead of a Shadowsocks server.
+//
+// All data structures and functions will also be exposed as libraries that
+// non-golang callers can use (for example, C/Java/Objective-C).
+package socks5
+
+import (
+	"fmt"
+
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline"
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/connectivity"
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/internal/happyeyeballs"
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/internal/proxyclient"
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/internal/socks5"
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/neterrors"
+)
+
+// A client object that can be used to connect to a remote SOCKS5 proxy.
+type Client outline.Client
+
+// NewClient creates a new client for the SOCKS5 proxy at `host:port`. If `username`
+// is not empty, the client authenticates with `username` and `password`.
+// UDP is relayed with the UDP ASSOCIATE command.
+func NewClient(host string, port int, username, password string) (*Client, error) {
+	if err := proxyclient.ValidateConfig(host, port, username, password); err != nil {
+		return nil, fmt.Errorf("invalid SOCKS5 configuration: %w", err)
+	}
+	var credentials *socks5.Credentials
+	if len(username) > 0 {
+		credentials = &socks5.Credentials{Username: username, Password: password}
+	}
+	// The host is resolved on the first connection, and again on network changes.
+	proxyEndpoint := happyeyeballs.NewEndpoint(host, port, happyeyeballs.DefaultLookup)
+	dialer, err := socks5.NewDialer(&happyeyeballs.StreamEndpoint{Endpoint: proxyEndpoint}, credentials)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SOCKS5 configuration: %w", err)
+	}
+	return &Client{StreamDialer: dialer, PacketListener: dialer, NetworkChangeHandler: proxyEndpoint}, nil
+}
+
+// CheckConnectivity determines whether the SOCKS5 proxy can relay TCP and UDP traffic under
+// the current network. Returns one of the error codes of the shadowsocks package, and an
+// error if an unexpected error ocurrs.
+func CheckConnectivity(client *Client) (int, error) {
+	return proxyclient.ErrorCode(proxyclient.Check((*outline.Client)(client), nil, true))
+}
+
+// CheckConnectivityWithChecker is like [CheckConnectivity], with the targets, timeouts and
+// retry policy of `checker`. The check can be stopped with [connectivity.Checker.Cancel].
+func CheckConnectivityWithChecker(client *Client, checker *connectivity.Checker) (int, error) {
+	return proxyclient.ErrorCode(proxyclient.Check((*outline.Client)(client), checker, true))
+}
+
+// CheckConnectivityJSON is like [CheckConnectivityWithChecker], and returns the failure as the
+// JSON of a [neterrors.PlatformError], with its code, category, message, cause and whether it
+// can be retried, or an empty string on success.
+func CheckConnectivityJSON(client *Client, checker *connectivity.Checker) string {
+	return neterrors.ToJSON(proxyclient.Check((*outline.Client)(client), checker, true))
+}
@@ -0,0 +1,51 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package socks5
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_NewClient(t *testing.T) {
+	tests := []struct {
+		name     string
+		host     string
+		port     int
+		username string
+		password string
+		wantErr  bool
+	}{
+		{name: "no auth", host: "192.0.2.1", port: 1080},
+		{name: "user/pass", host: "proxy.example", port: 1080, username: "user", password: "secret"},
+		{name: "empty host", port: 1080, wantErr: true},
+		{name: "port 0", host: "192.0.2.1", wantErr: true},
+		{name: "port 65536", host: "192.0.2.1", port: 65536, wantErr: true},
+		{name: "password without username", host: "192.0.2.1", port: 1080, password: "secret", wantErr: true},
+		{name: "username without password", host: "192.0.2.1", port: 1080, username: "user", wantErr: true},
+		{name: "long username", host: "192.0.2.1", port: 1080, username: strings.Repeat("u", 256), password: "secret", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewClient(tt.host, tt.port, tt.username, tt.password)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewClient() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (got.StreamDialer == nil || got.PacketListener == nil || got.NetworkChangeHandler == nil) {
+				t.Errorf("NewClient() = %+v, want all fields set", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tun2socks
+
+import (
+	"encoding/binary"
+)
+
+const (
+	ipv4MinHeaderLength = 20
+	ipv6HeaderLength    = 40
+	tcpMinHeaderLength  = 20
+	udpHeaderLength     = 8
+	// The smallest MTU that IPv6 links must support (RFC 8200, section 5).
+	ipv6MinMTU = 1280
+
+	protocolICMP   = 1
+	protocolTCP    = 6
+	protocolUDP    = 17
+	protocolICMPv6 = 58
+
+	icmpv6TypeDestinationUnreachable = 1
+	icmpv6TypePacketTooBig           = 2
+	// Codes of the Destination Unreachable messages.
+	icmpv6CodePortUnreachable = 4
+)
+
+// newICMPv6Message returns an ICMPv6 error message of `icmpType` and `code`, for the IPv6
+// `packet` from the TUN device. `body` is the first word of the message body, such as the MTU
+// of a Packet Too Big message. The message quotes as much of the packet as fits in the
+// minimum MTU (RFC 4443).
+func newICMPv6Message(packet []byte, icmpType, code uint8, body uint32) []byte {
+	quoted := packet
+	if maxQuoted := ipv6MinMTU - ipv6HeaderLength - 8; len(quoted) > maxQuoted {
+		quoted = quoted[:maxQuoted]
+	}
+	message := newIPv6Reply(packet, protocolICMPv6, 8+len(quoted))
+	icmp := message[ipv6HeaderLength:]
+	icmp[0], icmp[1] = icmpType, code
+	binary.BigEndian.PutUint32(icmp[4:8], body)
+	copy(icmp[8:], quoted)
+	binary.BigEndian.PutUint16(icmp[2:4], checksum(pseudoHeaderSum(message), icmp))
+	return message
+}
+
+// newIPv6Reply returns an IPv6 packet from the destination to the source of `packet`, with a
+// zeroed payload of `protocol` and `payloadLength`.
+func newIPv6Reply(packet []byte, protocol uint8, payloadLength int) []byte {
+	message := make([]byte, ipv6HeaderLength+payloadLength)
+	header := message[:ipv6HeaderLength]
+	header[0] = 0x60 // Version 6.
+	binary.BigEndian.PutUint16(header[4:6], uint16(payloadLength))
+	header[6] = protocol
+	header[7] = 64 // Hop limit
+	copy(header[8:24], packet[24:40])
+	copy(header[24:40], packet[8:24])
+	return message
+}
+
+// pseudoHeaderSum returns the partial sum of the pseudo-header of the IPv6 `packet`, with the
+// addresses, length and protocol, that its upper-layer checksum covers.
+func pseudoHeaderSum(packet []byte) uint32 {
+	pseudoHeader := make([]byte, 40)
+	copy(pseudoHeader[:32], packet[8:40])
+	binary.BigEndian.PutUint32(pseudoHeader[32:36], uint32(len(packet)-ipv6HeaderLength))
+	pseudoHeader[39] = packet[6]
+	return sum(0, pseudoHeader)
+}
+
+// checksum returns the Internet checksum (RFC 1071) of `data`, starting with the partial `initial` sum.
+func checksum(initial uint32, data []byte) uint16 {
+	s := sum(initial, data)
+	for s > 0xffff {
+		s = (s >> 16) + (s & 0xffff)
+	}
+	return ^uint16(s)
+}
+
+func sum(initial uint32, data []byte) uint32 {
+	s := initial
+	for i := 0; i+1 < len(data); i += 2 {
+		s += uint32(binary.BigEndian.Uint16(data[i : i+2]))
+	}
+	if len(data)%2 == 1 {
+		s += uint32(data[len(data)-1]) << 8
+	}
+	return s
+}
@@ -0,0 +1,86 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tun2socks
+
+import "encoding/binary"
+
+// Flags of the IPv6 protocols rejected by the tunnel.
+const (
+	rejectIPv6TCP = 1 << iota
+	rejectIPv6UDP
+)
+
+// TCP header flags.
+const (
+	tcpFlagFIN = 0x01
+	tcpFlagSYN = 0x02
+	tcpFlagRST = 0x04
+	tcpFlagACK = 0x10
+)
+
+// ipv6Unreachable returns the reply to the IPv6 `packet` from the TUN device if its protocol is
+// rejected by the `rejected` flags, so that the connection fails right away: a TCP reset, or an
+// ICMPv6 Port Unreachable message for UDP. Linux and Android treat the latter as a hard error,
+// unlike the "no route to destination" code, which UDP sockets ignore.
+// Returns nil if the packet must be relayed.
+func ipv6Unreachable(packet []byte, rejected int32) []byte {
+	// Packets with extension headers are relayed, since they are rare.
+	if len(packet) < ipv6HeaderLength || packet[0]>>4 != 6 {
+		return nil
+	}
+	switch {
+	case packet[6] == protocolTCP && rejected&rejectIPv6TCP != 0:
+		return tcpReset(packet)
+	case packet[6] == protocolUDP && rejected&rejectIPv6UDP != 0:
+		return newICMPv6Message(packet, icmpv6TypeDestinationUnreachable, icmpv6CodePortUnreachable, 0)
+	}
+	return nil
+}
+
+// tcpReset returns the TCP reset that answers the IPv6 TCP `packet` (RFC 9293, section 3.10.7.1),
+// or nil if the packet is truncated or is itself a reset.
+func tcpReset(packet []byte) []byte {
+	segment := packet[ipv6HeaderLength:]
+	if len(segment) < tcpMinHeaderLength {
+		return nil
+	}
+	headerLength := int(segment[12]>>4) * 4
+	flags := segment[13]
+	if headerLength < tcpMinHeaderLength || headerLength > len(segment) || flags&tcpFlagRST != 0 {
+		return nil
+	}
+	message := newIPv6Reply(packet, protocolTCP, tcpMinHeaderLength)
+	reset := message[ipv6HeaderLength:]
+	copy(reset[0:2], segment[2:4])
+	copy(reset[2:4], segment[0:2])
+	reset[12] = tcpMinHeaderLength / 4 << 4
+	if flags&tcpFlagACK != 0 {
+		copy(reset[4:8], segment[8:12])
+		reset[13] = tcpFlagRST
+	} else {
+		// Acknowledge the whole segment, whose SYN and FIN flags count as one byte each.
+		length := len(segment) - headerLength
+		if flags&tcpFlagSYN != 0 {
+			length++
+		}
+		if flags&tcpFlagFIN != 0 {
+			length++
+		}
+		binary.BigEndian.PutUint32(reset[8:12], binary.BigEndian.Uint32(segment[4:8])+uint32(length))
+		reset[13] = tcpFlagRST | tcpFlagACK
+	}
+	binary.BigEndian.PutUint16(reset[16:18], checksum(pseudoHeaderSum(message), reset))
+	return message
+}
@@ -0,0 +1,118 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tun2socks
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+)
+
+// newTCPPacket returns an IPv6 TCP segment with `flags`, sequence and acknowledgment numbers
+// 1000 and 2000, and a payload of `payloadSize` bytes.
+func newTCPPacket(flags byte, payloadSize int) []byte {
+	packet := newUDPPacket(6, tcpMinHeaderLength-udpHeaderLength+payloadSize, true)
+	packet[6] = protocolTCP
+	segment := packet[ipv6HeaderLength:]
+	binary.BigEndian.PutUint32(segment[4:8], 1000)
+	binary.BigEndian.PutUint32(segment[8:12], 2000)
+	segment[12], segment[13] = tcpMinHeaderLength/4<<4, flags
+	return packet
+}
+
+func TestIPv6Unreachable(t *testing.T) {
+	udp := newUDPPacket(6, 100, true)
+	tests := []struct {
+		name     string
+		packet   []byte
+		rejected int32
+		want     bool
+	}{
+		{name: "UDP rejected", packet: udp, rejected: rejectIPv6UDP, want: true},
+		{name: "UDP allowed", packet: udp, rejected: rejectIPv6TCP},
+		{name: "TCP allowed", packet: newTCPPacket(tcpFlagSYN, 0), rejected: rejectIPv6UDP},
+		{name: "IPv4", packet: newUDPPacket(4, 100, true), rejected: rejectIPv6TCP | rejectIPv6UDP},
+		{name: "truncated", packet: udp[:30], rejected: rejectIPv6UDP},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := ipv6Unreachable(tt.packet, tt.rejected)
+			if !tt.want {
+				if message != nil {
+					t.Errorf("Got message %x, want the packet relayed", message)
+				}
+				return
+			}
+			header, err := ipv6.ParseHeader(message)
+			if err != nil || !header.Src.Equal(dstIPv6) || !header.Dst.Equal(srcIPv6) {
+				t.Fatalf("Got header %v, %v", header, err)
+			}
+			parsed, err := icmp.ParseMessage(protocolICMPv6, message[ipv6HeaderLength:])
+			if err != nil {
+				t.Fatalf("Invalid ICMPv6 message: %v", err)
+			}
+			body, ok := parsed.Body.(*icmp.DstUnreach)
+			if parsed.Type != ipv6.ICMPTypeDestinationUnreachable || parsed.Code != icmpv6CodePortUnreachable || !ok || !bytes.Equal(body.Data, tt.packet) {
+				t.Errorf("Got ICMPv6 message %+v, want Port Unreachable quoting the packet", parsed)
+			}
+		})
+	}
+}
+
+func TestIPv6Unreachable_TCP(t *testing.T) {
+	tests := []struct {
+		name      string
+		packet    []byte
+		wantSeq   uint32
+		wantAck   uint32
+		wantFlags byte
+	}{
+		{name: "SYN", packet: newTCPPacket(tcpFlagSYN, 0), wantAck: 1001, wantFlags: tcpFlagRST | tcpFlagACK},
+		{name: "data", packet: newTCPPacket(0, 10), wantAck: 1010, wantFlags: tcpFlagRST | tcpFlagACK},
+		{name: "ACK", packet: newTCPPacket(tcpFlagACK, 10), wantSeq: 2000, wantFlags: tcpFlagRST},
+		{name: "RST", packet: newTCPPacket(tcpFlagRST, 0)},
+		{name: "truncated", packet: newTCPPacket(tcpFlagSYN, 0)[:50]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message := ipv6Unreachable(tt.packet, rejectIPv6TCP)
+			if tt.wantFlags == 0 {
+				if message != nil {
+					t.Errorf("Got message %x, want the packet relayed", message)
+				}
+				return
+			}
+			header, err := ipv6.ParseHeader(message)
+			if err != nil || header.NextHeader != protocolTCP || !header.Src.Equal(dstIPv6) || !header.Dst.Equal(srcIPv6) {
+				t.Fatalf("Got header %v, %v", header, err)
+			}
+			reset := message[ipv6HeaderLength:]
+			if len(reset) != tcpMinHeaderLength || checksum(pseudoHeaderSum(message), reset) != 0 {
+				t.Fatalf("Invalid TCP segment %x", reset)
+			}
+			srcPort, dstPort := binary.BigEndian.Uint16(reset[0:2]), binary.BigEndian.Uint16(reset[2:4])
+			if srcPort != 443 || dstPort != 12345 {
+				t.Errorf("Got ports %v -> %v, want 443 -> 12345", srcPort, dstPort)
+			}
+			seq, ack, flags := binary.BigEndian.Uint32(reset[4:8]), binary.BigEndian.Uint32(reset[8:12]), reset[13]
+			if seq != tt.wantSeq || ack != tt.wantAck || flags != tt.wantFlags {
+				t.Errorf("Got seq %v, ack %v, flags %#x, want %v, %v, %#x", seq, ack, flags, tt.wantSeq, tt.wantAck, tt.wantFlags)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tun2socks
+
+import (
+	"encoding/binary"
+)
+
+// packetTooBig returns an ICMP message for the UDP `packet` from the TUN device, if its payload
+// is larger than `maxPayload` and it can't be fragmented. The message is a Fragmentation Needed
+// (IPv4) or a Packet Too Big (IPv6), with the MTU that fits `maxPayload`.
+// Returns nil if the packet must be relayed.
+func packetTooBig(packet []byte, maxPayload int) []byte {
+	if len(packet) == 0 {
+		return nil
+	}
+	switch packet[0] >> 4 {
+	case 4:
+		return ipv4FragmentationNeeded(packet, maxPayload)
+	case 6:
+		return ipv6PacketTooBig(packet, maxPayload)
+	}
+	return nil
+}
+
+func ipv4FragmentationNeeded(packet []byte, maxPayload int) []byte {
+	headerLength := int(packet[0]&0x0f) * 4
+	if headerLength < ipv4MinHeaderLength || len(packet) < headerLength+udpHeaderLength || packet[9] != protocolUDP {
+		return nil
+	}
+	// Datagrams without the Don't Fragment flag are fragmented by the network.
+	if packet[6]&0x40 == 0 {
+		return nil
+	}
+	totalLength := int(binary.BigEndian.Uint16(packet[2:4]))
+	if totalLength-headerLength-udpHeaderLength <= maxPayload {
+		return nil
+	}
+	// The message quotes the IP header and the UDP header (RFC 792).
+	quoted := packet[:headerLength+udpHeaderLength]
+	message := make([]byte, ipv4MinHeaderLength+8+len(quoted))
+	header, icmp := message[:ipv4MinHeaderLength], message[ipv4MinHeaderLength:]
+	header[0] = 0x45 // Version 4, header length of 5 words.
+	binary.BigEndian.PutUint16(header[2:4], uint16(len(message)))
+	header[8] = 64 // TTL
+	header[9] = protocolICMP
+	copy(header[12:16], packet[16:20])
+	copy(header[16:20], packet[12:16])
+	binary.BigEndian.PutUint16(header[10:12], checksum(0, header))
+
+	icmp[0], icmp[1] = 3, 4 // Destination Unreachable, Fragmentation Needed.
+	binary.BigEndian.PutUint16(icmp[6:8], uint16(maxPayload+ipv4MinHeaderLength+udpHeaderLength))
+	copy(icmp[8:], quoted)
+	binary.BigEndian.PutUint16(icmp[2:4], checksum(0, icmp))
+	return message
+}
+
+func ipv6PacketTooBig(packet []byte, maxPayload int) []byte {
+	// Packets with extension headers are relayed, since they are rare.
+	if len(packet) < ipv6HeaderLength+udpHeaderLength || packet[6] != protocolUDP {
+		return nil
+	}
+	payloadLength := int(binary.BigEndian.Uint16(packet[4:6]))
+	if payloadLength-udpHeaderLength <= maxPayload {
+		return nil
+	}
+	mtu := maxPayload + ipv6HeaderLength + udpHeaderLength
+	if mtu < ipv6MinMTU {
+		// Links can't advertise an MTU below the minimum, so the packet is relayed.
+		return nil
+	}
+	return newICMPv6Message(packet, icmpv6TypePacketTooBig, 0, uint32(mtu))
+}
@@ -0,0 +1,143 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tun2socks
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+var (
+	srcIPv4 = net.IPv4(10, 111, 222, 1).To4()
+	dstIPv4 = net.IPv4(8, 8, 8, 8).To4()
+	srcIPv6 = net.ParseIP("fd00::1")
+	dstIPv6 = net.ParseIP("2001:db8::1")
+)
+
+// newUDPPacket returns an IPv4 or IPv6 UDP packet with a payload of `payloadSize` bytes, and
+// the Don't Fragment flag set if `dontFragment`.
+func newUDPPacket(ipVersion, payloadSize int, dontFragment bool) []byte {
+	udp := make([]byte, udpHeaderLength+payloadSize)
+	binary.BigEndian.PutUint16(udp[0:2], 12345)
+	binary.BigEndian.PutUint16(udp[2:4], 443)
+	binary.BigEndian.PutUint16(udp[4:6], uint16(len(udp)))
+	if ipVersion == 4 {
+		header := make([]byte, ipv4MinHeaderLength)
+		header[0] = 0x45
+		binary.BigEndian.PutUint16(header[2:4], uint16(len(header)+len(udp)))
+		if dontFragment {
+			header[6] = 0x40
+		}
+		header[8], header[9] = 64, protocolUDP
+		copy(header[12:16], srcIPv4)
+		copy(header[16:20], dstIPv4)
+		binary.BigEndian.PutUint16(header[10:12], checksum(0, header))
+		return append(header, udp...)
+	}
+	header := make([]byte, ipv6HeaderLength)
+	header[0] = 0x60
+	binary.BigEndian.PutUint16(header[4:6], uint16(len(udp)))
+	header[6], header[7] = protocolUDP, 64
+	copy(header[8:24], srcIPv6)
+	copy(header[24:40], dstIPv6)
+	return append(header, udp...)
+}
+
+func TestPacketTooBig_IPv4(t *testing.T) {
+	if message := packetTooBig(newUDPPacket(4, 1000, true), 1000); message != nil {
+		t.Errorf("Got message for a packet within the limit: %x", message)
+	}
+	if message := packetTooBig(newUDPPacket(4, 1001, false), 1000); message != nil {
+		t.Errorf("Got message for a packet that can be fragmented: %x", message)
+	}
+
+	packet := newUDPPacket(4, 1001, true)
+	message := packetTooBig(packet, 1000)
+	header, err := ipv4.ParseHeader(message)
+	if err != nil {
+		t.Fatalf("Invalid IPv4 header: %v", err)
+	}
+	if header.Protocol != protocolICMP || !header.Src.Equal(dstIPv4) || !header.Dst.Equal(srcIPv4) || header.TotalLen != len(message) {
+		t.Errorf("Got header %v", header)
+	}
+	if checksum(0, message[:header.Len]) != 0 || checksum(0, message[header.Len:]) != 0 {
+		t.Errorf("Invalid checksums in message %x", message)
+	}
+	parsed, err := icmp.ParseMessage(protocolICMP, message[header.Len:])
+	if err != nil {
+		t.Fatalf("Invalid ICMP message: %v", err)
+	}
+	body, ok := parsed.Body.(*icmp.DstUnreach)
+	if parsed.Type != ipv4.ICMPTypeDestinationUnreachable || parsed.Code != 4 || !ok {
+		t.Fatalf("Got ICMP message %+v, want Fragmentation Needed", parsed)
+	}
+	if mtu := binary.BigEndian.Uint16(message[header.Len+6:]); mtu != 1028 {
+		t.Errorf("Got MTU %d, want 1028", mtu)
+	}
+	if !bytes.Equal(body.Data, packet[:ipv4MinHeaderLength+udpHeaderLength]) {
+		t.Errorf("Got quoted data %x, want the IP and UDP headers", body.Data)
+	}
+}
+
+func TestPacketTooBig_IPv6(t *testing.T) {
+	if message := packetTooBig(newUDPPacket(6, 1300, true), 1300); message != nil {
+		t.Errorf("Got message for a packet within the limit: %x", message)
+	}
+	if message := packetTooBig(newUDPPacket(6, 1300, true), 1000); message != nil {
+		t.Errorf("Got message advertising an MTU below the IPv6 minimum: %x", message)
+	}
+
+	packet := newUDPPacket(6, 1400, true)
+	message := packetTooBig(packet, 1300)
+	header, err := ipv6.ParseHeader(message)
+	if err != nil {
+		t.Fatalf("Invalid IPv6 header: %v", err)
+	}
+	if header.NextHeader != protocolICMPv6 || !header.Src.Equal(dstIPv6) || !header.Dst.Equal(srcIPv6) || len(message) > ipv6MinMTU {
+		t.Errorf("Got header %v for message of %d bytes", header, len(message))
+	}
+	parsed, err := icmp.ParseMessage(protocolICMPv6, message[ipv6HeaderLength:])
+	if err != nil {
+		t.Fatalf("Invalid ICMPv6 message: %v", err)
+	}
+	body, ok := parsed.Body.(*icmp.PacketTooBig)
+	if parsed.Type != ipv6.ICMPTypePacketTooBig || !ok || body.MTU != 1348 {
+		t.Fatalf("Got ICMPv6 message %+v, want Packet Too Big with MTU 1348", parsed)
+	}
+	if !bytes.HasPrefix(packet, body.Data) {
+		t.Errorf("Got quoted data that is not a prefix of the packet")
+	}
+	pseudoHeader := append(append([]byte(nil), message[8:40]...), 0, 0, 0, 0, 0, 0, 0, protocolICMPv6)
+	binary.BigEndian.PutUint32(pseudoHeader[32:36], uint32(len(message)-ipv6HeaderLength))
+	if checksum(sum(0, pseudoHeader), message[ipv6HeaderLength:]) != 0 {
+		t.Errorf("Invalid checksum in message %x", message)
+	}
+}
+
+func TestPacketTooBig_Ignored(t *testing.T) {
+	tcp := newUDPPacket(4, 2000, true)
+	tcp[9] = 6
+	for _, packet := range [][]byte{nil, {0x45}, tcp, newUDPPacket(4, 10, true)[:24]} {
+		if message := packetTooBig(packet, 100); message != nil {
+			t.Errorf("Got message %x for packet %x", message, packet)
+		}
+	}
+}
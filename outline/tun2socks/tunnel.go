@@ -15,9 +15,11 @@
 package tun2socks
 
 import (
+	"context"
 	"errors"
 	"io"
-	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/eycorsican/go-tun2socks/core"
@@ -25,6 +27,7 @@ import (
 
 	"github.com/Jigsaw-Code/outline-sdk/transport"
 
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline"
 	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/connectivity"
 	"github.com/Jigsaw-Code/outline-go-tun2socks/tunnel"
 )
@@ -34,9 +37,43 @@ type Tunnel interface {
 	tunnel.Tunnel
 
 	// UpdateUDPSupport determines if UDP is supported following a network connectivity change.
-	// Sets the tunnel's UDP connection handler accordingly, falling back to DNS over TCP if UDP is not supported.
+	// Sets the tunnel's UDP connection handler accordingly, falling back to UDP-over-TCP, if the proxy
+	// supports it, or to DNS over TCP if UDP is not supported.
 	// Returns whether UDP proxying is supported in the new network.
 	UpdateUDPSupport() bool
+
+	// OnNetworkChanged must be called when the device switches networks. Discards the proxy
+	// connection state of the previous network, such as the resolved proxy addresses, and
+	// updates the UDP support as in UpdateUDPSupport.
+	// Returns whether UDP proxying is supported in the new network.
+	OnNetworkChanged() bool
+
+	// SetConnectivityChecker sets the checker of UpdateUDPSupport, which defaults to
+	// connectivity.NewChecker().
+	SetConnectivityChecker(checker *connectivity.Checker)
+
+	// SetMaxUDPPayloadSize limits the payload of the UDP datagrams from the TUN device to `size`
+	// bytes, such as the result of connectivity.Checker.ProbeMaxUDPPayload. Larger datagrams that
+	// can't be fragmented are dropped, and answered with an ICMP Fragmentation Needed (IPv4) or
+	// Packet Too Big (IPv6) message that advertises the MTU that fits `size`, so that apps lower
+	// the size of their datagrams instead of losing them through the proxy. TCP is not affected,
+	// since the tunnel terminates the TCP connections. Non-positive sizes remove the limit.
+	SetMaxUDPPayloadSize(size int)
+
+	// SetIPv6Support sets whether the proxy can reach IPv6 destinations over TCP and UDP, such as
+	// the result of connectivity.Checker.CheckIPv6Connectivity. The packets of unsupported IPv6
+	// flows are answered with a TCP reset or an ICMPv6 Port Unreachable message, so that apps
+	// fall back to IPv4 quickly instead of timing out. IPv6 is supported by default.
+	SetIPv6Support(tcp, udp bool)
+
+	// StartMonitoring starts a connectivity.Monitor of the proxy, with the checker of
+	// SetConnectivityChecker, that notifies `listener` of the connectivity state transitions.
+	// Replaces the previous monitor, if any. The monitor checks immediately when the network
+	// changes, and is stopped by StopMonitoring and Disconnect, which the listener may call.
+	StartMonitoring(listener connectivity.MonitorListener)
+
+	// StopMonitoring stops the monitor started by StartMonitoring, if any.
+	StopMonitoring()
 }
 
 // Deprecated: use Tunnel directly.
@@ -44,21 +81,34 @@ type OutlineTunnel = Tunnel
 
 type outlinetunnel struct {
 	tunnel.Tunnel
+	tunWriter    io.Writer
 	lwipStack    core.LWIPStack
 	streamDialer transport.StreamDialer
 	packetDialer transport.PacketListener
-	isUDPEnabled bool // Whether the tunnel supports proxying UDP.
+	// Relays UDP over TCP when UDP is not supported. May be nil.
+	fallbackPacketDialer transport.PacketListener
+	// Notified of network changes. May be nil.
+	networkChangeHandler outline.NetworkChangeHandler
+	isUDPEnabled         bool // Whether the tunnel supports proxying UDP.
+	// Protects checker, which can be replaced while the checks run.
+	checkerMu sync.Mutex
+	checker   *connectivity.Checker
+	// Limit of the UDP payloads from the TUN device, or 0. Accessed atomically.
+	maxUDPPayload int32
+	// Flags of the rejected IPv6 protocols. Accessed atomically.
+	rejectedIPv6 int32
+	// Protects monitor, which is nil if not monitoring.
+	monitorMu sync.Mutex
+	monitor   *connectivity.Monitor
 }
 
-// newTunnel connects a tunnel to a Shadowsocks proxy server and returns an `outline.Tunnel`.
+// newTunnel connects a tunnel to a proxy server and returns an `outline.Tunnel`.
 //
-// `host` is the IP or domain of the Shadowsocks proxy.
-// `port` is the port of the Shadowsocks proxy.
-// `password` is the password of the Shadowsocks proxy.
-// `cipher` is the encryption cipher used by the Shadowsocks proxy.
-// `isUDPEnabled` indicates if the Shadowsocks proxy and the network support proxying UDP traffic.
+// `client` provides the dialers to the proxy. Its FallbackPacketListener, if not nil,
+// relays UDP over TCP when `isUDPEnabled` is false.
+// `isUDPEnabled` indicates if the proxy and the network support proxying UDP traffic.
 // `tunWriter` is used to output packets back to the TUN device.  OutlineTunnel.Disconnect() will close `tunWriter`.
-func newTunnel(streamDialer transport.StreamDialer, packetDialer transport.PacketListener, isUDPEnabled bool, tunWriter io.WriteCloser) (Tunnel, error) {
+func newTunnel(client *outline.Client, isUDPEnabled bool, tunWriter io.WriteCloser) (Tunnel, error) {
 	if tunWriter == nil {
 		return nil, errors.New("Must provide a TUN writer")
 	}
@@ -67,14 +117,23 @@ func newTunnel(streamDialer transport.StreamDialer, packetDialer transport.Packe
 	})
 	lwipStack := core.NewLWIPStack()
 	base := tunnel.NewTunnel(tunWriter, lwipStack)
-	t := &outlinetunnel{base, lwipStack, streamDialer, packetDialer, isUDPEnabled}
+	t := &outlinetunnel{
+		Tunnel:               base,
+		tunWriter:            tunWriter,
+		lwipStack:            lwipStack,
+		streamDialer:         client.StreamDialer,
+		packetDialer:         client.PacketListener,
+		fallbackPacketDialer: client.FallbackPacketListener,
+		networkChangeHandler: client.NetworkChangeHandler,
+		isUDPEnabled:         isUDPEnabled,
+		checker:              connectivity.NewChecker(),
+	}
 	t.registerConnectionHandlers()
 	return t, nil
 }
 
 func (t *outlinetunnel) UpdateUDPSupport() bool {
-	resolverAddr := &net.UDPAddr{IP: net.ParseIP("1.1.1.1"), Port: 53}
-	isUDPEnabled := connectivity.CheckUDPConnectivityWithDNS(t.packetDialer, resolverAddr) == nil
+	isUDPEnabled := t.connectivityChecker().CheckUDPConnectivity(context.Background(), t.packetDialer) == nil
 	if t.isUDPEnabled != isUDPEnabled {
 		t.isUDPEnabled = isUDPEnabled
 		t.lwipStack.Close() // Close existing connections to avoid using the previous handlers.
@@ -83,12 +142,112 @@ func (t *outlinetunnel) UpdateUDPSupport() bool {
 	return isUDPEnabled
 }
 
+func (t *outlinetunnel) SetConnectivityChecker(checker *connectivity.Checker) {
+	if checker == nil {
+		return
+	}
+	t.checkerMu.Lock()
+	t.checker = checker
+	t.checkerMu.Unlock()
+}
+
+func (t *outlinetunnel) connectivityChecker() *connectivity.Checker {
+	t.checkerMu.Lock()
+	defer t.checkerMu.Unlock()
+	return t.checker
+}
+
+func (t *outlinetunnel) SetMaxUDPPayloadSize(size int) {
+	if size < 0 {
+		size = 0
+	}
+	atomic.StoreInt32(&t.maxUDPPayload, int32(size))
+}
+
+func (t *outlinetunnel) SetIPv6Support(tcp, udp bool) {
+	var rejected int32
+	if !tcp {
+		rejected |= rejectIPv6TCP
+	}
+	if !udp {
+		rejected |= rejectIPv6UDP
+	}
+	atomic.StoreInt32(&t.rejectedIPv6, rejected)
+}
+
+func (t *outlinetunnel) Write(data []byte) (int, error) {
+	if message := t.rejectPacket(data); message != nil && t.IsConnected() {
+		if _, err := t.tunWriter.Write(message); err != nil {
+			return 0, err
+		}
+		return len(data), nil
+	}
+	return t.Tunnel.Write(data)
+}
+
+// rejectPacket returns the ICMP message that answers `packet` instead of relaying it, or nil if
+// it must be relayed.
+func (t *outlinetunnel) rejectPacket(packet []byte) []byte {
+	if rejected := atomic.LoadInt32(&t.rejectedIPv6); rejected != 0 {
+		if message := ipv6Unreachable(packet, rejected); message != nil {
+			return message
+		}
+	}
+	if maxPayload := atomic.LoadInt32(&t.maxUDPPayload); maxPayload > 0 {
+		return packetTooBig(packet, int(maxPayload))
+	}
+	return nil
+}
+
+func (t *outlinetunnel) OnNetworkChanged() bool {
+	if t.networkChangeHandler != nil {
+		t.networkChangeHandler.OnNetworkChanged()
+	}
+	isUDPEnabled := t.UpdateUDPSupport()
+	t.monitorMu.Lock()
+	defer t.monitorMu.Unlock()
+	if t.monitor != nil {
+		t.monitor.CheckNow()
+	}
+	return isUDPEnabled
+}
+
+func (t *outlinetunnel) StartMonitoring(listener connectivity.MonitorListener) {
+	client := &outline.Client{StreamDialer: t.streamDialer, PacketListener: t.packetDialer}
+	monitor := connectivity.NewMonitor(t.connectivityChecker(), client, listener)
+	monitor.Start()
+	t.monitorMu.Lock()
+	previous := t.monitor
+	t.monitor = monitor
+	t.monitorMu.Unlock()
+	if previous != nil {
+		previous.Stop()
+	}
+}
+
+func (t *outlinetunnel) StopMonitoring() {
+	t.monitorMu.Lock()
+	monitor := t.monitor
+	t.monitor = nil
+	t.monitorMu.Unlock()
+	if monitor != nil {
+		monitor.Stop()
+	}
+}
+
+func (t *outlinetunnel) Disconnect() {
+	t.StopMonitoring()
+	t.Tunnel.Disconnect()
+}
+
 // Registers UDP and TCP Shadowsocks connection handlers to the tunnel's host and port.
-// Registers a DNS/TCP fallback UDP handler when UDP is disabled.
+// Registers a UDP-over-TCP or DNS/TCP fallback UDP handler when UDP is disabled.
 func (t *outlinetunnel) registerConnectionHandlers() {
 	var udpHandler core.UDPConnHandler
 	if t.isUDPEnabled {
 		udpHandler = NewUDPHandler(t.packetDialer, 30*time.Second)
+	} else if t.fallbackPacketDialer != nil {
+		udpHandler = NewUDPHandler(t.fallbackPacketDialer, 30*time.Second)
 	} else {
 		udpHandler = dnsfallback.NewUDPHandler()
 	}
@@ -15,9 +15,13 @@
 package tun2socks
 
 import (
+	"errors"
 	"runtime/debug"
 
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline"
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/httpconnect"
 	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/shadowsocks"
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/socks5"
 	"github.com/Jigsaw-Code/outline-go-tun2socks/tunnel"
 	"github.com/eycorsican/go-tun2socks/common/log"
 )
@@ -41,11 +45,34 @@ func init() {
 // Returns an error if the TUN file descriptor cannot be opened, or if the tunnel fails to
 // connect.
 func ConnectShadowsocksTunnel(fd int, client *shadowsocks.Client, isUDPEnabled bool) (Tunnel, error) {
+	return connectTunnel(fd, (*outline.Client)(client), isUDPEnabled)
+}
+
+// ConnectSOCKS5Tunnel reads packets from a TUN device and routes it to a SOCKS5 proxy server,
+// like [ConnectShadowsocksTunnel].
+//
+//   - `client` is the SOCKS5 client (created by [socks5.NewClient]).
+func ConnectSOCKS5Tunnel(fd int, client *socks5.Client, isUDPEnabled bool) (Tunnel, error) {
+	return connectTunnel(fd, (*outline.Client)(client), isUDPEnabled)
+}
+
+// ConnectHTTPProxyTunnel reads packets from a TUN device and routes it to an HTTP proxy
+// server, like [ConnectShadowsocksTunnel]. UDP is not relayed.
+//
+//   - `client` is the HTTP proxy client (created by [httpconnect.NewClient]).
+func ConnectHTTPProxyTunnel(fd int, client *httpconnect.Client, isUDPEnabled bool) (Tunnel, error) {
+	return connectTunnel(fd, (*outline.Client)(client), isUDPEnabled)
+}
+
+func connectTunnel(fd int, client *outline.Client, isUDPEnabled bool) (Tunnel, error) {
+	if client == nil {
+		return nil, errors.New("must provide a client")
+	}
 	tun, err := tunnel.MakeTunFile(fd)
 	if err != nil {
 		return nil, err
 	}
-	t, err := newTunnel(client, client, isUDPEnabled, tun)
+	t, err := newTunnel(client, isUDPEnabled, tun)
 	if err != nil {
 		return nil, err
 	}
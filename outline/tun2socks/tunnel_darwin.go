@@ -20,7 +20,10 @@ import (
 	"runtime/debug"
 	"time"
 
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline"
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/httpconnect"
 	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/shadowsocks"
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/socks5"
 )
 
 // TunWriter is an interface that allows for outputting packets to the TUN (VPN).
@@ -56,5 +59,31 @@ func ConnectShadowsocksTunnel(tunWriter TunWriter, client *shadowsocks.Client, i
 	} else if client == nil {
 		return nil, errors.New("must provide a client")
 	}
-	return newTunnel(client, client, isUDPEnabled, tunWriter)
+	return newTunnel((*outline.Client)(client), isUDPEnabled, tunWriter)
+}
+
+// ConnectSOCKS5Tunnel reads packets from a TUN device and routes it to a SOCKS5 proxy server,
+// like [ConnectShadowsocksTunnel].
+//
+// `client` is the SOCKS5 client (created by [socks5.NewClient]).
+func ConnectSOCKS5Tunnel(tunWriter TunWriter, client *socks5.Client, isUDPEnabled bool) (Tunnel, error) {
+	if tunWriter == nil {
+		return nil, errors.New("must provide a TunWriter")
+	} else if client == nil {
+		return nil, errors.New("must provide a client")
+	}
+	return newTunnel((*outline.Client)(client), isUDPEnabled, tunWriter)
+}
+
+// ConnectHTTPProxyTunnel reads packets from a TUN device and routes it to an HTTP proxy
+// server, like [ConnectShadowsocksTunnel]. UDP is not relayed.
+//
+// `client` is the HTTP proxy client (created by [httpconnect.NewClient]).
+func ConnectHTTPProxyTunnel(tunWriter TunWriter, client *httpconnect.Client, isUDPEnabled bool) (Tunnel, error) {
+	if tunWriter == nil {
+		return nil, errors.New("must provide a TunWriter")
+	} else if client == nil {
+		return nil, errors.New("must provide a client")
+	}
+	return newTunnel((*outline.Client)(client), isUDPEnabled, tunWriter)
 }
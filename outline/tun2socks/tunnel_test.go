@@ -0,0 +1,48 @@
+// Copyright 2023 The Outline Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tun2socks
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/Jigsaw-Code/outline-go-tun2socks/outline/connectivity"
+)
+
+func TestSetConnectivityChecker(t *testing.T) {
+	initial := connectivity.NewChecker()
+	tunnel := &outlinetunnel{checker: initial}
+	tunnel.SetConnectivityChecker(nil)
+	if tunnel.connectivityChecker() != initial {
+		t.Error("A nil checker must be ignored")
+	}
+
+	// Run with -race to detect unsynchronized accesses.
+	var wg sync.WaitGroup
+	checker := connectivity.NewChecker()
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		tunnel.SetConnectivityChecker(checker)
+	}()
+	go func() {
+		defer wg.Done()
+		tunnel.connectivityChecker()
+	}()
+	wg.Wait()
+	if tunnel.connectivityChecker() != checker {
+		t.Error("Checker not replaced")
+	}
+}